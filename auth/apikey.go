@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// APIKeyHeader is the request header carrying the API key.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeys authenticates static API keys, by caller name.
+type APIKeys map[string]string
+
+func (k APIKeys) Authenticate(r *http.Request) (*Identity, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	// every key is compared, so the time does not depend on the matching one
+	var subject string
+	for name, k := range k {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			subject = name
+		}
+	}
+
+	if subject == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return &Identity{
+		Subject: subject,
+		Method:  MethodAPIKey,
+	}, nil
+}
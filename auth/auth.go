@@ -0,0 +1,103 @@
+// Package auth authenticates the callers of the API with static API keys or
+// JWT bearer tokens.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrNoCredentials is returned when the request carries no credentials
+	// handled by the authenticator.
+	ErrNoCredentials = errors.New("credentials are required")
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	ErrInvalidToken  = errors.New("invalid token")
+)
+
+// Authentication methods.
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	// Subject identifies the caller within its authentication method.
+	Subject string
+	Method  string
+}
+
+// Owner returns the subject namespaced by the authentication method, as
+// stored on the jobs of the caller: an API key name and a JWT subject never
+// match each other.
+func (id *Identity) Owner() string {
+	switch id.Method {
+	case MethodAPIKey:
+		return "key:" + id.Subject
+	case MethodJWT:
+		return "jwt:" + id.Subject
+	}
+
+	return id.Method + ":" + id.Subject
+}
+
+// Authenticator returns the identity of the caller of a request, or
+// ErrNoCredentials when the request has no credentials it can check.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Chain tries each authenticator in turn, until one of them finds credentials.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Identity, error) {
+	for _, a := range c {
+		id, err := a.Authenticate(r)
+		if err == ErrNoCredentials {
+			continue
+		}
+
+		return id, err
+	}
+
+	return nil, ErrNoCredentials
+}
+
+type contextKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity attached by the middleware, nil when the
+// request is anonymous.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+
+	return id
+}
+
+// Middleware attaches the identity of the caller to the request context.
+// Anonymous requests are passed through, handlers decide whether an identity
+// is required; requests with invalid credentials are rejected by onError.
+func Middleware(a Authenticator, onError func(w http.ResponseWriter, err error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := a.Authenticate(r)
+			if err == ErrNoCredentials {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if err != nil {
+				onError(w, err)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+		})
+	}
+}
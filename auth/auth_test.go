@@ -0,0 +1,82 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeys(t *testing.T) {
+	keys := auth.APIKeys{"backend": "key1", "admin": "key2"}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	_, err := keys.Authenticate(req)
+	require.Equal(t, auth.ErrNoCredentials, err)
+
+	req.Header.Set(auth.APIKeyHeader, "key2")
+	id, err := keys.Authenticate(req)
+	require.NoError(t, err)
+	require.Equal(t, &auth.Identity{Subject: "admin", Method: auth.MethodAPIKey}, id)
+
+	req.Header.Set(auth.APIKeyHeader, "key3")
+	_, err = keys.Authenticate(req)
+	require.Equal(t, auth.ErrInvalidAPIKey, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	chain := auth.Chain{
+		auth.APIKeys{"backend": "key1"},
+		&auth.JWT{Secret: []byte("secret"), Now: func() time.Time { return now }},
+	}
+
+	var identity *auth.Identity
+	handler := auth.Middleware(chain, func(w http.ResponseWriter, err error) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = auth.FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		code     int
+		identity *auth.Identity
+	}{
+		{"anonymous", "", "", http.StatusOK, nil},
+		{"api key", auth.APIKeyHeader, "key1", http.StatusOK, &auth.Identity{Subject: "backend", Method: auth.MethodAPIKey}},
+		{"jwt", "Authorization", "Bearer " + hs256(t, "secret", claims(nil)), http.StatusOK, &auth.Identity{Subject: "artist", Method: auth.MethodJWT}},
+		{"invalid api key", auth.APIKeyHeader, "key2", http.StatusUnauthorized, nil},
+		{"invalid jwt", "Authorization", "Bearer " + hs256(t, "other", claims(nil)), http.StatusUnauthorized, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity = nil
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.code, rec.Code)
+			require.Equal(t, tt.identity, identity)
+		})
+	}
+}
+
+func TestIdentityOwner(t *testing.T) {
+	key := &auth.Identity{Subject: "artist", Method: auth.MethodAPIKey}
+	token := &auth.Identity{Subject: "artist", Method: auth.MethodJWT}
+
+	require.Equal(t, "key:artist", key.Owner())
+	require.Equal(t, "jwt:artist", token.Owner())
+	require.NotEqual(t, key.Owner(), token.Owner())
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+)
+
+// JWKS holds the RSA public keys of a JSON Web Key Set, by key id.
+type JWKS struct {
+	keys map[string]*rsa.PublicKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// LoadJWKS reads the key set stored in path, keys which are not RSA signing
+// keys are ignored.
+func LoadJWKS(path string) (*JWKS, error) {
+	bz, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseJWKS(bz)
+}
+
+func ParseJWKS(bz []byte) (*JWKS, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(bz, &set); err != nil {
+		return nil, fmt.Errorf("cannot decode jwks: %w", err)
+	}
+
+	jwks := &JWKS{keys: make(map[string]*rsa.PublicKey)}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") || (k.Alg != "" && k.Alg != "RS256") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: invalid modulus", k.Kid)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: invalid exponent", k.Kid)
+		}
+
+		jwks.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(jwks.keys) == 0 {
+		return nil, fmt.Errorf("jwks has no RS256 key")
+	}
+
+	return jwks, nil
+}
+
+// Key returns the key with the given id. Tokens without a key id are accepted
+// when the set has a single key.
+func (s *JWKS) Key(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+
+	k, ok := s.keys[kid]
+
+	return k, ok
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// leeway is the clock skew tolerated on the token times.
+const leeway = 30 * time.Second
+
+// JWT authenticates bearer tokens signed with HS256, using Secret, or RS256,
+// using the keys of a JWKS. The subject of the token is the caller identity.
+type JWT struct {
+	Secret []byte
+	Keys   *JWKS
+	// Issuer and Audience, when set, must match the token claims.
+	Issuer   string
+	Audience string
+
+	// Now returns the current time, time.Now when nil.
+	Now func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Claims are the registered claims of a token checked by JWT.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// audience is either a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(bz []byte) error {
+	var single string
+	if err := json.Unmarshal(bz, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(bz, &many); err != nil {
+		return err
+	}
+	*a = many
+
+	return nil
+}
+
+func (j *JWT) Authenticate(r *http.Request) (*Identity, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := j.Verify(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Subject: claims.Subject,
+		Method:  MethodJWT,
+	}, nil
+}
+
+// Verify checks the signature and the claims of the token.
+func (j *JWT) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256":
+		if len(j.Secret) == 0 {
+			return nil, fmt.Errorf("%w: HS256 is not accepted", ErrInvalidToken)
+		}
+
+		mac := hmac.New(sha256.New, j.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, ErrInvalidToken
+		}
+
+	case "RS256":
+		if j.Keys == nil {
+			return nil, fmt.Errorf("%w: RS256 is not accepted", ErrInvalidToken)
+		}
+
+		key, ok := j.Keys.Key(header.Kid)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, header.Kid)
+		}
+
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, ErrInvalidToken
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err := j.checkClaims(claims); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+
+	return &claims, nil
+}
+
+func (j *JWT) checkClaims(claims Claims) error {
+	now := time.Now()
+	if j.Now != nil {
+		now = j.Now()
+	}
+
+	if claims.Subject == "" {
+		return fmt.Errorf("subject is required")
+	}
+
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("expiration is required")
+	}
+
+	if now.Add(-leeway).After(time.Unix(claims.ExpiresAt, 0)) {
+		return fmt.Errorf("token has expired")
+	}
+
+	if claims.NotBefore != 0 && now.Add(leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+
+	if j.Issuer != "" && claims.Issuer != j.Issuer {
+		return fmt.Errorf("unexpected issuer")
+	}
+
+	if j.Audience != "" {
+		for _, aud := range claims.Audience {
+			if aud == j.Audience {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("unexpected audience")
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	bz, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bz, v)
+}
@@ -0,0 +1,155 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/auth"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Unix(1600000000, 0)
+
+func segment(t *testing.T, v interface{}) string {
+	bz, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	return base64.RawURLEncoding.EncodeToString(bz)
+}
+
+func hs256(t *testing.T, secret string, claims map[string]interface{}) string {
+	signed := segment(t, map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + segment(t, claims)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func rs256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := segment(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + segment(t, claims)
+
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func jwks(t *testing.T, kid string, key *rsa.PublicKey) *auth.JWKS {
+	set := fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":%q,"use":"sig","alg":"RS256","n":%q,"e":%q},{"kty":"EC","kid":"ec"}]}`,
+		kid,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	)
+
+	keys, err := auth.ParseJWKS([]byte(set))
+	require.NoError(t, err)
+
+	return keys
+}
+
+func claims(extra map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{
+		"sub": "artist",
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for k, v := range extra {
+		c[k] = v
+	}
+
+	return c
+}
+
+func TestJWTHS256(t *testing.T) {
+	j := &auth.JWT{
+		Secret:   []byte("secret"),
+		Issuer:   "backend",
+		Audience: "bitsongms",
+		Now:      func() time.Time { return now },
+	}
+
+	valid := map[string]interface{}{"iss": "backend", "aud": []string{"web", "bitsongms"}}
+
+	tests := []struct {
+		name  string
+		token string
+		err   bool
+	}{
+		{"valid", hs256(t, "secret", claims(valid)), false},
+		{"single audience", hs256(t, "secret", claims(map[string]interface{}{"iss": "backend", "aud": "bitsongms"})), false},
+		{"wrong secret", hs256(t, "other", claims(valid)), true},
+		{"expired", hs256(t, "secret", claims(map[string]interface{}{"iss": "backend", "aud": "bitsongms", "exp": now.Add(-time.Hour).Unix()})), true},
+		{"not valid yet", hs256(t, "secret", claims(map[string]interface{}{"iss": "backend", "aud": "bitsongms", "nbf": now.Add(time.Hour).Unix()})), true},
+		{"no expiration", hs256(t, "secret", map[string]interface{}{"sub": "artist", "iss": "backend", "aud": "bitsongms"}), true},
+		{"no subject", hs256(t, "secret", map[string]interface{}{"exp": now.Add(time.Hour).Unix(), "iss": "backend", "aud": "bitsongms"}), true},
+		{"wrong issuer", hs256(t, "secret", claims(map[string]interface{}{"iss": "other", "aud": "bitsongms"})), true},
+		{"wrong audience", hs256(t, "secret", claims(map[string]interface{}{"iss": "backend", "aud": "web"})), true},
+		{"alg none", segment(t, map[string]string{"alg": "none"}) + "." + segment(t, claims(valid)) + ".", true},
+		{"malformed", "not.a-token", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			id, err := j.Authenticate(req)
+			if tt.err {
+				require.Error(t, err)
+				require.True(t, errors.Is(err, auth.ErrInvalidToken))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, &auth.Identity{Subject: "artist", Method: auth.MethodJWT}, id)
+		})
+	}
+}
+
+func TestJWTRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	j := &auth.JWT{
+		Keys: jwks(t, "key1", &key.PublicKey),
+		Now:  func() time.Time { return now },
+	}
+
+	c, err := j.Verify(rs256(t, key, "key1", claims(nil)))
+	require.NoError(t, err)
+	require.Equal(t, "artist", c.Subject)
+
+	// the only key is used when the token has no key id
+	_, err = j.Verify(rs256(t, key, "", claims(nil)))
+	require.NoError(t, err)
+
+	_, err = j.Verify(rs256(t, key, "key2", claims(nil)))
+	require.Error(t, err)
+
+	_, err = j.Verify(rs256(t, other, "key1", claims(nil)))
+	require.Error(t, err)
+
+	// HS256 is refused without a secret, the public key cannot be used as one
+	_, err = j.Verify(hs256(t, string(key.PublicKey.N.Bytes()), claims(nil)))
+	require.Error(t, err)
+}
+
+func TestParseJWKSWithoutRSAKey(t *testing.T) {
+	_, err := auth.ParseJWKS([]byte(`{"keys":[{"kty":"EC","kid":"ec"}]}`))
+	require.Error(t, err)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"github.com/angelorc/go-uploader/auth"
+	"github.com/angelorc/go-uploader/config"
+)
+
+// newAuthenticator returns the authenticator of the API callers, nil when
+// authentication is not configured.
+func newAuthenticator(cfg config.Auth) (auth.Authenticator, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	var chain auth.Chain
+
+	if len(cfg.APIKeys) > 0 {
+		chain = append(chain, auth.APIKeys(cfg.APIKeys))
+	}
+
+	if cfg.JWT.Enabled() {
+		jwt := &auth.JWT{
+			Issuer:   cfg.JWT.Issuer,
+			Audience: cfg.JWT.Audience,
+		}
+
+		if cfg.JWT.Secret != "" {
+			jwt.Secret = []byte(cfg.JWT.Secret)
+		}
+
+		if cfg.JWT.JWKSFile != "" {
+			keys, err := auth.LoadJWKS(cfg.JWT.JWKSFile)
+			if err != nil {
+				return nil, err
+			}
+			jwt.Keys = keys
+		}
+
+		chain = append(chain, jwt)
+	}
+
+	return chain, nil
+}
@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"github.com/angelorc/go-uploader/models"
-	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/queue"
+	"github.com/angelorc/go-uploader/services"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	"github.com/rs/zerolog"
@@ -11,6 +13,7 @@ import (
 	"github.com/spf13/cobra"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/angelorc/go-uploader/server"
@@ -20,7 +23,9 @@ const (
 	logLevelJSON = "json"
 	logLevelText = "text"
 	dbPath       = ".bitsongms"
-	listenAddr   = "127.0.0.1:8081"
+
+	// eventsRetention is how long the events of a finished job can be resumed.
+	eventsRetention = 10 * time.Minute
 )
 
 var (
@@ -36,6 +41,7 @@ var rootCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(getStartCmd())
 	rootCmd.AddCommand(getVersionCmd())
+	rootCmd.AddCommand(getGcCmd())
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -59,74 +65,89 @@ func getStartCmd() *cobra.Command {
 
 			zerolog.SetGlobalLevel(logLvl)
 
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
 			if _, err := os.Stat(dbPath); os.IsNotExist(err) {
 				if err := os.Mkdir(dbPath, os.ModePerm); err != nil {
 					return err
 				}
 			}
 
-			// make a queue with a capacity of 1 transcoder.
-			queue := make(chan *transcoder.Transcoder, 1)
+			// open the persistent queue, buffering up to queue size jobs in memory.
+			q, err := queue.Open(filepath.Join(dbPath, "queue"), cfg.Transcoder.QueueSize)
+			if err != nil {
+				return err
+			}
+			defer q.Close()
 
-			go func() {
-				for q := range queue {
-					doTranscode(q)
-				}
-			}()
+			ipfs := services.NewIpfs(cfg.Ipfs.Endpoint)
+			store := newStorage(cfg.Storage, ipfs)
+			broker := events.NewBroker(eventsRetention)
+
+			for i := 0; i < cfg.Transcoder.Workers; i++ {
+				w := newWorker(i, q, ipfs, store, broker, cfg)
+				go w.run()
+			}
+
+			log.Info().Int("workers", cfg.Transcoder.Workers).Int("threads", cfg.Transcoder.Threads).Msg("transcode workers started")
+
+			// re-enqueue the jobs accepted before the last shutdown, once the
+			// workers run as it blocks while the queue is full
+			recovered, err := q.Recover()
+			if err != nil {
+				return err
+			}
+
+			if recovered > 0 {
+				log.Info().Int("jobs", recovered).Msg("recovered pending transcode jobs")
+			}
+
+			if cfg.Janitor.Interval > 0 {
+				go runJanitor(newJanitor(cfg.Janitor), cfg.Janitor.Interval)
+			}
+
+			authn, err := newAuthenticator(cfg.Auth)
+			if err != nil {
+				return err
+			}
+
+			if authn == nil {
+				log.Warn().Msg("authentication is disabled, anyone reaching the server can use the API")
+			}
 
 			// create HTTP router and mount routes
 			router := mux.NewRouter()
 			c := cors.New(cors.Options{
-				AllowedOrigins: []string{"*"},
+				AllowedOrigins: cfg.CORSOrigins,
+				AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodDelete},
+				AllowedHeaders: []string{"*"},
+				ExposedHeaders: append(append([]string{}, server.TusHeaders...), server.StreamHeaders...),
 			})
 
-			server.RegisterRoutes(router, queue)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			server.RegisterRoutes(ctx, router, q, broker, store, authn, cfg)
 
+			// no write timeout, it would end the event streams, which are
+			// only ended by the terminal events or the clients
 			srv := &http.Server{
-				Handler:      c.Handler(router),
-				Addr:         listenAddr,
-				WriteTimeout: 15 * time.Second,
-				ReadTimeout:  15 * time.Second,
+				Handler:     c.Handler(router),
+				Addr:        cfg.ListenAddr,
+				ReadTimeout: 15 * time.Second,
 			}
 
-			log.Info().Str("address", listenAddr).Msg("starting API server...")
+			log.Info().Str("address", cfg.ListenAddr).Msg("starting API server...")
 			return srv.ListenAndServe()
 		},
 	}
 
 	startCmd.Flags().StringVar(&logLevel, "log-level", zerolog.InfoLevel.String(), "logging level")
 	startCmd.Flags().StringVar(&logFormat, "log-format", logLevelJSON, "logging format; must be either json or text")
+	registerConfigFlags(startCmd)
 
 	return startCmd
 }
-
-func doTranscode(audio *transcoder.Transcoder) {
-	tm := &models.Transcoder{
-		ID:         audio.Id,
-	}
-
-	tm.UpdatePercentage(20)
-	// Convert to mp3
-	log.Info().Str("filename", audio.Uploader.Header.Filename).Msg("starting conversion to mp3")
-
-	if err := audio.TranscodeToMp3(); err != nil {
-		log.Error().Str("filename", audio.Uploader.Header.Filename).Msg("failed to transcode")
-		return
-	}
-
-	tm.UpdatePercentage(50)
-
-	// check size compared to original
-
-	// spilt mp3 to segments
-	log.Info().Str("filename", audio.Uploader.Header.Filename).Msg("starting splitting to segments")
-
-	if err := audio.SplitToSegments(); err != nil {
-		log.Error().Str("filename", audio.Uploader.Header.Filename).Msg("failed to split")
-		return
-	}
-
-	tm.UpdatePercentage(100)
-
-	log.Info().Str("filename", audio.Uploader.Header.Filename).Msg("transcode completed")
-}
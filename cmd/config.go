@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/angelorc/go-uploader/config"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagConfig       = "config"
+	flagListenAddr   = "listen-addr"
+	flagWorkers      = "workers"
+	flagQueueSize    = "queue-size"
+	flagThreads      = "threads"
+	flagIpfsEndpoint = "ipfs-endpoint"
+	flagIpfsGateway  = "ipfs-gateway"
+	flagMaxUpload    = "max-upload-size"
+)
+
+var (
+	configFile string
+	// flagCfg holds the values of the config flags, they override the
+	// config file only when explicitly set.
+	flagCfg = config.DefaultConfig()
+)
+
+func registerConfigFlags(cmd *cobra.Command) {
+	registerConfigFileFlag(cmd)
+	cmd.Flags().StringVar(&flagCfg.ListenAddr, flagListenAddr, flagCfg.ListenAddr, "address the API server listens on")
+	cmd.Flags().IntVar(&flagCfg.Transcoder.Workers, flagWorkers, flagCfg.Transcoder.Workers, "number of jobs transcoded concurrently")
+	cmd.Flags().IntVar(&flagCfg.Transcoder.QueueSize, flagQueueSize, flagCfg.Transcoder.QueueSize, "number of jobs waiting for a worker before uploads are rejected")
+	cmd.Flags().IntVar(&flagCfg.Transcoder.Threads, flagThreads, flagCfg.Transcoder.Threads, "ffmpeg threads used by each job; 0 lets ffmpeg decide")
+	cmd.Flags().StringVar(&flagCfg.Ipfs.Endpoint, flagIpfsEndpoint, flagCfg.Ipfs.Endpoint, "ipfs HTTP API endpoint used to publish segments")
+	cmd.Flags().StringVar(&flagCfg.Ipfs.Gateway, flagIpfsGateway, flagCfg.Ipfs.Gateway, "ipfs gateway prefix written in the published playlist; empty writes bare CIDs")
+	cmd.Flags().Int64Var(&flagCfg.Uploads.MaxSize, flagMaxUpload, flagCfg.Uploads.MaxSize, "largest uploaded file in bytes")
+}
+
+func registerConfigFileFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&configFile, flagConfig, filepath.Join(dbPath, "config.yaml"), "path of the yaml config file, ignored when missing")
+}
+
+// loadConfig loads the config file, when it exists, and applies the flags
+// explicitly set on the command line.
+func loadConfig(cmd *cobra.Command) (config.Config, error) {
+	cfg := config.DefaultConfig()
+
+	if _, err := os.Stat(configFile); err == nil || cmd.Flags().Changed(flagConfig) {
+		cfg, err = config.Load(configFile)
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	flags := cmd.Flags()
+
+	if flags.Changed(flagListenAddr) {
+		cfg.ListenAddr = flagCfg.ListenAddr
+	}
+
+	if flags.Changed(flagWorkers) {
+		cfg.Transcoder.Workers = flagCfg.Transcoder.Workers
+	}
+
+	if flags.Changed(flagQueueSize) {
+		cfg.Transcoder.QueueSize = flagCfg.Transcoder.QueueSize
+	}
+
+	if flags.Changed(flagThreads) {
+		cfg.Transcoder.Threads = flagCfg.Transcoder.Threads
+	}
+
+	if flags.Changed(flagIpfsEndpoint) {
+		cfg.Ipfs.Endpoint = flagCfg.Ipfs.Endpoint
+	}
+
+	if flags.Changed(flagIpfsGateway) {
+		cfg.Ipfs.Gateway = flagCfg.Ipfs.Gateway
+	}
+
+	if flags.Changed(flagMaxUpload) {
+		cfg.Uploads.MaxSize = flagCfg.Uploads.MaxSize
+	}
+
+	return cfg, cfg.Validate()
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/janitor"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/services"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+const flagDryRun = "dry-run"
+
+var gcDryRun bool
+
+// newJanitor returns the janitor of the upload working directories, the jobs
+// are looked up in mongo.
+func newJanitor(cfg config.Janitor) *janitor.Janitor {
+	policy := janitor.Policy{
+		FailedRetention: cfg.FailedRetention,
+		StaleRetention:  cfg.StaleRetention,
+		HighWater:       cfg.HighWater,
+	}
+
+	return janitor.New(services.UploadsDir, policy, models.FindByUploadID)
+}
+
+// runJanitor runs the janitor every interval, it never returns.
+func runJanitor(j *janitor.Janitor, interval time.Duration) {
+	for range time.Tick(interval) {
+		report, err := j.Run(false)
+		if err != nil {
+			log.Error().Err(err).Msg("janitor run failed")
+		}
+
+		for _, r := range report.Removed {
+			log.Info().Str("upload", r.UploadID).Str("reason", r.Reason).Int64("size", r.Size).Msg("working directory removed")
+		}
+
+		log.Info().Int64("usage", report.Usage).Int64("freed", report.Freed).Int("removed", len(report.Removed)).Msg("janitor run completed")
+	}
+}
+
+func getGcCmd() *cobra.Command {
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove the working directories of the uploads which are no longer needed",
+		Long: `Remove the working directories of the done jobs, of the failed jobs older than
+the failed retention and of the uploads without job idle for longer than the
+stale retention. Above the high-water mark the failed jobs and idle uploads
+are removed before their retention, oldest first.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			report, err := newJanitor(cfg.Janitor).Run(gcDryRun)
+
+			verb := "removed"
+			if gcDryRun {
+				verb = "would remove"
+			}
+
+			for _, r := range report.Removed {
+				fmt.Printf("%s %s (%s, %d bytes, last written %s)\n", verb, r.UploadID, r.Reason, r.Size, r.ModTime.Format(time.RFC3339))
+			}
+
+			fmt.Printf("%s %d directories, %d of %d bytes\n", verb, len(report.Removed), report.Freed, report.Usage)
+
+			return err
+		},
+	}
+
+	gcCmd.Flags().BoolVar(&gcDryRun, flagDryRun, false, "print the directories to remove without removing them")
+	registerConfigFileFlag(gcCmd)
+
+	return gcCmd
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/rs/zerolog"
+)
+
+// progressInterval is the minimum delay between two progress updates stored
+// in mongo for the same job, stage completions are always stored.
+const progressInterval = 2 * time.Second
+
+// stageWeights is the share of the overall percentage of every stage, in
+// the order they are run.
+var stageWeights = []struct {
+	stage  string
+	weight int
+}{
+	{transcoder.StageAnalyze, 10},
+	{transcoder.StageTranscode, 35},
+	{transcoder.StageWaveform, 5},
+	{transcoder.StageSegment, 30},
+	{transcoder.StagePublish, 20},
+}
+
+// progress publishes the progress reported by the transcoder to the broker
+// and throttles it before storing it in the transcoder document.
+type progress struct {
+	tm     *models.Transcoder
+	broker *events.Broker
+	logger zerolog.Logger
+
+	mu         sync.Mutex
+	stages     map[string]int
+	lastUpdate time.Time
+}
+
+func newProgress(tm *models.Transcoder, broker *events.Broker, logger zerolog.Logger) *progress {
+	return &progress{
+		tm:     tm,
+		broker: broker,
+		logger: logger,
+		stages: make(map[string]int),
+	}
+}
+
+// update records the percentage of the stage, it implements transcoder.ProgressFunc.
+func (p *progress) update(stage string, percentage int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if last, ok := p.stages[stage]; ok && percentage <= last {
+		return
+	}
+
+	p.stages[stage] = percentage
+
+	p.broker.Publish(p.tm.ID.Hex(), events.Event{
+		Type:            events.TypeProgress,
+		Status:          models.StatusRunning,
+		Percentage:      p.overall(),
+		Stage:           stage,
+		StagePercentage: percentage,
+	})
+
+	if percentage < 100 && time.Since(p.lastUpdate) < progressInterval {
+		return
+	}
+
+	p.lastUpdate = time.Now()
+
+	if err := p.tm.UpdateProgress(stage, percentage, p.overall()); err != nil {
+		p.logger.Error().Err(err).Str("stage", stage).Msg("failed to update progress")
+	}
+}
+
+func (p *progress) overall() int {
+	var total int
+	for _, sw := range stageWeights {
+		total += p.stages[sw.stage] * sw.weight
+	}
+
+	return total / 100
+}
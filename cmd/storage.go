@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/services"
+	"github.com/angelorc/go-uploader/storage"
+)
+
+// newStorage returns the storage of the transcoder outputs selected by the
+// config, the ipfs backend uses the node of the publisher.
+func newStorage(cfg config.Storage, ipfs *services.Ipfs) storage.Storage {
+	switch cfg.Backend {
+	case storage.BackendS3:
+		s3 := cfg.S3
+		return storage.NewS3(s3.Endpoint, s3.Region, s3.Bucket, s3.AccessKey, s3.SecretKey, s3.PathStyle)
+
+	case storage.BackendIpfs:
+		return storage.NewIpfs(ipfs, cfg.Ipfs.Root)
+
+	default:
+		return storage.NewLocal(cfg.Local.Root)
+	}
+}
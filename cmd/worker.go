@@ -0,0 +1,233 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/queue"
+	"github.com/angelorc/go-uploader/services"
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// worker transcodes the jobs received from the queue, one at a time.
+type worker struct {
+	q      *queue.Queue
+	ipfs   *services.Ipfs
+	store  storage.Storage
+	broker *events.Broker
+	cfg    config.Config
+	logger zerolog.Logger
+}
+
+func newWorker(id int, q *queue.Queue, ipfs *services.Ipfs, store storage.Storage, broker *events.Broker, cfg config.Config) *worker {
+	return &worker{
+		q:      q,
+		ipfs:   ipfs,
+		store:  store,
+		broker: broker,
+		cfg:    cfg,
+		logger: log.With().Int("worker", id).Logger(),
+	}
+}
+
+func (w *worker) run() {
+	for job := range w.q.Jobs() {
+		w.doTranscode(job)
+	}
+}
+
+func (w *worker) doTranscode(job *queue.Job) {
+	id, err := primitive.ObjectIDFromHex(job.ID)
+	if err != nil {
+		w.logger.Error().Err(err).Str("job", job.ID).Msg("invalid transcode job")
+		w.setJobState(job, queue.StateFailed)
+		return
+	}
+
+	tm := &models.Transcoder{
+		ID: id,
+	}
+
+	audio, err := w.newJobTranscoder(job, id)
+	if err != nil {
+		w.fail(job, tm, "invalid transcode job", err)
+		return
+	}
+
+	w.setJobState(job, queue.StateRunning)
+
+	if err := tm.Start(); err != nil {
+		w.logger.Error().Err(err).Str("job", job.ID).Msg("failed to mark job as running")
+	}
+
+	w.broker.Publish(job.ID, events.Event{
+		Type:   events.TypeStatus,
+		Status: models.StatusRunning,
+	})
+
+	audio.OnProgress = newProgress(tm, w.broker, w.logger).update
+
+	// measure the loudness, normalized by the conversion when the profile has a target
+	w.logger.Info().Str("filename", audio.Uploader.Header.Filename).Msg("measuring loudness")
+
+	err = audio.MeasureLoudness()
+	if err == transcoder.ErrSilentAudio {
+		w.logger.Warn().Str("job", job.ID).Msg("audio is silent, loudness is not measured")
+	} else if err != nil {
+		w.fail(job, tm, "failed to measure loudness", err)
+		return
+	}
+
+	// Convert to mp3
+	w.logger.Info().Str("filename", audio.Uploader.Header.Filename).Msg("starting conversion to mp3")
+
+	if err := audio.TranscodeToMp3(); err != nil {
+		w.fail(job, tm, "failed to transcode", err)
+		return
+	}
+
+	if audio.Loudness != nil {
+		if err := tm.UpdateLoudness(*audio.Loudness); err != nil {
+			w.logger.Error().Err(err).Str("job", job.ID).Msg("failed to store loudness")
+		}
+	}
+
+	// compute the waveforms drawn by the players
+	w.logger.Info().Str("filename", audio.Uploader.Header.Filename).Msg("generating waveforms")
+
+	if err := audio.GenerateWaveforms(); err != nil {
+		w.fail(job, tm, "failed to generate waveforms", err)
+		return
+	}
+
+	// tag the mp3 download with the tags read from the job, they may have
+	// been updated since the upload
+	w.logger.Info().Str("filename", audio.Uploader.Header.Filename).Msg("writing mp3 download")
+
+	record, err := tm.Get()
+	if err != nil {
+		w.fail(job, tm, "failed to get job tags", err)
+		return
+	}
+
+	if err := audio.WriteDownload(record.Metadata, record.Tags); err != nil {
+		w.fail(job, tm, "failed to write mp3 download", err)
+		return
+	}
+
+	// check size compared to original
+
+	// spilt mp3 to segments
+	w.logger.Info().Str("filename", audio.Uploader.Header.Filename).Msg("starting splitting to segments")
+
+	if err := audio.SplitToSegments(); err != nil {
+		w.fail(job, tm, "failed to split", err)
+		return
+	}
+
+	// publish segments and playlist to ipfs
+	w.logger.Info().Str("filename", audio.Uploader.Header.Filename).Msg("publishing to ipfs")
+
+	manifests, err := audio.PublishToIpfs(w.ipfs, w.cfg.Ipfs.Gateway)
+	if err != nil {
+		w.fail(job, tm, "failed to publish to ipfs", err)
+		return
+	}
+
+	if err := tm.UpdateManifests(manifests); err != nil {
+		w.logger.Error().Err(err).Str("job", job.ID).Msg("failed to store manifests")
+	}
+
+	if err := tm.Complete(); err != nil {
+		w.logger.Error().Err(err).Str("job", job.ID).Msg("failed to mark job as done")
+	}
+
+	w.broker.Publish(job.ID, events.Event{
+		Type:       events.TypeStatus,
+		Status:     models.StatusDone,
+		Percentage: 100,
+	})
+
+	// the intermediates are not needed once the outputs are stored
+	if err := audio.RemoveFiles(); err != nil {
+		w.logger.Error().Err(err).Str("job", job.ID).Msg("failed to remove intermediate files")
+	}
+
+	w.logger.Info().Str("filename", audio.Uploader.Header.Filename).Msg("transcode completed")
+	w.setJobState(job, queue.StateDone)
+}
+
+// fail records the failure of the job, both in the queue and in the
+// transcoder document returned to the clients.
+func (w *worker) fail(job *queue.Job, tm *models.Transcoder, msg string, err error) {
+	w.logger.Error().Err(err).Str("job", job.ID).Str("filename", job.FileName).Msg(msg)
+
+	var stderr string
+
+	var ffmpegErr *transcoder.FFmpegError
+	if errors.As(err, &ffmpegErr) {
+		stderr = ffmpegErr.Stderr
+	}
+
+	err = fmt.Errorf("%s: %w", msg, err)
+	if err := tm.Fail(err, stderr); err != nil {
+		w.logger.Error().Err(err).Str("job", job.ID).Msg("failed to mark job as failed")
+	}
+
+	// the failed job stores nothing, its partial outputs are removed and
+	// its storage is given back
+	if err := transcoder.DeleteOutputs(w.store, job.ID); err != nil {
+		w.logger.Error().Err(err).Str("job", job.ID).Msg("failed to remove job outputs")
+	}
+
+	if err := tm.ReleaseQuota(); err != nil {
+		w.logger.Error().Err(err).Str("job", job.ID).Msg("failed to release job quota")
+	}
+
+	w.broker.Publish(job.ID, events.Event{
+		Type:   events.TypeStatus,
+		Status: models.StatusFailed,
+		Error:  err.Error(),
+	})
+
+	w.setJobState(job, queue.StateFailed)
+}
+
+// newJobTranscoder rebuilds the transcoder of a persisted job.
+func (w *worker) newJobTranscoder(job *queue.Job, id primitive.ObjectID) (*transcoder.Transcoder, error) {
+	uploader, err := services.RestoreUploader(job.UploadID, job.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	name := job.Profile
+	if name == "" {
+		// jobs queued before the profiles were introduced
+		name = transcoder.DefaultProfileName
+	}
+
+	profile, ok := w.cfg.Transcoder.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %s", name)
+	}
+
+	audio := transcoder.NewTranscoder(uploader, id)
+	audio.Threads = w.cfg.Transcoder.Threads
+	audio.Storage = w.store
+	audio.Profile = profile
+
+	return audio, nil
+}
+
+func (w *worker) setJobState(job *queue.Job, state string) {
+	if err := w.q.SetState(job, state); err != nil {
+		w.logger.Error().Err(err).Str("job", job.ID).Str("state", state).Msg("failed to update job state")
+	}
+}
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/angelorc/go-uploader/services"
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/angelorc/go-uploader/transcoder"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DefaultListenAddr = "127.0.0.1:8081"
+
+	DefaultUploadMaxTTL = 15 * time.Minute
+
+	DefaultJanitorInterval        = time.Hour
+	DefaultJanitorFailedRetention = 72 * time.Hour
+	DefaultJanitorStaleRetention  = 24 * time.Hour
+
+	DefaultStorageRoot     = ".bitsongms/storage"
+	DefaultIpfsStorageRoot = "/bitsongms"
+)
+
+// Config defines the settings of the media server, it is loaded from a yaml
+// file and can be overridden by the command line flags.
+type Config struct {
+	ListenAddr string `yaml:"listen_addr"`
+	// CORSOrigins are the origins allowed to call the API from a browser.
+	CORSOrigins []string   `yaml:"cors_origins"`
+	Transcoder  Transcoder `yaml:"transcoder"`
+	Ipfs        Ipfs       `yaml:"ipfs"`
+	Uploads     Uploads    `yaml:"uploads"`
+	Auth        Auth       `yaml:"auth"`
+	Limits      Limits     `yaml:"limits"`
+	Storage     Storage    `yaml:"storage"`
+	Janitor     Janitor    `yaml:"janitor"`
+}
+
+type Transcoder struct {
+	// Workers is the number of jobs transcoded concurrently.
+	Workers int `yaml:"workers"`
+	// QueueSize is the number of accepted jobs waiting for a worker, uploads
+	// are rejected when the queue is full.
+	QueueSize int `yaml:"queue_size"`
+	// Threads limits the ffmpeg threads used by each job, 0 lets ffmpeg decide.
+	Threads int `yaml:"threads"`
+	// Profiles are the HLS outputs selectable by the jobs, by name. The
+	// configured profiles are added to the default ones.
+	Profiles map[string]transcoder.Profile `yaml:"profiles"`
+}
+
+type Ipfs struct {
+	Endpoint string `yaml:"endpoint"`
+	Gateway  string `yaml:"gateway"`
+}
+
+// Storage selects the backend storing the transcoder outputs, local, s3 or
+// ipfs, and configures it.
+type Storage struct {
+	Backend string       `yaml:"backend"`
+	Local   LocalStorage `yaml:"local"`
+	S3      S3Storage    `yaml:"s3"`
+	Ipfs    IpfsStorage  `yaml:"ipfs"`
+}
+
+type LocalStorage struct {
+	// Root is the directory storing the outputs.
+	Root string `yaml:"root"`
+}
+
+// S3Storage configures an S3-compatible object store, PathStyle addresses
+// the bucket in the path as required by most self-hosted stores.
+type S3Storage struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"`
+}
+
+// IpfsStorage stores the outputs in the mutable file system of the ipfs
+// node configured by Ipfs.Endpoint.
+type IpfsStorage struct {
+	// Root is the directory of the mutable file system storing the outputs.
+	Root string `yaml:"root"`
+}
+
+// Janitor configures the removal of the working directories of the uploads,
+// see janitor.Policy.
+type Janitor struct {
+	// Interval is the delay between two runs of the server, disabled when 0.
+	Interval        time.Duration `yaml:"interval"`
+	FailedRetention time.Duration `yaml:"failed_retention"`
+	StaleRetention  time.Duration `yaml:"stale_retention"`
+	// HighWater is the disk usage of the working directories, in bytes,
+	// unlimited when 0.
+	HighWater int64 `yaml:"high_water"`
+}
+
+// Uploads configures the size of the uploads and the signed upload URLs,
+// which authorize uploads without credentials. When Secret is empty uploads
+// are not signed.
+type Uploads struct {
+	// MaxSize is the largest uploaded file in bytes, larger request bodies are
+	// rejected before being written to disk.
+	MaxSize int64 `yaml:"max_size"`
+	// Secret is the HMAC key of the signed upload URLs.
+	Secret string `yaml:"secret"`
+	// MaxTTL is the longest validity of a signed upload URL.
+	MaxTTL time.Duration `yaml:"max_ttl"`
+}
+
+// Auth configures the authentication of the API callers. When neither API
+// keys nor JWT are configured the API is open to anyone reaching the server.
+type Auth struct {
+	// APIKeys are the static API keys, by caller name.
+	APIKeys map[string]string `yaml:"api_keys"`
+	JWT     JWT               `yaml:"jwt"`
+	// Admins are the callers allowed to manage the quotas, by owner: the API
+	// key name prefixed by key: or the JWT subject prefixed by jwt:.
+	Admins []string `yaml:"admins"`
+}
+
+// JWT configures the bearer tokens, signed with HS256 using Secret or with
+// RS256 using the keys of the JWKS file.
+type JWT struct {
+	Secret   string `yaml:"secret"`
+	JWKSFile string `yaml:"jwks_file"`
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+}
+
+// Limits configures the upload rate and the storage allowed to each caller,
+// identified by its owner or by its address when anonymous.
+type Limits struct {
+	// UploadsPerMinute is the rate of uploads allowed to a caller, unlimited
+	// when 0.
+	UploadsPerMinute float64 `yaml:"uploads_per_minute"`
+	// UploadBurst is the number of uploads a caller can start at once.
+	UploadBurst int `yaml:"upload_burst"`
+	// Quotas enables the storage quotas, tracked in the database.
+	Quotas bool `yaml:"quotas"`
+	// DefaultQuota is the storage allowed to a caller without a quota of its
+	// own, in bytes; unlimited when 0.
+	DefaultQuota int64 `yaml:"default_quota"`
+}
+
+// Enabled reports whether the API callers must authenticate.
+func (a Auth) Enabled() bool {
+	return len(a.APIKeys) > 0 || a.JWT.Enabled()
+}
+
+func (j JWT) Enabled() bool {
+	return j.Secret != "" || j.JWKSFile != ""
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:  DefaultListenAddr,
+		CORSOrigins: []string{"*"},
+		Transcoder: Transcoder{
+			Workers:   1,
+			QueueSize: 10,
+			Threads:   0,
+			Profiles:  defaultProfiles(),
+		},
+		Ipfs: Ipfs{
+			Endpoint: services.IPFS_ENDPOINT,
+			Gateway:  services.IPFS_GATEWAY,
+		},
+		Uploads: Uploads{
+			MaxSize: transcoder.MaxAudioSize(),
+			MaxTTL:  DefaultUploadMaxTTL,
+		},
+		Limits: Limits{
+			UploadBurst: 5,
+		},
+		Storage: Storage{
+			Backend: storage.BackendLocal,
+			Local: LocalStorage{
+				Root: DefaultStorageRoot,
+			},
+			Ipfs: IpfsStorage{
+				Root: DefaultIpfsStorageRoot,
+			},
+		},
+		Janitor: Janitor{
+			Interval:        DefaultJanitorInterval,
+			FailedRetention: DefaultJanitorFailedRetention,
+			StaleRetention:  DefaultJanitorStaleRetention,
+		},
+	}
+}
+
+// defaultProfiles returns a copy of the default transcoder profiles, the
+// config file is decoded into it.
+func defaultProfiles() map[string]transcoder.Profile {
+	profiles := make(map[string]transcoder.Profile, len(transcoder.DefaultProfiles))
+	for name, p := range transcoder.DefaultProfiles {
+		profiles[name] = p
+	}
+
+	return profiles
+}
+
+// Load reads the yaml config file stored in path, missing values are set to
+// their default.
+func Load(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	bz, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := yaml.UnmarshalStrict(bz, &cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func (c Config) Validate() error {
+	if c.Transcoder.Workers < 1 {
+		return fmt.Errorf("transcoder workers must be at least 1")
+	}
+
+	if c.Transcoder.QueueSize < 1 {
+		return fmt.Errorf("transcoder queue size must be at least 1")
+	}
+
+	if c.Transcoder.Threads < 0 {
+		return fmt.Errorf("transcoder threads cannot be negative")
+	}
+
+	if _, ok := c.Transcoder.Profiles[transcoder.DefaultProfileName]; !ok {
+		return fmt.Errorf("transcoder profile %s is required", transcoder.DefaultProfileName)
+	}
+
+	for name, p := range c.Transcoder.Profiles {
+		if err := transcoder.ValidateProfile(p); err != nil {
+			return fmt.Errorf("transcoder profile %s: %w", name, err)
+		}
+	}
+
+	if c.Uploads.Secret != "" && !c.Auth.Enabled() {
+		return fmt.Errorf("auth is required to sign upload URLs")
+	}
+
+	for name, key := range c.Auth.APIKeys {
+		if name == "" || key == "" {
+			return fmt.Errorf("auth api keys must have a name and a key")
+		}
+	}
+
+	if c.Uploads.MaxSize <= 0 {
+		return fmt.Errorf("uploads max size must be positive")
+	}
+
+	if c.Uploads.MaxTTL <= 0 {
+		return fmt.Errorf("uploads max ttl must be positive")
+	}
+
+	if c.Limits.UploadsPerMinute < 0 {
+		return fmt.Errorf("limits uploads per minute cannot be negative")
+	}
+
+	if c.Limits.UploadsPerMinute > 0 && c.Limits.UploadBurst < 1 {
+		return fmt.Errorf("limits upload burst must be at least 1")
+	}
+
+	if c.Limits.DefaultQuota < 0 {
+		return fmt.Errorf("limits default quota cannot be negative")
+	}
+
+	if c.Janitor.Interval < 0 || c.Janitor.FailedRetention < 0 || c.Janitor.StaleRetention < 0 || c.Janitor.HighWater < 0 {
+		return fmt.Errorf("janitor settings cannot be negative")
+	}
+
+	switch c.Storage.Backend {
+	case storage.BackendLocal:
+		if c.Storage.Local.Root == "" {
+			return fmt.Errorf("storage local root is required")
+		}
+
+	case storage.BackendS3:
+		if c.Storage.S3.Endpoint == "" || c.Storage.S3.Bucket == "" {
+			return fmt.Errorf("storage s3 endpoint and bucket are required")
+		}
+
+	case storage.BackendIpfs:
+		if c.Storage.Ipfs.Root == "" {
+			return fmt.Errorf("storage ipfs root is required")
+		}
+
+	default:
+		return fmt.Errorf("unknown storage backend: %s", c.Storage.Backend)
+	}
+
+	return nil
+}
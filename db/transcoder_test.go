@@ -0,0 +1,114 @@
+package db_test
+
+import (
+	"fmt"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestStatusLifecycle(t *testing.T) {
+	transcoder := models.NewTranscoder()
+	err := transcoder.Create()
+	require.NoError(t, err)
+
+	res, err := transcoder.Get()
+	require.NoError(t, err)
+	require.Equal(t, models.StatusQueued, res.Status)
+	require.Nil(t, res.StartedAt)
+
+	err = transcoder.Start()
+	require.NoError(t, err)
+
+	err = transcoder.Fail(fmt.Errorf("failed to transcode"), "Invalid data found when processing input")
+	require.NoError(t, err)
+
+	res, err = transcoder.Get()
+	require.NoError(t, err)
+	require.Equal(t, models.StatusFailed, res.Status)
+	require.Equal(t, "failed to transcode", res.Error)
+	require.Equal(t, "Invalid data found when processing input", res.Stderr)
+	require.Equal(t, 1, res.Attempts)
+	require.NotNil(t, res.StartedAt)
+	require.NotNil(t, res.FinishedAt)
+
+	err = transcoder.Start()
+	require.NoError(t, err)
+
+	err = transcoder.Complete()
+	require.NoError(t, err)
+
+	res, err = transcoder.Get()
+	require.NoError(t, err)
+	require.Equal(t, models.StatusDone, res.Status)
+	require.Equal(t, 100, res.Percentage)
+	require.Equal(t, 2, res.Attempts)
+	require.Empty(t, res.Error)
+	require.Empty(t, res.Stderr)
+
+	err = transcoder.Delete()
+	require.NoError(t, err)
+}
+
+func TestFindDuplicate(t *testing.T) {
+	transcoder := models.NewTranscoder()
+	transcoder.Owner = "backend"
+	transcoder.Profile = "default"
+	transcoder.Sha256 = "f5a5fd42d16a20302798ef6ed309979b43003d2320d9f0e8ea9831a92759fb4b"
+	err := transcoder.Create()
+	require.NoError(t, err)
+
+	upload := models.NewTranscoder()
+	upload.Owner = transcoder.Owner
+	upload.Profile = transcoder.Profile
+	upload.Sha256 = transcoder.Sha256
+
+	// only the done jobs are reused
+	dup, err := upload.FindDuplicate()
+	require.NoError(t, err)
+	require.Nil(t, dup)
+
+	err = transcoder.Complete()
+	require.NoError(t, err)
+
+	dup, err = upload.FindDuplicate()
+	require.NoError(t, err)
+	require.NotNil(t, dup)
+	require.Equal(t, transcoder.ID, dup.ID)
+
+	// nor shared across owners
+	upload.Owner = ""
+	dup, err = upload.FindDuplicate()
+	require.NoError(t, err)
+	require.Nil(t, dup)
+
+	err = transcoder.Delete()
+	require.NoError(t, err)
+}
+
+func TestReleaseQuota(t *testing.T) {
+	quota := models.NewQuota(primitive.NewObjectID().Hex(), 0)
+	err := quota.Reserve(1024)
+	require.NoError(t, err)
+
+	transcoder := models.NewTranscoder()
+	transcoder.QuotaKey = quota.Owner
+	transcoder.Reserved = 1024
+	err = transcoder.Create()
+	require.NoError(t, err)
+
+	// released once, by the worker or the janitor
+	for i := 0; i < 2; i++ {
+		err = transcoder.ReleaseQuota()
+		require.NoError(t, err)
+	}
+
+	res, err := quota.Get()
+	require.NoError(t, err)
+	require.Equal(t, int64(0), res.UsedBytes)
+	require.Equal(t, int64(0), res.Uploads)
+
+	err = transcoder.Delete()
+	require.NoError(t, err)
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/angelorc/go-uploader/models"
+)
+
+const (
+	TypeStatus   = "status"
+	TypeProgress = "progress"
+
+	// historySize is the number of events kept for each job to let the
+	// clients resume a stream.
+	historySize = 512
+	// subscriberBuffer is the number of events buffered for a subscriber,
+	// slow subscribers are dropped and must resume the stream.
+	subscriberBuffer = 64
+)
+
+// Event is a status or progress change of a transcode job.
+type Event struct {
+	ID              uint64 `json:"-"`
+	Type            string `json:"-"`
+	Status          string `json:"status"`
+	Percentage      int    `json:"percentage"`
+	Stage           string `json:"stage,omitempty"`
+	StagePercentage int    `json:"stage_percentage,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// Terminal reports whether the event ends the stream of the job.
+func (e Event) Terminal() bool {
+	return e.Type == TypeStatus && (e.Status == models.StatusDone || e.Status == models.StatusFailed)
+}
+
+type stream struct {
+	lastID      uint64
+	history     []Event
+	subscribers map[chan Event]struct{}
+	finished    bool
+}
+
+// Broker dispatches the events of the transcode jobs to the subscribers. The
+// events of a finished job are kept for the retention delay.
+type Broker struct {
+	retention time.Duration
+
+	mu      sync.Mutex
+	streams map[string]*stream
+}
+
+func NewBroker(retention time.Duration) *Broker {
+	return &Broker{
+		retention: retention,
+		streams:   make(map[string]*stream),
+	}
+}
+
+// Publish assigns the next id of the job stream to the event and sends it to
+// every subscriber.
+func (b *Broker) Publish(jobID string, e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.streams[jobID]
+	if !ok || s.finished {
+		// a retried job starts a new stream, ids keep growing
+		var lastID uint64
+		if ok {
+			lastID = s.lastID
+		}
+
+		s = &stream{
+			lastID:      lastID,
+			subscribers: make(map[chan Event]struct{}),
+		}
+		b.streams[jobID] = s
+	}
+
+	s.lastID++
+	e.ID = s.lastID
+
+	s.history = append(s.history, e)
+	if len(s.history) > historySize {
+		s.history = s.history[len(s.history)-historySize:]
+	}
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	if e.Terminal() {
+		s.finished = true
+		for ch := range s.subscribers {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+
+		time.AfterFunc(b.retention, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			if b.streams[jobID] == s {
+				delete(b.streams, jobID)
+			}
+		})
+	}
+}
+
+// Subscription holds the events missed since the last event id given to
+// Subscribe, followed by the live events received on C. C is closed when
+// the job is finished or when the subscriber is too slow.
+type Subscription struct {
+	// Known is false when the broker has no event for the job.
+	Known  bool
+	Events []Event
+	C      <-chan Event
+
+	close func()
+}
+
+// Close stops the delivery of the events.
+func (s *Subscription) Close() {
+	s.close()
+}
+
+// Subscribe subscribes to the events of the job published after lastEventID.
+func (b *Broker) Subscribe(jobID string, lastEventID uint64) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{
+		C:     ch,
+		close: func() {},
+	}
+
+	s, ok := b.streams[jobID]
+	if !ok {
+		s = &stream{
+			subscribers: make(map[chan Event]struct{}),
+		}
+		b.streams[jobID] = s
+	}
+
+	sub.Known = s.lastID > 0
+
+	for _, e := range s.history {
+		if e.ID > lastEventID {
+			sub.Events = append(sub.Events, e)
+		}
+	}
+
+	if s.finished {
+		close(ch)
+		return sub
+	}
+
+	s.subscribers[ch] = struct{}{}
+	sub.close = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+
+		// forget the streams created by subscribers of unknown jobs
+		if s.lastID == 0 && len(s.subscribers) == 0 && b.streams[jobID] == s {
+			delete(b.streams, jobID)
+		}
+	}
+
+	return sub
+}
@@ -0,0 +1,102 @@
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/stretchr/testify/require"
+)
+
+func progress(percentage int) events.Event {
+	return events.Event{
+		Type:       events.TypeProgress,
+		Status:     models.StatusRunning,
+		Percentage: percentage,
+	}
+}
+
+func status(status string) events.Event {
+	return events.Event{
+		Type:   events.TypeStatus,
+		Status: status,
+	}
+}
+
+func receive(t *testing.T, sub *events.Subscription) (events.Event, bool) {
+	select {
+	case e, ok := <-sub.C:
+		return e, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for event")
+		return events.Event{}, false
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	b := events.NewBroker(time.Minute)
+
+	sub := b.Subscribe("job1", 0)
+	defer sub.Close()
+	require.False(t, sub.Known)
+	require.Empty(t, sub.Events)
+
+	b.Publish("job1", status(models.StatusRunning))
+	b.Publish("job1", progress(50))
+	b.Publish("job2", progress(10))
+	b.Publish("job1", status(models.StatusDone))
+
+	for i, expected := range []string{models.StatusRunning, models.StatusRunning, models.StatusDone} {
+		e, ok := receive(t, sub)
+		require.True(t, ok)
+		require.EqualValues(t, i+1, e.ID)
+		require.Equal(t, expected, e.Status)
+	}
+
+	// the stream is closed once the job is done
+	_, ok := receive(t, sub)
+	require.False(t, ok)
+}
+
+func TestSubscribeResume(t *testing.T) {
+	b := events.NewBroker(time.Minute)
+
+	b.Publish("job1", status(models.StatusRunning))
+	b.Publish("job1", progress(25))
+	b.Publish("job1", progress(50))
+
+	sub := b.Subscribe("job1", 1)
+	defer sub.Close()
+
+	require.True(t, sub.Known)
+	require.Len(t, sub.Events, 2)
+	require.EqualValues(t, 2, sub.Events[0].ID)
+	require.EqualValues(t, 3, sub.Events[1].ID)
+
+	b.Publish("job1", status(models.StatusFailed))
+
+	e, ok := receive(t, sub)
+	require.True(t, ok)
+	require.True(t, e.Terminal())
+
+	// finished streams can still be resumed until the retention delay
+	late := b.Subscribe("job1", 3)
+	require.Len(t, late.Events, 1)
+	require.Equal(t, models.StatusFailed, late.Events[0].Status)
+
+	_, ok = receive(t, late)
+	require.False(t, ok)
+}
+
+func TestRetention(t *testing.T) {
+	b := events.NewBroker(10 * time.Millisecond)
+
+	b.Publish("job1", status(models.StatusDone))
+	require.Eventually(t, func() bool {
+		sub := b.Subscribe("job1", 0)
+		defer sub.Close()
+
+		return !sub.Known
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,238 @@
+// Package janitor removes the working directories of the uploads which are
+// no longer needed, according to the state of their transcode job.
+package janitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/angelorc/go-uploader/models"
+)
+
+// Removal reasons.
+const (
+	ReasonDone      = "done"
+	ReasonFailed    = "failed"
+	ReasonStale     = "stale"
+	ReasonHighWater = "high-water"
+)
+
+// BusyWindow is how long after its last write an upload without job is
+// considered in progress, it is never removed meanwhile.
+const BusyWindow = 10 * time.Minute
+
+// Lookup returns the transcode job of an upload, nil when there is none.
+type Lookup func(uploadID string) (*models.Transcoder, error)
+
+// Policy defines when the working directories are removed. The directories
+// of the done jobs are removed right away, the ones of the queued and
+// running jobs are always kept.
+type Policy struct {
+	// FailedRetention is how long the directory of a failed job is kept,
+	// to inspect or retry it.
+	FailedRetention time.Duration
+	// StaleRetention is how long an upload without job, such as an abandoned
+	// resumable upload, is kept after its last write.
+	StaleRetention time.Duration
+	// HighWater is the disk usage of the working directories, in bytes,
+	// above which the failed jobs and stale uploads are removed before their
+	// retention, oldest first. Unlimited when 0.
+	HighWater int64
+}
+
+// Removal is a working directory removed, or to be removed on a dry run.
+type Removal struct {
+	UploadID string    `json:"upload_id"`
+	Reason   string    `json:"reason"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+}
+
+// Report is the outcome of a run, Usage is the disk usage of the working
+// directories before the run.
+type Report struct {
+	Usage   int64     `json:"usage"`
+	Freed   int64     `json:"freed"`
+	Removed []Removal `json:"removed"`
+}
+
+type Janitor struct {
+	// Root is the directory holding the working directories, by upload id.
+	Root   string
+	Policy Policy
+	Lookup Lookup
+	// Now returns the current time, it is replaced by the tests.
+	Now func() time.Time
+}
+
+func New(root string, policy Policy, lookup Lookup) *Janitor {
+	return &Janitor{
+		Root:   root,
+		Policy: policy,
+		Lookup: lookup,
+		Now:    time.Now,
+	}
+}
+
+// workDir is a working directory, ModTime is the last write of any of its
+// files.
+type workDir struct {
+	id      string
+	size    int64
+	modTime time.Time
+	// since is the start of the retention of a failed job or stale upload.
+	since time.Time
+	// reason is set when the directory is removable under pressure.
+	reason string
+	// job is the transcode job of the upload, nil when there is none.
+	job *models.Transcoder
+}
+
+// Run removes the working directories according to the policy, nothing is
+// removed on a dry run but the report is the same.
+func (j *Janitor) Run(dryRun bool) (Report, error) {
+	report := Report{Removed: []Removal{}}
+
+	entries, err := ioutil.ReadDir(j.Root)
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+
+	now := j.Now()
+
+	var candidates []workDir
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		dir, err := j.stat(entry)
+		if err != nil {
+			return report, err
+		}
+		report.Usage += dir.size
+
+		job, err := j.Lookup(dir.id)
+		if err != nil {
+			return report, err
+		}
+		dir.job = job
+
+		switch {
+		case job != nil && (job.Status == models.StatusQueued || job.Status == models.StatusRunning):
+			continue
+
+		case job != nil && job.Status == models.StatusDone:
+			dir.reason = ReasonDone
+
+		case job != nil && job.Status == models.StatusFailed:
+			dir.since = dir.modTime
+			if job.FinishedAt != nil {
+				dir.since = *job.FinishedAt
+			}
+
+			if now.Sub(dir.since) < j.Policy.FailedRetention {
+				dir.reason = ReasonHighWater
+				candidates = append(candidates, dir)
+				continue
+			}
+			dir.reason = ReasonFailed
+
+		default:
+			dir.since = dir.modTime
+
+			idle := now.Sub(dir.modTime)
+			if idle < j.Policy.StaleRetention {
+				if idle >= BusyWindow {
+					dir.reason = ReasonHighWater
+					candidates = append(candidates, dir)
+				}
+				continue
+			}
+			dir.reason = ReasonStale
+		}
+
+		if err := j.remove(&report, dir, dryRun); err != nil {
+			return report, err
+		}
+	}
+
+	if j.Policy.HighWater <= 0 {
+		return report, nil
+	}
+
+	sort.Slice(candidates, func(a, b int) bool { return candidates[a].since.Before(candidates[b].since) })
+
+	for _, dir := range candidates {
+		if report.Usage-report.Freed <= j.Policy.HighWater {
+			break
+		}
+
+		if err := j.remove(&report, dir, dryRun); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (j *Janitor) remove(report *Report, dir workDir, dryRun bool) error {
+	if !dryRun {
+		if err := os.RemoveAll(filepath.Join(j.Root, dir.id)); err != nil {
+			return err
+		}
+
+		// normally released by the worker when the job failed
+		if dir.job != nil && dir.job.Status == models.StatusFailed && dir.job.Reserved > 0 {
+			if err := dir.job.ReleaseQuota(); err != nil {
+				return err
+			}
+		}
+	}
+
+	report.Freed += dir.size
+	report.Removed = append(report.Removed, Removal{
+		UploadID: dir.id,
+		Reason:   dir.reason,
+		Size:     dir.size,
+		ModTime:  dir.modTime,
+	})
+
+	return nil
+}
+
+func (j *Janitor) stat(entry os.FileInfo) (workDir, error) {
+	dir := workDir{
+		id:      entry.Name(),
+		modTime: entry.ModTime(),
+	}
+
+	err := filepath.Walk(filepath.Join(j.Root, entry.Name()), func(path string, info os.FileInfo, err error) error {
+		// removed meanwhile, e.g. by a worker
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			dir.size += info.Size()
+		}
+
+		if info.ModTime().After(dir.modTime) {
+			dir.modTime = info.ModTime()
+		}
+
+		return nil
+	})
+
+	return dir, err
+}
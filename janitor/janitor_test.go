@@ -0,0 +1,136 @@
+package janitor_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/janitor"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// writeWorkDir writes a working directory of size bytes last written at modTime.
+func writeWorkDir(t *testing.T, root string, id string, size int, modTime time.Time) {
+	dir := filepath.Join(root, id)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+
+	file := filepath.Join(dir, "original.mp3")
+	require.NoError(t, ioutil.WriteFile(file, make([]byte, size), 0644))
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+	require.NoError(t, os.Chtimes(dir, modTime, modTime))
+}
+
+func newTestJanitor(t *testing.T, policy janitor.Policy) (*janitor.Janitor, string) {
+	root, err := ioutil.TempDir("", "janitor")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(root) })
+
+	failedAt := now.Add(-time.Hour)
+	failedLongAgo := now.Add(-100 * time.Hour)
+
+	jobs := map[string]*models.Transcoder{
+		"queued":        {Status: models.StatusQueued},
+		"running":       {Status: models.StatusRunning},
+		"done":          {Status: models.StatusDone},
+		"failed-recent": {Status: models.StatusFailed, FinishedAt: &failedAt},
+		"failed-old":    {Status: models.StatusFailed, FinishedAt: &failedLongAgo},
+	}
+
+	writeWorkDir(t, root, "queued", 100, now.Add(-200*time.Hour))
+	writeWorkDir(t, root, "running", 100, now.Add(-200*time.Hour))
+	writeWorkDir(t, root, "done", 10, now)
+	writeWorkDir(t, root, "failed-recent", 20, now.Add(-time.Hour))
+	writeWorkDir(t, root, "failed-old", 30, now.Add(-100*time.Hour))
+	writeWorkDir(t, root, "stale-old", 40, now.Add(-48*time.Hour))
+	writeWorkDir(t, root, "stale-idle", 50, now.Add(-2*time.Hour))
+	writeWorkDir(t, root, "uploading", 60, now.Add(-time.Minute))
+
+	j := janitor.New(root, policy, func(uploadID string) (*models.Transcoder, error) {
+		return jobs[uploadID], nil
+	})
+	j.Now = func() time.Time { return now }
+
+	return j, root
+}
+
+func removed(report janitor.Report) map[string]string {
+	reasons := make(map[string]string)
+	for _, r := range report.Removed {
+		reasons[r.UploadID] = r.Reason
+	}
+
+	return reasons
+}
+
+func remaining(t *testing.T, root string) []string {
+	entries, err := ioutil.ReadDir(root)
+	require.NoError(t, err)
+
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.Name())
+	}
+
+	return ids
+}
+
+func TestJanitorRetention(t *testing.T) {
+	j, root := newTestJanitor(t, janitor.Policy{
+		FailedRetention: 72 * time.Hour,
+		StaleRetention:  24 * time.Hour,
+	})
+
+	// nothing is removed on a dry run
+	report, err := j.Run(true)
+	require.NoError(t, err)
+	require.Equal(t, int64(410), report.Usage)
+	require.Equal(t, int64(80), report.Freed)
+	require.Equal(t, map[string]string{
+		"done":       janitor.ReasonDone,
+		"failed-old": janitor.ReasonFailed,
+		"stale-old":  janitor.ReasonStale,
+	}, removed(report))
+	require.Len(t, remaining(t, root), 8)
+
+	report, err = j.Run(false)
+	require.NoError(t, err)
+	require.Len(t, report.Removed, 3)
+	require.Equal(t, []string{"failed-recent", "queued", "running", "stale-idle", "uploading"}, remaining(t, root))
+}
+
+func TestJanitorHighWater(t *testing.T) {
+	j, root := newTestJanitor(t, janitor.Policy{
+		FailedRetention: 72 * time.Hour,
+		StaleRetention:  24 * time.Hour,
+		HighWater:       280,
+	})
+
+	// 80 of the 410 bytes are freed by the retention, then the idle upload,
+	// older than the recent failure, brings the usage down to the mark
+	report, err := j.Run(false)
+	require.NoError(t, err)
+	require.Equal(t, int64(130), report.Freed)
+	require.Equal(t, janitor.ReasonHighWater, removed(report)["stale-idle"])
+	require.Equal(t, []string{"failed-recent", "queued", "running", "uploading"}, remaining(t, root))
+
+	// the active jobs and uploads are kept whatever the mark
+	j.Policy.HighWater = 1
+
+	report, err = j.Run(false)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"failed-recent": janitor.ReasonHighWater}, removed(report))
+	require.Equal(t, []string{"queued", "running", "uploading"}, remaining(t, root))
+}
+
+func TestJanitorMissingRoot(t *testing.T) {
+	j := janitor.New(filepath.Join(os.TempDir(), "janitor-missing"), janitor.Policy{}, nil)
+
+	report, err := j.Run(false)
+	require.NoError(t, err)
+	require.Empty(t, report.Removed)
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"context"
+	"github.com/angelorc/go-uploader/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+const GrantCollection = "grant"
+
+// duplicateKeyCode is the code of the mongo write errors on a duplicate key.
+const duplicateKeyCode = 11000
+
+// Grant records the use of a signed upload URL, by the id of the transcode
+// it signs.
+type Grant struct {
+	JobID  primitive.ObjectID `json:"job_id" bson:"_id"`
+	UsedAt time.Time          `json:"used_at" bson:"used_at"`
+}
+
+func (g *Grant) GetCollection() *mongo.Collection {
+	db, _ := db.Connect()
+
+	return db.Collection(GrantCollection)
+}
+
+// Claim records the use of the grant, once: it returns false when the grant
+// was already used.
+func (g *Grant) Claim() (bool, error) {
+	collection := g.GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	g.UsedAt = time.Now().UTC()
+
+	_, err := collection.InsertOne(ctx, g)
+	if isDuplicateKey(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func isDuplicateKey(err error) bool {
+	we, ok := err.(mongo.WriteException)
+	if !ok {
+		return false
+	}
+
+	for _, e := range we.WriteErrors {
+		if e.Code == duplicateKeyCode {
+			return true
+		}
+	}
+
+	return false
+}
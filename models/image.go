@@ -0,0 +1,89 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/angelorc/go-uploader/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+const ImageCollection = "image"
+
+// ImageVariant describes a resized rendition of an uploaded image.
+type ImageVariant struct {
+	Size     int    `json:"size" bson:"size"`
+	Width    int    `json:"width" bson:"width"`
+	Height   int    `json:"height" bson:"height"`
+	Bytes    int64  `json:"bytes" bson:"bytes"`
+	FileName string `json:"file_name" bson:"file_name"`
+}
+
+type Image struct {
+	ID       primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Format   string             `json:"format" bson:"format"`
+	Variants []ImageVariant     `json:"variants" bson:"variants"`
+}
+
+func NewImage() *Image {
+	return &Image{
+		ID: primitive.NewObjectID(),
+	}
+}
+
+func (i *Image) GetCollection() *mongo.Collection {
+	db, _ := db.Connect()
+
+	return db.Collection(ImageCollection)
+}
+
+func (i *Image) Create() error {
+	collection := i.GetCollection()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, i)
+	if err != nil {
+		return fmt.Errorf("cannot create mongo/image")
+	}
+
+	return nil
+}
+
+func (i *Image) Get() (*Image, error) {
+	collection := i.GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: i.ID},
+	}
+
+	var image Image
+	err := collection.FindOne(ctx, filter).Decode(&image)
+	if err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
+
+func (i *Image) Delete() error {
+	collection := i.GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: i.ID},
+	}
+
+	_, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
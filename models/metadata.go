@@ -0,0 +1,51 @@
+package models
+
+// AudioMetadata is the metadata of an original reported by ffprobe. Tags
+// holds the tags of the container and of the audio stream, ID3 or Vorbis
+// comments, by lower case key, the common ones are also set to the named
+// fields to prefill the track details.
+type AudioMetadata struct {
+	Format      string            `json:"format" bson:"format"`
+	Duration    float64           `json:"duration" bson:"duration"`
+	BitRate     int64             `json:"bit_rate,omitempty" bson:"bit_rate,omitempty"`
+	Title       string            `json:"title,omitempty" bson:"title,omitempty"`
+	Artist      string            `json:"artist,omitempty" bson:"artist,omitempty"`
+	Album       string            `json:"album,omitempty" bson:"album,omitempty"`
+	AlbumArtist string            `json:"album_artist,omitempty" bson:"album_artist,omitempty"`
+	Genre       string            `json:"genre,omitempty" bson:"genre,omitempty"`
+	Date        string            `json:"date,omitempty" bson:"date,omitempty"`
+	Track       string            `json:"track,omitempty" bson:"track,omitempty"`
+	Disc        string            `json:"disc,omitempty" bson:"disc,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty" bson:"tags,omitempty"`
+	Streams     []StreamMetadata  `json:"streams" bson:"streams"`
+}
+
+// StreamMetadata is a stream of an original, audio or the attached cover
+// art. BitDepth is the bits per sample of the lossless codecs.
+type StreamMetadata struct {
+	Index         int               `json:"index" bson:"index"`
+	CodecType     string            `json:"codec_type" bson:"codec_type"`
+	CodecName     string            `json:"codec_name" bson:"codec_name"`
+	Profile       string            `json:"profile,omitempty" bson:"profile,omitempty"`
+	SampleRate    int               `json:"sample_rate,omitempty" bson:"sample_rate,omitempty"`
+	Channels      int               `json:"channels,omitempty" bson:"channels,omitempty"`
+	ChannelLayout string            `json:"channel_layout,omitempty" bson:"channel_layout,omitempty"`
+	BitDepth      int               `json:"bit_depth,omitempty" bson:"bit_depth,omitempty"`
+	BitRate       int64             `json:"bit_rate,omitempty" bson:"bit_rate,omitempty"`
+	Duration      float64           `json:"duration,omitempty" bson:"duration,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty" bson:"tags,omitempty"`
+}
+
+// TrackTags are the tags written as ID3 frames into the MP3 download, sent
+// with the upload or a later update. The source tags are written along with
+// them unless DropSourceTags is set. CoverMIME is the type of the cover
+// stored with the outputs, empty without cover.
+type TrackTags struct {
+	Title          string `json:"title,omitempty" bson:"title,omitempty"`
+	Artist         string `json:"artist,omitempty" bson:"artist,omitempty"`
+	Album          string `json:"album,omitempty" bson:"album,omitempty"`
+	ISRC           string `json:"isrc,omitempty" bson:"isrc,omitempty"`
+	Year           string `json:"year,omitempty" bson:"year,omitempty"`
+	DropSourceTags bool   `json:"drop_source_tags,omitempty" bson:"drop_source_tags,omitempty"`
+	CoverMIME      string `json:"cover_mime,omitempty" bson:"cover_mime,omitempty"`
+}
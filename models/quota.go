@@ -0,0 +1,195 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/angelorc/go-uploader/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const QuotaCollection = "quota"
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Quota is the storage used by an uploader, identified by its owner or its
+// address when anonymous. MaxBytes is the storage allowed, unlimited when 0.
+type Quota struct {
+	Owner     string    `json:"owner" bson:"_id"`
+	MaxBytes  int64     `json:"max_bytes" bson:"max_bytes"`
+	UsedBytes int64     `json:"used_bytes" bson:"used_bytes"`
+	Uploads   int64     `json:"uploads" bson:"uploads"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// NewQuota returns the quota of owner, maxBytes is used when the owner has no
+// stored quota yet.
+func NewQuota(owner string, maxBytes int64) *Quota {
+	return &Quota{
+		Owner:    owner,
+		MaxBytes: maxBytes,
+	}
+}
+
+func (q *Quota) GetCollection() *mongo.Collection {
+	db, _ := db.Connect()
+
+	return db.Collection(QuotaCollection)
+}
+
+// Get returns the stored quota, or q when the owner has none.
+func (q *Quota) Get() (*Quota, error) {
+	collection := q.GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: q.Owner},
+	}
+
+	var quota Quota
+	err := collection.FindOne(ctx, filter).Decode(&quota)
+	if err == mongo.ErrNoDocuments {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &quota, nil
+}
+
+// Remaining returns the bytes the owner can still upload, -1 when unlimited.
+func (q *Quota) Remaining() int64 {
+	if q.MaxBytes == 0 {
+		return -1
+	}
+
+	if q.UsedBytes >= q.MaxBytes {
+		return 0
+	}
+
+	return q.MaxBytes - q.UsedBytes
+}
+
+// Reserve atomically adds bytes to the used storage, or returns
+// ErrQuotaExceeded when the quota would be exceeded.
+func (q *Quota) Reserve(bytes int64) error {
+	collection := q.GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the quota is created with the default max bytes on first use
+	_, err := collection.UpdateOne(ctx, bson.D{
+		{Key: "_id", Value: q.Owner},
+	}, bson.D{
+		{Key: "$setOnInsert", Value: bson.D{
+			{Key: "max_bytes", Value: q.MaxBytes},
+			{Key: "used_bytes", Value: int64(0)},
+			{Key: "uploads", Value: int64(0)},
+			{Key: "updated_at", Value: time.Now().UTC()},
+		}},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{
+		{Key: "_id", Value: q.Owner},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "max_bytes", Value: 0}},
+			bson.D{{Key: "$expr", Value: bson.D{
+				{Key: "$lte", Value: bson.A{
+					bson.D{{Key: "$add", Value: bson.A{"$used_bytes", bytes}}},
+					"$max_bytes",
+				}},
+			}}},
+		}},
+	}
+
+	res, err := collection.UpdateOne(ctx, filter, quotaUsage(bytes, 1))
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+// Release gives back the storage of a reservation.
+func (q *Quota) Release(bytes int64) error {
+	collection := q.GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: q.Owner},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, quotaUsage(-bytes, -1))
+
+	return err
+}
+
+// SetMaxBytes sets the storage allowed to the owner.
+func (q *Quota) SetMaxBytes(maxBytes int64) error {
+	collection := q.GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: q.Owner},
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "max_bytes", Value: maxBytes},
+			{Key: "updated_at", Value: time.Now().UTC()},
+		}},
+		{Key: "$setOnInsert", Value: bson.D{
+			{Key: "used_bytes", Value: int64(0)},
+			{Key: "uploads", Value: int64(0)},
+		}},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+
+	return err
+}
+
+func quotaUsage(bytes int64, uploads int64) bson.D {
+	return bson.D{
+		{Key: "$inc", Value: bson.D{
+			{Key: "used_bytes", Value: bytes},
+			{Key: "uploads", Value: uploads},
+		}},
+		{Key: "$set", Value: bson.D{
+			{Key: "updated_at", Value: time.Now().UTC()},
+		}},
+	}
+}
+
+// ListQuotas returns the stored quotas, sorted by owner.
+func ListQuotas() ([]Quota, error) {
+	collection := (&Quota{}).GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	quotas := []Quota{}
+	if err := cursor.All(ctx, &quotas); err != nil {
+		return nil, err
+	}
+
+	return quotas, nil
+}
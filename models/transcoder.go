@@ -2,25 +2,105 @@ package models
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/angelorc/go-uploader/db"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
 )
 
 const Collection = "transcoder"
 
+// ErrTranscodeRunning is returned when the tags of a running job are updated,
+// its download is being written from the tags read at its start.
+var ErrTranscodeRunning = errors.New("transcode is running, retry once done")
+
+// Transcoder job statuses.
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Manifest types.
+const (
+	ManifestHLS  = "hls"
+	ManifestDash = "dash"
+)
+
+// Manifest is a published manifest of the job output, Cid is the CID of the
+// rewritten manifest.
+type Manifest struct {
+	Type     string `json:"type" bson:"type"`
+	FileName string `json:"file_name" bson:"file_name"`
+	Cid      string `json:"cid" bson:"cid"`
+}
+
+// Loudness is the EBU R128 loudness of the original: the integrated loudness
+// and its gating threshold in LUFS, the true peak in dBTP and the loudness
+// range in LU. Target is the integrated loudness the outputs were normalized
+// to, zero when they keep the levels of the original. TrackGain and
+// TrackPeak are the ReplayGain 2.0 gain, in dB, and linear peak of the
+// outputs, for the players normalizing on their side.
+type Loudness struct {
+	Integrated float64 `json:"integrated" bson:"integrated"`
+	TruePeak   float64 `json:"true_peak" bson:"true_peak"`
+	LRA        float64 `json:"lra" bson:"lra"`
+	Threshold  float64 `json:"threshold" bson:"threshold"`
+	Target     float64 `json:"target,omitempty" bson:"target,omitempty"`
+	TrackGain  float64 `json:"track_gain" bson:"track_gain"`
+	TrackPeak  float64 `json:"track_peak" bson:"track_peak"`
+}
+
+// Transcoder is the status of a transcode job.
 type Transcoder struct {
-	ID         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	Percentage int                `json:"percentage" bson:"percentage"`
+	ID primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	// Owner is the identity of the caller which uploaded the audio.
+	Owner string `json:"owner,omitempty" bson:"owner,omitempty"`
+	// UploadID is the working directory of the upload.
+	UploadID string `json:"upload_id,omitempty" bson:"upload_id,omitempty"`
+	// Sha256 is the hash of the original.
+	Sha256   string  `json:"sha256,omitempty" bson:"sha256,omitempty"`
+	Duration float32 `json:"duration" bson:"duration"`
+	// Metadata is the ffprobe metadata and tags of the original.
+	Metadata *AudioMetadata `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	// Tags are the tags of the MP3 download.
+	Tags   *TrackTags `json:"tags,omitempty" bson:"tags,omitempty"`
+	Status string     `json:"status" bson:"status"`
+	// Profile is the name of the HLS output profile.
+	Profile string `json:"profile" bson:"profile"`
+	// Loudness is measured before transcoding.
+	Loudness   *Loudness `json:"loudness,omitempty" bson:"loudness,omitempty"`
+	Percentage int       `json:"percentage" bson:"percentage"`
+	// Stages holds the percentage of every transcoding stage.
+	Stages map[string]int `json:"stages,omitempty" bson:"stages,omitempty"`
+	Cid    string         `json:"cid,omitempty" bson:"cid,omitempty"`
+	// Manifests are the HLS and DASH manifests available once the job is done.
+	Manifests []Manifest `json:"manifests,omitempty" bson:"manifests,omitempty"`
+	// Error is the reason of the failure when the status is failed.
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+	// Stderr holds the last lines written by ffmpeg when the status is failed.
+	Stderr   string `json:"stderr,omitempty" bson:"stderr,omitempty"`
+	Attempts int    `json:"attempts" bson:"attempts"`
+	// QuotaKey is the caller whose quota holds the Reserved bytes until the
+	// job fails.
+	QuotaKey   string     `json:"-" bson:"quota_key,omitempty"`
+	Reserved   int64      `json:"-" bson:"reserved,omitempty"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
 }
 
 func NewTranscoder() *Transcoder {
 	return &Transcoder{
 		ID:         primitive.NewObjectID(),
+		Status:     StatusQueued,
 		Percentage: 0,
+		CreatedAt:  time.Now().UTC(),
 	}
 }
 
@@ -33,7 +113,9 @@ func (t *Transcoder) GetCollection() *mongo.Collection {
 func (t *Transcoder) Create() error {
 	collection := t.GetCollection()
 
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
 	_, err := collection.InsertOne(ctx, t)
 	if err != nil {
 		return fmt.Errorf("cannot create mongo/transcoder")
@@ -44,14 +126,89 @@ func (t *Transcoder) Create() error {
 
 func (t *Transcoder) Get() (*Transcoder, error) {
 	collection := t.GetCollection()
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: t.ID},
+	}
+
+	var transcoder Transcoder
+	err := collection.FindOne(ctx, filter).Decode(&transcoder)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transcoder, nil
+}
+
+// Exists reports whether the job has been created.
+func (t *Transcoder) Exists() (bool, error) {
+	collection := t.GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: t.ID},
+	}
+
+	count, err := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// FindByUploadID returns the job of an upload, nil when there is none.
+func FindByUploadID(uploadID string) (*Transcoder, error) {
+	collection := (&Transcoder{}).GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
 	filter := bson.D{
-		{"_id", t.ID},
+		{Key: "upload_id", Value: uploadID},
 	}
 
 	var transcoder Transcoder
 	err := collection.FindOne(ctx, filter).Decode(&transcoder)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &transcoder, nil
+}
+
+// FindDuplicate returns the last done job of the same owner and profile
+// transcoding an original with the same hash, nil when there is none.
+func (t *Transcoder) FindDuplicate() (*Transcoder, error) {
+	collection := t.GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	owner := bson.E{Key: "owner", Value: t.Owner}
+	if t.Owner == "" {
+		// the owner is omitted when empty
+		owner.Value = bson.D{{Key: "$exists", Value: false}}
+	}
+
+	filter := bson.D{
+		{Key: "sha256", Value: t.Sha256},
+		owner,
+		{Key: "profile", Value: t.Profile},
+		{Key: "status", Value: StatusDone},
+	}
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "finished_at", Value: -1}})
+
+	var transcoder Transcoder
+	err := collection.FindOne(ctx, filter, opts).Decode(&transcoder)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -60,17 +217,129 @@ func (t *Transcoder) Get() (*Transcoder, error) {
 }
 
 func (t *Transcoder) UpdatePercentage(percentage int) error {
+	return t.update(bson.D{
+		{Key: "percentage", Value: percentage},
+	})
+}
+
+// UpdateProgress sets the percentage of a single stage along with the overall
+// percentage of the job.
+func (t *Transcoder) UpdateProgress(stage string, stagePercentage int, percentage int) error {
+	return t.update(bson.D{
+		{Key: "stages." + stage, Value: stagePercentage},
+		{Key: "percentage", Value: percentage},
+	})
+}
+
+func (t *Transcoder) UpdateCid(cid string) error {
+	return t.update(bson.D{
+		{Key: "cid", Value: cid},
+	})
+}
+
+// UpdateLoudness sets the loudness of the original and of the outputs.
+func (t *Transcoder) UpdateLoudness(loudness Loudness) error {
+	return t.update(bson.D{
+		{Key: "loudness", Value: loudness},
+	})
+}
+
+// UpdateTags sets the tags of the MP3 download unless the job is running,
+// ErrTranscodeRunning is returned then. It returns the updated job.
+func (t *Transcoder) UpdateTags(tags TrackTags) (*Transcoder, error) {
 	collection := t.GetCollection()
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
 	filter := bson.D{
-		{"_id", t.ID},
+		{Key: "_id", Value: t.ID},
+		{Key: "status", Value: bson.D{{Key: "$ne", Value: StatusRunning}}},
 	}
 
 	update := bson.D{
-		{"$set", bson.D{
-			{"percentage", percentage},
+		{Key: "$set", Value: bson.D{{Key: "tags", Value: tags}}},
+	}
+
+	var updated Transcoder
+	err := collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTranscodeRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// UpdateManifests sets the published manifests, the CID of the first one is
+// stored as the job CID.
+func (t *Transcoder) UpdateManifests(manifests []Manifest) error {
+	fields := bson.D{
+		{Key: "manifests", Value: manifests},
+	}
+
+	if len(manifests) > 0 {
+		fields = append(fields, bson.E{Key: "cid", Value: manifests[0].Cid})
+	}
+
+	return t.update(fields)
+}
+
+// Start marks the job as running, a new attempt is counted and the outcome of
+// any previous attempt is cleared.
+func (t *Transcoder) Start() error {
+	return t.updateWith(bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: StatusRunning},
+			{Key: "percentage", Value: 0},
+			{Key: "started_at", Value: time.Now().UTC()},
+		}},
+		{Key: "$unset", Value: bson.D{
+			{Key: "stages", Value: ""},
+			{Key: "manifests", Value: ""},
+			{Key: "error", Value: ""},
+			{Key: "stderr", Value: ""},
+			{Key: "finished_at", Value: ""},
 		}},
+		{Key: "$inc", Value: bson.D{
+			{Key: "attempts", Value: 1},
+		}},
+	})
+}
+
+// Fail marks the job as failed with the given error and ffmpeg stderr.
+func (t *Transcoder) Fail(err error, stderr string) error {
+	return t.update(bson.D{
+		{Key: "status", Value: StatusFailed},
+		{Key: "error", Value: err.Error()},
+		{Key: "stderr", Value: stderr},
+		{Key: "finished_at", Value: time.Now().UTC()},
+	})
+}
+
+// Complete marks the job as done.
+func (t *Transcoder) Complete() error {
+	return t.update(bson.D{
+		{Key: "status", Value: StatusDone},
+		{Key: "percentage", Value: 100},
+		{Key: "finished_at", Value: time.Now().UTC()},
+	})
+}
+
+func (t *Transcoder) update(fields bson.D) error {
+	return t.updateWith(bson.D{
+		{Key: "$set", Value: fields},
+	})
+}
+
+func (t *Transcoder) updateWith(update bson.D) error {
+	collection := t.GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: t.ID},
 	}
 
 	_, err := collection.UpdateOne(ctx, filter, update)
@@ -81,12 +350,41 @@ func (t *Transcoder) UpdatePercentage(percentage int) error {
 	return nil
 }
 
+// ReleaseQuota gives back the storage reserved by the job, once: the
+// reservation is cleared in the same update.
+func (t *Transcoder) ReleaseQuota() error {
+	collection := t.GetCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: t.ID},
+		{Key: "reserved", Value: bson.D{{Key: "$gt", Value: 0}}},
+	}
+
+	update := bson.D{
+		{Key: "$unset", Value: bson.D{{Key: "reserved", Value: ""}}},
+	}
+
+	var reserved Transcoder
+	err := collection.FindOneAndUpdate(ctx, filter, update).Decode(&reserved)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return NewQuota(reserved.QuotaKey, 0).Release(reserved.Reserved)
+}
+
 func (t *Transcoder) Delete() error {
 	collection := t.GetCollection()
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
 	filter := bson.D{
-		{"_id", t.ID},
+		{Key: "_id", Value: t.ID},
 	}
 
 	_, err := collection.DeleteOne(ctx, filter)
@@ -95,4 +393,4 @@ func (t *Transcoder) Delete() error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,26 @@
+package queue
+
+import (
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// badgerLogger forwards the badger logs to zerolog.
+type badgerLogger struct{}
+
+func (badgerLogger) Errorf(format string, args ...interface{}) {
+	log.Error().Str("module", "queue").Msgf(strings.TrimSpace(format), args...)
+}
+
+func (badgerLogger) Warningf(format string, args ...interface{}) {
+	log.Warn().Str("module", "queue").Msgf(strings.TrimSpace(format), args...)
+}
+
+func (badgerLogger) Infof(format string, args ...interface{}) {
+	log.Debug().Str("module", "queue").Msgf(strings.TrimSpace(format), args...)
+}
+
+func (badgerLogger) Debugf(format string, args ...interface{}) {
+	log.Debug().Str("module", "queue").Msgf(strings.TrimSpace(format), args...)
+}
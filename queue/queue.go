@@ -0,0 +1,225 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+const (
+	StateQueued  = "queued"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+
+	keyPrefix = "job/"
+
+	// DefaultRetention is how long the done and failed jobs are kept.
+	DefaultRetention = 24 * time.Hour
+)
+
+// ErrQueueFull is returned by Enqueue when every slot of the queue is taken.
+var ErrQueueFull = errors.New("transcode queue is full")
+
+// Job is a transcode job persisted on disk until it is done or failed.
+type Job struct {
+	ID        string    `json:"id"`
+	UploadID  string    `json:"upload_id"`
+	FileName  string    `json:"file_name"`
+	Profile   string    `json:"profile"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func NewJob(id, uploadID, fileName, profile string) *Job {
+	now := time.Now().UTC()
+
+	return &Job{
+		ID:        id,
+		UploadID:  uploadID,
+		FileName:  fileName,
+		Profile:   profile,
+		State:     StateQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Queue is a job queue backed by badger, jobs are stored before being handed
+// to the workers so that they survive a restart of the server.
+type Queue struct {
+	db   *badger.DB
+	jobs chan *Job
+	// Retention is how long the done and failed jobs are kept before they
+	// expire from the database.
+	Retention time.Duration
+}
+
+// Open opens (or creates) the queue database stored in path. capacity is the
+// number of jobs buffered in memory for the workers.
+func Open(path string, capacity int) (*Queue, error) {
+	db, err := badger.Open(badger.DefaultOptions(path).WithLogger(badgerLogger{}))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open queue database: %w", err)
+	}
+
+	return &Queue{
+		db:        db,
+		jobs:      make(chan *Job, capacity),
+		Retention: DefaultRetention,
+	}, nil
+}
+
+func (q *Queue) Close() error {
+	return q.db.Close()
+}
+
+// Jobs returns the channel the workers receive jobs from.
+func (q *Queue) Jobs() <-chan *Job {
+	return q.jobs
+}
+
+// Full reports whether the queue has no free slot left.
+func (q *Queue) Full() bool {
+	return len(q.jobs) == cap(q.jobs)
+}
+
+// Enqueue persists the job as queued and hands it to the workers. It never
+// blocks, ErrQueueFull is returned and the job is discarded when the queue
+// is full.
+func (q *Queue) Enqueue(job *Job) error {
+	job.State = StateQueued
+	if err := q.save(job); err != nil {
+		return err
+	}
+
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		if err := q.delete(job); err != nil {
+			return err
+		}
+
+		return ErrQueueFull
+	}
+}
+
+// SetState updates the persisted state of the job, the done and failed jobs
+// expire after the retention.
+func (q *Queue) SetState(job *Job, state string) error {
+	job.State = state
+	job.UpdatedAt = time.Now().UTC()
+
+	return q.save(job)
+}
+
+func (q *Queue) Get(id string) (*Job, error) {
+	var job Job
+
+	err := q.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(keyPrefix + id))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &job)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// Pending returns the queued and running jobs, oldest first.
+func (q *Queue) Pending() ([]*Job, error) {
+	var jobs []*Job
+
+	err := q.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(keyPrefix)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var job Job
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &job)
+			})
+			if err != nil {
+				return err
+			}
+
+			if job.State == StateQueued || job.State == StateRunning {
+				jobs = append(jobs, &job)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+
+	return jobs, nil
+}
+
+// Recover re-enqueues the jobs left queued or running by a previous run of
+// the server. Interrupted jobs are restarted from scratch. It returns the
+// number of recovered jobs once they are all handed to the workers, it
+// blocks while the queue is full and must be called once the workers run.
+func (q *Queue) Recover() (int, error) {
+	jobs, err := q.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, job := range jobs {
+		if job.State == StateRunning {
+			if err := q.SetState(job, StateQueued); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	for _, job := range jobs {
+		q.jobs <- job
+	}
+
+	return len(jobs), nil
+}
+
+func (q *Queue) delete(job *Job) error {
+	return q.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(keyPrefix + job.ID))
+	})
+}
+
+func (q *Queue) save(job *Job) error {
+	bz, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	entry := badger.NewEntry([]byte(keyPrefix+job.ID), bz)
+	if job.State == StateDone || job.State == StateFailed {
+		entry = entry.WithTTL(q.Retention)
+	}
+
+	return q.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(entry)
+	})
+}
@@ -0,0 +1,146 @@
+package queue_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/queue"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, q *queue.Queue) *queue.Job {
+	select {
+	case job := <-q.Jobs():
+		return job
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for job")
+		return nil
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	q, err := queue.Open(dir, 1)
+	require.NoError(t, err)
+	defer q.Close()
+
+	require.NoError(t, q.Enqueue(queue.NewJob("job1", "upload1", "track.wav", "default")))
+
+	job := receive(t, q)
+	require.Equal(t, "job1", job.ID)
+	require.Equal(t, queue.StateQueued, job.State)
+
+	require.NoError(t, q.SetState(job, queue.StateDone))
+
+	stored, err := q.Get("job1")
+	require.NoError(t, err)
+	require.Equal(t, queue.StateDone, stored.State)
+	require.Equal(t, "upload1", stored.UploadID)
+	require.Equal(t, "track.wav", stored.FileName)
+	require.Equal(t, "default", stored.Profile)
+}
+
+func TestRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	q, err := queue.Open(dir, 3)
+	require.NoError(t, err)
+
+	for _, id := range []string{"running", "queued", "done"} {
+		require.NoError(t, q.Enqueue(queue.NewJob(id, "upload-"+id, id+".mp3", "default")))
+	}
+
+	require.NoError(t, q.SetState(receive(t, q), queue.StateRunning))
+	receive(t, q)
+	require.NoError(t, q.SetState(receive(t, q), queue.StateDone))
+
+	// simulate a restart of the server
+	require.NoError(t, q.Close())
+
+	q, err = queue.Open(dir, 3)
+	require.NoError(t, err)
+	defer q.Close()
+
+	recovered, err := q.Recover()
+	require.NoError(t, err)
+	require.Equal(t, 2, recovered)
+
+	// handed to the workers before Recover returns
+	require.Len(t, q.Jobs(), 2)
+
+	first := receive(t, q)
+	require.Equal(t, "running", first.ID)
+	require.Equal(t, queue.StateQueued, first.State)
+
+	second := receive(t, q)
+	require.Equal(t, "queued", second.ID)
+
+	pending, err := q.Pending()
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	for _, job := range pending {
+		require.Equal(t, queue.StateQueued, job.State)
+	}
+}
+
+func TestTerminalJobsExpire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	q, err := queue.Open(dir, 2)
+	require.NoError(t, err)
+	defer q.Close()
+
+	q.Retention = time.Second
+
+	for _, id := range []string{"running", "done"} {
+		require.NoError(t, q.Enqueue(queue.NewJob(id, "upload-"+id, id+".mp3", "default")))
+	}
+
+	require.NoError(t, q.SetState(receive(t, q), queue.StateRunning))
+	require.NoError(t, q.SetState(receive(t, q), queue.StateDone))
+
+	_, err = q.Get("done")
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+
+	_, err = q.Get("done")
+	require.Error(t, err)
+
+	_, err = q.Get("running")
+	require.NoError(t, err)
+}
+
+func TestEnqueueFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	q, err := queue.Open(dir, 1)
+	require.NoError(t, err)
+	defer q.Close()
+
+	require.False(t, q.Full())
+	require.NoError(t, q.Enqueue(queue.NewJob("job1", "upload1", "track.wav", "default")))
+	require.True(t, q.Full())
+
+	err = q.Enqueue(queue.NewJob("job2", "upload2", "track.wav", "default"))
+	require.Equal(t, queue.ErrQueueFull, err)
+
+	// rejected jobs must not be recovered on restart
+	_, err = q.Get("job2")
+	require.Error(t, err)
+
+	receive(t, q)
+	require.False(t, q.Full())
+	require.NoError(t, q.Enqueue(queue.NewJob("job2", "upload2", "track.wav", "default")))
+}
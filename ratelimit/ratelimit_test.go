@@ -0,0 +1,58 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/ratelimit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	// 1 request every 10s, up to 2 at once
+	l := ratelimit.New(0.1, 2)
+	l.SetClock(func() time.Time { return now })
+
+	for i := 0; i < 2; i++ {
+		ok, _ := l.Allow("a")
+		require.True(t, ok)
+	}
+
+	ok, wait := l.Allow("a")
+	require.False(t, ok)
+	require.Equal(t, 10*time.Second, wait)
+
+	// buckets are independent
+	ok, _ = l.Allow("b")
+	require.True(t, ok)
+
+	now = now.Add(4 * time.Second)
+	ok, wait = l.Allow("a")
+	require.False(t, ok)
+	require.Equal(t, 6*time.Second, wait)
+
+	now = now.Add(6 * time.Second)
+	ok, _ = l.Allow("a")
+	require.True(t, ok)
+}
+
+func TestLimiterPrune(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	l := ratelimit.New(1, 1)
+	l.SetClock(func() time.Time { return now })
+
+	ok, _ := l.Allow("a")
+	require.True(t, ok)
+
+	now = now.Add(time.Second)
+	l.Prune()
+
+	// a pruned bucket starts full
+	ok, _ = l.Allow("a")
+	require.True(t, ok)
+	ok, _ = l.Allow("a")
+	require.False(t, ok)
+}
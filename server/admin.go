@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+const methodPUT = "PUT"
+
+type SetQuotaReq struct {
+	// MaxBytes is the storage allowed to the owner, unlimited when 0.
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// registerAdminRoutes registers the routes reserved to the configured admins.
+func registerAdminRoutes(api *mux.Router, cfg config.Config) {
+	admins := cfg.Auth.Admins
+
+	api.HandleFunc("/admin/quotas", requireAdmin(admins, listQuotasHandler(cfg.Limits))).Methods(methodGET)
+	api.HandleFunc("/admin/quotas/{owner}", requireAdmin(admins, getQuotaHandler(cfg.Limits))).Methods(methodGET)
+	api.HandleFunc("/admin/quotas/{owner}", requireAdmin(admins, setQuotaHandler(cfg.Limits))).Methods(methodPUT)
+}
+
+func writeQuotaResponse(w http.ResponseWriter, v interface{}) {
+	bz, err := json.Marshal(v)
+	if err != nil {
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("failed to encode response: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(bz)
+}
+
+// checkQuotasEnabled writes a 501 response when the quotas are disabled.
+func checkQuotasEnabled(w http.ResponseWriter, limits config.Limits) bool {
+	if !limits.Quotas {
+		writeErrorResponse(w, http.StatusNotImplemented, fmt.Errorf("quotas are not enabled"))
+		return false
+	}
+
+	return true
+}
+
+// @Summary List quotas
+// @Description List the storage quotas of the uploaders, reserved to the admins.
+// @Tags admin
+// @Produce json
+// @Success 200 {array} models.Quota
+// @Failure 401 {object} server.ErrorResponse "Authentication required"
+// @Failure 403 {object} server.ErrorResponse "Admin access required"
+// @Failure 501 {object} server.ErrorResponse "Quotas are not enabled"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/quotas [get]
+func listQuotasHandler(limits config.Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkQuotasEnabled(w, limits) {
+			return
+		}
+
+		quotas, err := models.ListQuotas()
+		if err != nil {
+			log.Error().Err(err).Msg("Cannot list quotas.")
+
+			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot list quotas"))
+			return
+		}
+
+		writeQuotaResponse(w, quotas)
+	}
+}
+
+// @Summary Get a quota
+// @Description Get the storage quota of an uploader, by owner, "key:<api key name>" or "jwt:<subject>", or by "ip:<address>" for the anonymous ones. Reserved to the admins.
+// @Tags admin
+// @Produce json
+// @Param owner path string true "Owner"
+// @Success 200 {object} models.Quota
+// @Failure 401 {object} server.ErrorResponse "Authentication required"
+// @Failure 403 {object} server.ErrorResponse "Admin access required"
+// @Failure 501 {object} server.ErrorResponse "Quotas are not enabled"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/quotas/{owner} [get]
+func getQuotaHandler(limits config.Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkQuotasEnabled(w, limits) {
+			return
+		}
+
+		quota, err := models.NewQuota(mux.Vars(r)["owner"], limits.DefaultQuota).Get()
+		if err != nil {
+			log.Error().Err(err).Msg("Cannot get quota.")
+
+			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot get quota"))
+			return
+		}
+
+		writeQuotaResponse(w, quota)
+	}
+}
+
+// @Summary Set a quota
+// @Description Set the storage allowed to an uploader, the storage already used is kept. Reserved to the admins.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param owner path string true "Owner"
+// @Param body body server.SetQuotaReq true "Quota"
+// @Success 200 {object} models.Quota
+// @Failure 400 {object} server.ErrorResponse "Error"
+// @Failure 401 {object} server.ErrorResponse "Authentication required"
+// @Failure 403 {object} server.ErrorResponse "Admin access required"
+// @Failure 501 {object} server.ErrorResponse "Quotas are not enabled"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/quotas/{owner} [put]
+func setQuotaHandler(limits config.Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkQuotasEnabled(w, limits) {
+			return
+		}
+
+		var req SetQuotaReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot decode request"))
+			return
+		}
+
+		if req.MaxBytes < 0 {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("max bytes cannot be negative"))
+			return
+		}
+
+		quota := models.NewQuota(mux.Vars(r)["owner"], limits.DefaultQuota)
+		if err := quota.SetMaxBytes(req.MaxBytes); err != nil {
+			log.Error().Err(err).Str("owner", quota.Owner).Msg("Cannot set quota.")
+
+			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot set quota"))
+			return
+		}
+
+		log.Info().Str("owner", quota.Owner).Int64("max_bytes", req.MaxBytes).Msg("quota updated")
+
+		quota, err := quota.Get()
+		if err != nil {
+			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot get quota"))
+			return
+		}
+
+		writeQuotaResponse(w, quota)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/angelorc/go-uploader/auth"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func writeAuthError(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeErrorResponse(w, http.StatusUnauthorized, err)
+}
+
+// requireIdentity rejects the anonymous requests when authentication is enabled.
+func requireIdentity(enabled bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if enabled && auth.FromContext(r.Context()) == nil {
+			writeAuthError(w, auth.ErrNoCredentials)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireAdmin rejects the requests of the callers which are not admins.
+func requireAdmin(admins []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := auth.FromContext(r.Context())
+		if id == nil {
+			writeAuthError(w, auth.ErrNoCredentials)
+			return
+		}
+
+		if !isAdmin(admins, id) {
+			writeErrorResponse(w, http.StatusForbidden, fmt.Errorf("admin access required"))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func isAdmin(admins []string, id *auth.Identity) bool {
+	for _, admin := range admins {
+		if id.Owner() == admin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ownsTranscode reports whether the caller can access the transcode, anyone
+// can when authentication is disabled.
+func ownsTranscode(r *http.Request, authEnabled bool, res *models.Transcoder) bool {
+	return !authEnabled || res.Owner == identityOwner(r)
+}
+
+// identityOwner returns the owner of the caller, empty when anonymous.
+func identityOwner(r *http.Request) string {
+	if id := auth.FromContext(r.Context()); id != nil {
+		return id.Owner()
+	}
+
+	return ""
+}
+
+// uploadAuth is the authorization of an audio upload.
+type uploadAuth struct {
+	// Grant is set for the uploads authorized by a signed URL.
+	Grant *services.UploadGrant
+	// Owner is the identity stored on the transcode.
+	Owner string
+	// JobID is the id of the transcode created by the upload.
+	JobID primitive.ObjectID
+	// Client identifies the caller of the upload rate and quota.
+	Client string
+}
+
+func newUploadAuth(grant *services.UploadGrant, owner string, client string) (*uploadAuth, error) {
+	ua := &uploadAuth{
+		Grant:  grant,
+		Owner:  owner,
+		JobID:  primitive.NewObjectID(),
+		Client: client,
+	}
+
+	if grant != nil {
+		id, err := primitive.ObjectIDFromHex(grant.JobID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job id in the upload signature")
+		}
+
+		ua.JobID = id
+		ua.Owner = grant.Owner
+	}
+
+	return ua, nil
+}
+
+// authorizeUpload authorizes an upload with its signature or the identity of
+// the caller. A signature is required from anonymous callers when uploads are
+// signed, authenticated callers may upload without one. On failure the
+// status of the error response is returned.
+func authorizeUpload(r *http.Request, signer *services.Signer, authEnabled bool) (*uploadAuth, int, error) {
+	var (
+		grant *services.UploadGrant
+		owner string
+	)
+
+	id := auth.FromContext(r.Context())
+
+	switch {
+	case signer != nil && r.URL.Query().Get("signature") != "":
+		g, err := signer.Verify(r.URL.Query(), time.Now())
+		if err != nil {
+			return nil, http.StatusForbidden, err
+		}
+		grant = g
+
+	case id != nil:
+		owner = id.Owner()
+
+	case signer != nil:
+		return nil, http.StatusForbidden, services.ErrSignatureMissing
+
+	case authEnabled:
+		return nil, http.StatusUnauthorized, auth.ErrNoCredentials
+	}
+
+	if grant != nil {
+		owner = grant.Owner
+	}
+
+	ua, err := newUploadAuth(grant, owner, clientKey(r, owner))
+	if err != nil {
+		return nil, http.StatusForbidden, err
+	}
+
+	return ua, http.StatusOK, nil
+}
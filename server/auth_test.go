@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/auth"
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/server"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOwnsTranscodeByMethod(t *testing.T) {
+	res := &models.Transcoder{Owner: "key:artist"}
+
+	tests := []struct {
+		name string
+		id   *auth.Identity
+		owns bool
+	}{
+		{"api key", &auth.Identity{Subject: "artist", Method: auth.MethodAPIKey}, true},
+		{"jwt of the same subject", &auth.Identity{Subject: "artist", Method: auth.MethodJWT}, false},
+		{"anonymous", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.id != nil {
+				r = r.WithContext(auth.WithIdentity(r.Context(), tt.id))
+			}
+
+			require.Equal(t, tt.owns, server.OwnsTranscode(r, true, res))
+		})
+	}
+}
+
+func TestAdminByMethod(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Admins = []string{"key:ops"}
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, auth.Chain{auth.APIKeys{"ops": "admin-token"}, bearerSubject{}}, cfg)
+
+	get := func(header string, value string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/quotas", nil)
+		req.Header.Set(header, value)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	// a JWT whose subject is the name of the admin API key is not an admin
+	require.Equal(t, http.StatusForbidden, get("Authorization", "Bearer ops"))
+	require.Equal(t, http.StatusNotImplemented, get(auth.APIKeyHeader, "admin-token"))
+}
@@ -31,21 +31,78 @@ var doc = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/transcode/{id}": {
+        "/admin/quotas": {
             "get": {
-                "description": "Get transcode status by ID.",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the storage quotas of the uploaders, reserved to the admins.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "transcode"
+                    "admin"
                 ],
-                "summary": "Get transcode status",
+                "summary": "List quotas",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Quota"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Authentication required",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin access required",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "Quotas are not enabled",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/quotas/{owner}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the storage quota of an uploader, by owner, \"key:\u003capi key name\u003e\" or \"jwt:\u003csubject\u003e\", or by \"ip:\u003caddress\u003e\" for the anonymous ones. Reserved to the admins.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get a quota",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "ID",
-                        "name": "id",
+                        "description": "Owner",
+                        "name": "owner",
                         "in": "path",
                         "required": true
                     }
@@ -54,48 +111,72 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.Transcoder"
+                            "$ref": "#/definitions/models.Quota"
                         }
                     },
-                    "400": {
-                        "description": "Failure to parse the id",
+                    "401": {
+                        "description": "Authentication required",
                         "schema": {
                             "$ref": "#/definitions/server.ErrorResponse"
                         }
                     },
-                    "404": {
-                        "description": "Failure to find the id",
+                    "403": {
+                        "description": "Admin access required",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "Quotas are not enabled",
                         "schema": {
                             "$ref": "#/definitions/server.ErrorResponse"
                         }
                     }
                 }
-            }
-        },
-        "/upload/audio": {
-            "post": {
-                "description": "Upload, transcode and publish to ipfs an audio",
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Set the storage allowed to an uploader, the storage already used is kept. Reserved to the admins.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "upload"
+                    "admin"
                 ],
-                "summary": "Upload and transcode audio file",
+                "summary": "Set a quota",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "Transcoder file",
-                        "name": "file",
-                        "in": "formData",
+                        "type": "string",
+                        "description": "Owner",
+                        "name": "owner",
+                        "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "Quota",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/server.SetQuotaReq"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/server.UploadAudioResp"
+                            "$ref": "#/definitions/models.Quota"
                         }
                     },
                     "400": {
@@ -103,79 +184,1547 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/server.ErrorResponse"
                         }
+                    },
+                    "401": {
+                        "description": "Authentication required",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin access required",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "Quotas are not enabled",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/upload/image": {
-            "post": {
-                "description": "Upload, create and publish to ipfs an image",
+        "/formats": {
+            "get": {
+                "description": "List the accepted audio formats with their MIME types, extensions and limits.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "upload"
                 ],
-                "summary": "Upload and create image file",
+                "summary": "List accepted audio formats",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.FormatsResp"
+                        }
+                    }
+                }
+            }
+        },
+        "/image/{id}/{file}": {
+            "get": {
+                "description": "Serve a square cover variant of an uploaded image from the storage, the file names are listed by the variants of the upload, e.g. cover_500.jpg.\nRange requests and conditional requests on the ETag are supported, the variants are cached as immutable.",
+                "produces": [
+                    "image/jpeg"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Get an image variant",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "Image file",
+                        "type": "string",
+                        "description": "Image ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Variant file name",
                         "name": "file",
-                        "in": "formData",
+                        "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "Variant",
                         "schema": {
-                            "$ref": "#/definitions/server.UploadAudioResp"
+                            "type": "string"
                         }
                     },
+                    "304": {
+                        "description": "Not modified"
+                    },
                     "400": {
-                        "description": "Error",
+                        "description": "Failure to parse the id",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Failure to find the variant",
                         "schema": {
                             "$ref": "#/definitions/server.ErrorResponse"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "models.Transcoder": {
-            "type": "object",
-            "properties": {
-                "_id": {
-                    "type": "string"
-                },
-                "percentage": {
-                    "type": "integer"
-                }
-            }
         },
-        "server.ErrorResponse": {
-            "type": "object",
-            "properties": {
-                "error": {
-                    "type": "string"
+        "/stream/{id}/{file}": {
+            "get": {
+                "description": "Serve a playlist, manifest, segment or the MP3 download of a transcode from the storage. The master playlist is master.m3u8, the download download.mp3, the media playlists and segments are below the rendition directories, e.g. 128k/list.m3u8 and 128k/segment000.ts or opus128k/list.m3u8.\nRange requests and conditional requests on the ETag are supported, segments are cached as immutable, the playlists, manifests and download are revalidated on their ETag.",
+                "produces": [
+                    "application/vnd.apple.mpegurl",
+                    "video/mp2t",
+                    "audio/mpeg"
+                ],
+                "tags": [
+                    "transcode"
+                ],
+                "summary": "Stream a transcode output",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Output file",
+                        "name": "file",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Byte range",
+                        "name": "Range",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Output file",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "304": {
+                        "description": "Not modified"
+                    },
+                    "400": {
+                        "description": "Failure to parse the id",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Failure to find the file",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "416": {
+                        "description": "Invalid range"
+                    }
                 }
             }
         },
-        "server.UploadAudioResp": {
-            "type": "object",
-            "properties": {
-                "duration": {
-                    "type": "number"
-                },
-                "file_name": {
-                    "type": "string"
-                },
-                "id": {
-                    "type": "string"
+        "/transcode/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get transcode status by ID.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transcode"
+                ],
+                "summary": "Get transcode status",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Transcoder"
+                        }
+                    },
+                    "400": {
+                        "description": "Failure to parse the id",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Authentication required",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Failure to find the id",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
                 }
             }
+        },
+        "/transcode/{id}/events": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stream the status and progress changes of a transcode as Server-Sent Events.\nThe stream is closed once the transcode is done or failed, it can be resumed with the Last-Event-ID header.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "transcode"
+                ],
+                "summary": "Stream transcode status",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Last received event id",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/events.Event"
+                        }
+                    },
+                    "400": {
+                        "description": "Failure to parse the id",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Authentication required",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Failure to find the id",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/transcode/{id}/tags": {
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Update the ID3 tags and the cover written into the MP3 download of a transcode, only the fields sent are changed and an empty value clears a tag.\nThe download of a done transcode is tagged again, the tags of a queued or failed one are written once transcoded.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transcode"
+                ],
+                "summary": "Update transcode tags",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Title",
+                        "name": "title",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Artist",
+                        "name": "artist",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Album",
+                        "name": "album",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ISRC",
+                        "name": "isrc",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Year, YYYY or YYYY-MM-DD",
+                        "name": "year",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Keep the tags of the original, true by default",
+                        "name": "keep_source_tags",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Cover, jpeg or png",
+                        "name": "cover",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Transcoder"
+                        }
+                    },
+                    "400": {
+                        "description": "Failure to parse the id or the tags",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Authentication required",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Failure to find the id",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Transcode is running",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Cover too large",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/transcode/{id}/waveform": {
+            "get": {
+                "description": "Get the waveform peaks of a transcode, the min and max 8 bit sample of every bucket of samples_per_pixel samples, in the JSON or binary dat format of audiowaveform.\nThe available samples per pixel are 512, 1024, 2048 and 4096. Range requests and conditional requests on the ETag are supported.",
+                "produces": [
+                    "application/json",
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "transcode"
+                ],
+                "summary": "Get transcode waveform",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Samples per pixel, 512 by default",
+                        "name": "samples_per_pixel",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "json (default) or dat",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Waveform",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "304": {
+                        "description": "Not modified"
+                    },
+                    "400": {
+                        "description": "Failure to parse the id or the parameters",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Failure to find the waveform",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tus": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Create a tus 1.0 resumable audio upload, the filename, profile and dedup options are read from the metadata.\nThe title, artist, album, isrc, year and keep_source_tags tags of the MP3 download are read from the metadata too, the cover is set by the tags update.",
+                "tags": [
+                    "tus"
+                ],
+                "summary": "Create a resumable upload",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "1.0.0",
+                        "name": "Tus-Resumable",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Size of the upload in bytes",
+                        "name": "Upload-Length",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "filename and profile, base64 encoded",
+                        "name": "Upload-Metadata",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Upload signature, required when uploads are signed",
+                        "name": "signature",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "201": {},
+                    "400": {
+                        "description": "Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Invalid upload signature",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Upload signature already used",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "412": {
+                        "description": "Unsupported tus version",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Upload too large",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Upload rate exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Transcode queue is full",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "507": {
+                        "description": "Storage quota exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "options": {
+                "description": "Get the tus protocol version, extensions and limits of the server.",
+                "tags": [
+                    "tus"
+                ],
+                "summary": "Discover the tus server",
+                "responses": {
+                    "204": {}
+                }
+            }
+        },
+        "/tus/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Remove an upload which has not been handed to the transcode queue yet.",
+                "tags": [
+                    "tus"
+                ],
+                "summary": "Terminate a resumable upload",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "1.0.0",
+                        "name": "Tus-Resumable",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Upload ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Upload signature, required for the uploads created with a signed URL",
+                        "name": "signature",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {},
+                    "404": {
+                        "description": "Upload not found or created by another caller",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Upload already transcoding",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "423": {
+                        "description": "Upload in use by another request",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "head": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the number of bytes received, to resume the upload. Once completed the transcode id is returned in Upload-Transcode-Id.",
+                "tags": [
+                    "tus"
+                ],
+                "summary": "Get the offset of a resumable upload",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "1.0.0",
+                        "name": "Tus-Resumable",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Upload ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Upload signature, required for the uploads created with a signed URL",
+                        "name": "signature",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {},
+                    "404": {
+                        "description": "Upload not found or created by another caller",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Append a chunk at Upload-Offset. The completed upload is checked and handed to the transcode queue, its id is returned in Upload-Transcode-Id.\nA completed upload which could not be handed to the queue, e.g. on a 503 or 507 response, is kept and handed again by an empty chunk at Upload-Length.",
+                "consumes": [
+                    "application/offset+octet-stream"
+                ],
+                "tags": [
+                    "tus"
+                ],
+                "summary": "Upload a chunk",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "1.0.0",
+                        "name": "Tus-Resumable",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Offset of the chunk",
+                        "name": "Upload-Offset",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Checksum algorithm and base64 digest of the chunk",
+                        "name": "Upload-Checksum",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Upload ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Upload signature, required for the uploads created with a signed URL",
+                        "name": "signature",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {},
+                    "400": {
+                        "description": "Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Upload not found or created by another caller",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Offset mismatch",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Wrong content type",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "423": {
+                        "description": "Upload in use by another request",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "460": {
+                        "description": "Checksum mismatch",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Transcode queue is full",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "507": {
+                        "description": "Storage quota exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/audio": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Upload, transcode and publish to ipfs an audio, detected from the file content, in one of the formats listed by /formats",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Upload and transcode audio file",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Transcoder file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Output profile, default or cmaf unless configured otherwise",
+                        "name": "profile",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Title written into the MP3 download",
+                        "name": "title",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Artist written into the MP3 download",
+                        "name": "artist",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Album written into the MP3 download",
+                        "name": "album",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ISRC written into the MP3 download",
+                        "name": "isrc",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Year written into the MP3 download, YYYY or YYYY-MM-DD",
+                        "name": "year",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Keep the tags of the original in the MP3 download, true by default",
+                        "name": "keep_source_tags",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Cover embedded in the MP3 download, jpeg or png",
+                        "name": "cover",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the outputs of a previous job of the same original instead of transcoding it again, true when omitted and no tags are sent",
+                        "name": "dedup",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Signed upload job id",
+                        "name": "job",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Signed upload max size",
+                        "name": "max_size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Signed upload formats",
+                        "name": "types",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Signed upload expiry",
+                        "name": "expires",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Upload signature, required when uploads are signed",
+                        "name": "signature",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.UploadAudioResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Invalid upload signature",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Upload signature already used",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Upload too large",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Format not allowed by the signature",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Upload rate exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Transcode queue is full",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "507": {
+                        "description": "Storage quota exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/image": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Upload an image (jpeg, png, webp or gif) and create the square cover variants, served by /image/{id}/{file}",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Upload and create image file",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Image file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.UploadImageResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Upload too large",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Upload rate exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Failure to save the image",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/sign": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Issue a short-lived signed URL authorizing a single audio upload, for the multipart and the tus endpoints.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Sign an upload URL",
+                "parameters": [
+                    {
+                        "description": "Upload constraints",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/server.SignUploadReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.SignUploadResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Authentication required",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "Upload signing is not configured",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "events.Event": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "percentage": {
+                    "type": "integer"
+                },
+                "stage": {
+                    "type": "string"
+                },
+                "stage_percentage": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.AudioMetadata": {
+            "type": "object",
+            "properties": {
+                "album": {
+                    "type": "string"
+                },
+                "album_artist": {
+                    "type": "string"
+                },
+                "artist": {
+                    "type": "string"
+                },
+                "bit_rate": {
+                    "type": "integer"
+                },
+                "date": {
+                    "type": "string"
+                },
+                "disc": {
+                    "type": "string"
+                },
+                "duration": {
+                    "type": "number"
+                },
+                "format": {
+                    "type": "string"
+                },
+                "genre": {
+                    "type": "string"
+                },
+                "streams": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.StreamMetadata"
+                    }
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "title": {
+                    "type": "string"
+                },
+                "track": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ImageVariant": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "file_name": {
+                    "type": "string"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "width": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.Loudness": {
+            "type": "object",
+            "properties": {
+                "integrated": {
+                    "type": "number"
+                },
+                "lra": {
+                    "type": "number"
+                },
+                "target": {
+                    "type": "number"
+                },
+                "threshold": {
+                    "type": "number"
+                },
+                "track_gain": {
+                    "type": "number"
+                },
+                "track_peak": {
+                    "type": "number"
+                },
+                "true_peak": {
+                    "type": "number"
+                }
+            }
+        },
+        "models.Manifest": {
+            "type": "object",
+            "properties": {
+                "cid": {
+                    "type": "string"
+                },
+                "file_name": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Quota": {
+            "type": "object",
+            "properties": {
+                "max_bytes": {
+                    "type": "integer"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "uploads": {
+                    "type": "integer"
+                },
+                "used_bytes": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.StreamMetadata": {
+            "type": "object",
+            "properties": {
+                "bit_depth": {
+                    "type": "integer"
+                },
+                "bit_rate": {
+                    "type": "integer"
+                },
+                "channel_layout": {
+                    "type": "string"
+                },
+                "channels": {
+                    "type": "integer"
+                },
+                "codec_name": {
+                    "type": "string"
+                },
+                "codec_type": {
+                    "type": "string"
+                },
+                "duration": {
+                    "type": "number"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "profile": {
+                    "type": "string"
+                },
+                "sample_rate": {
+                    "type": "integer"
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.TrackTags": {
+            "type": "object",
+            "properties": {
+                "album": {
+                    "type": "string"
+                },
+                "artist": {
+                    "type": "string"
+                },
+                "cover_mime": {
+                    "type": "string"
+                },
+                "drop_source_tags": {
+                    "type": "boolean"
+                },
+                "isrc": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "year": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Transcoder": {
+            "type": "object",
+            "properties": {
+                "_id": {
+                    "type": "string"
+                },
+                "attempts": {
+                    "type": "integer"
+                },
+                "cid": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "duration": {
+                    "type": "number"
+                },
+                "error": {
+                    "description": "Error is the reason of the failure when the status is failed.",
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "loudness": {
+                    "description": "Loudness is measured before transcoding.",
+                    "type": "object",
+                    "$ref": "#/definitions/models.Loudness"
+                },
+                "manifests": {
+                    "description": "Manifests are the HLS and DASH manifests available once the job is done.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Manifest"
+                    }
+                },
+                "metadata": {
+                    "description": "Metadata is the ffprobe metadata and tags of the original.",
+                    "type": "object",
+                    "$ref": "#/definitions/models.AudioMetadata"
+                },
+                "owner": {
+                    "description": "Owner is the identity of the caller which uploaded the audio.",
+                    "type": "string"
+                },
+                "percentage": {
+                    "type": "integer"
+                },
+                "profile": {
+                    "description": "Profile is the name of the HLS output profile.",
+                    "type": "string"
+                },
+                "sha256": {
+                    "description": "Sha256 is the hash of the original.",
+                    "type": "string"
+                },
+                "stages": {
+                    "description": "Stages holds the percentage of every transcoding stage.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "stderr": {
+                    "description": "Stderr holds the last lines written by ffmpeg when the status is failed.",
+                    "type": "string"
+                },
+                "tags": {
+                    "description": "Tags are the tags of the MP3 download.",
+                    "type": "object",
+                    "$ref": "#/definitions/models.TrackTags"
+                },
+                "upload_id": {
+                    "description": "UploadID is the working directory of the upload.",
+                    "type": "string"
+                }
+            }
+        },
+        "server.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "server.FormatsResp": {
+            "type": "object",
+            "properties": {
+                "accept": {
+                    "description": "Accept is the value of the accept attribute of a file input.",
+                    "type": "string"
+                },
+                "formats": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/transcoder.AudioFormat"
+                    }
+                }
+            }
+        },
+        "server.SetQuotaReq": {
+            "type": "object",
+            "properties": {
+                "max_bytes": {
+                    "description": "MaxBytes is the storage allowed to the owner, unlimited when 0.",
+                    "type": "integer"
+                }
+            }
+        },
+        "server.SignUploadReq": {
+            "type": "object",
+            "properties": {
+                "max_size": {
+                    "description": "MaxSize is the largest accepted file in bytes, the format limits when 0.",
+                    "type": "integer"
+                },
+                "owner": {
+                    "description": "Owner is the identity stored on the transcode, the caller when empty,\ne.g. jwt:\u003csubject\u003e for a JWT user. It is set by the admins and the API\nkey callers only, it is the caller for the others.",
+                    "type": "string"
+                },
+                "ttl": {
+                    "description": "TTL is the validity of the URL in seconds, the configured maximum when 0.",
+                    "type": "integer"
+                },
+                "types": {
+                    "description": "Types are the accepted audio formats, all of them when empty.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "server.SignUploadResp": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "job_id": {
+                    "type": "string"
+                },
+                "tus_url": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "server.UploadAudioResp": {
+            "type": "object",
+            "properties": {
+                "cid": {
+                    "type": "string"
+                },
+                "deduplicated": {
+                    "description": "Deduplicated is set when the original was already transcoded, Id is\nthen the previous job and Cid and Manifests its outputs.",
+                    "type": "boolean"
+                },
+                "duration": {
+                    "type": "number"
+                },
+                "file_name": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "manifests": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Manifest"
+                    }
+                },
+                "metadata": {
+                    "description": "Metadata holds the format, streams and tags of the original.",
+                    "type": "object",
+                    "$ref": "#/definitions/models.AudioMetadata"
+                }
+            }
+        },
+        "server.UploadImageResp": {
+            "type": "object",
+            "properties": {
+                "file_name": {
+                    "type": "string"
+                },
+                "format": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "variants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ImageVariant"
+                    }
+                }
+            }
+        },
+        "transcoder.AudioFormat": {
+            "type": "object",
+            "properties": {
+                "codecs": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "extensions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "ffprobe_formats": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "lossless": {
+                    "type": "boolean"
+                },
+                "lossless_codecs": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "max_duration": {
+                    "description": "MaxDuration is the longest accepted audio, in seconds.",
+                    "type": "integer"
+                },
+                "max_size": {
+                    "description": "MaxSize is the largest accepted file, in bytes.",
+                    "type": "integer"
+                },
+                "mime_types": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
+        "BearerAuth": {
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
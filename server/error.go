@@ -3,6 +3,7 @@ package server
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 )
 
 // ErrorResponse defines an error response returned upon any request failure.
@@ -17,3 +18,10 @@ func writeErrorResponse(w http.ResponseWriter, status int, err error) {
 	bz, _ := json.Marshal(ErrorResponse{Error: err.Error()})
 	_, _ = w.Write(bz)
 }
+
+// writeRetryResponse writes an error response asking the client to retry the
+// request after the given number of seconds.
+func writeRetryResponse(w http.ResponseWriter, status int, retryAfter int, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeErrorResponse(w, status, err)
+}
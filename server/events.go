@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// heartbeatInterval is the delay between two comments sent to keep idle
+// event streams open.
+const heartbeatInterval = 15 * time.Second
+
+// @Summary Stream transcode status
+// @Description Stream the status and progress changes of a transcode as Server-Sent Events.
+// @Description The stream is closed once the transcode is done or failed, it can be resumed with the Last-Event-ID header.
+// @Tags transcode
+// @Produce text/event-stream
+// @Param id path string true "ID"
+// @Param Last-Event-ID header integer false "Last received event id"
+// @Success 200 {object} events.Event
+// @Failure 400 {object} server.ErrorResponse "Failure to parse the id"
+// @Failure 401 {object} server.ErrorResponse "Authentication required"
+// @Failure 404 {object} server.ErrorResponse "Failure to find the id"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /transcode/{id}/events [get]
+func transcodeEventsHandler(broker *events.Broker, authEnabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params = mux.Vars(r)
+		id := params["id"]
+
+		pid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot decode id"))
+			return
+		}
+
+		var lastEventID uint64
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			lastEventID, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot decode Last-Event-ID"))
+				return
+			}
+		}
+
+		tm := &models.Transcoder{
+			ID: pid,
+		}
+
+		// the transcodes of other callers are reported as missing
+		if authEnabled {
+			res, err := tm.Get()
+			if err != nil || !ownsTranscode(r, authEnabled, res) {
+				writeErrorResponse(w, http.StatusNotFound, fmt.Errorf("id not found"))
+				return
+			}
+		}
+
+		sub := broker.Subscribe(pid.Hex(), lastEventID)
+		defer sub.Close()
+
+		// the broker knows nothing about jobs finished before the retention
+		// delay or before a restart, start from the stored status
+		var snapshot *events.Event
+		if !sub.Known {
+			res, err := tm.Get()
+			if err != nil {
+				writeErrorResponse(w, http.StatusNotFound, fmt.Errorf("id not found"))
+				return
+			}
+
+			snapshot = &events.Event{
+				Type:       events.TypeStatus,
+				Status:     res.Status,
+				Percentage: res.Percentage,
+				Error:      res.Error,
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		if snapshot != nil {
+			if err := writeEvent(w, *snapshot); err != nil || snapshot.Terminal() {
+				return
+			}
+		}
+
+		for _, e := range sub.Events {
+			if err := writeEvent(w, e); err != nil || e.Terminal() {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+
+				if err := writeEvent(w, e); err != nil || e.Terminal() {
+					return
+				}
+
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				flush(w)
+
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes e in the Server-Sent Events format, events without id
+// are not resumable.
+func writeEvent(w http.ResponseWriter, e events.Event) error {
+	bz, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if e.ID > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", e.ID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, bz); err != nil {
+		return err
+	}
+
+	flush(w)
+
+	return nil
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
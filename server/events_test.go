@@ -0,0 +1,69 @@
+package server_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/server"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTranscodeEvents(t *testing.T) {
+	broker := events.NewBroker(time.Minute)
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), broker, nil, nil, config.DefaultConfig())
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	id := primitive.NewObjectID().Hex()
+	broker.Publish(id, events.Event{Type: events.TypeStatus, Status: models.StatusQueued})
+	broker.Publish(id, events.Event{Type: events.TypeStatus, Status: models.StatusRunning})
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/transcode/"+id+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		broker.Publish(id, events.Event{Type: events.TypeProgress, Status: models.StatusRunning, Percentage: 40, Stage: "transcode", StagePercentage: 80})
+		broker.Publish(id, events.Event{Type: events.TypeStatus, Status: models.StatusDone, Percentage: 100})
+	}()
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	// the body ends when the server closes the finished stream
+	bz, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	expected := strings.Join([]string{
+		"id: 2\nevent: status\ndata: {\"status\":\"running\",\"percentage\":0}\n",
+		"id: 3\nevent: progress\ndata: {\"status\":\"running\",\"percentage\":40,\"stage\":\"transcode\",\"stage_percentage\":80}\n",
+		"id: 4\nevent: status\ndata: {\"status\":\"done\",\"percentage\":100}\n",
+	}, "\n") + "\n"
+	require.Equal(t, expected, string(bz))
+}
+
+func TestTranscodeEventsInvalidID(t *testing.T) {
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, config.DefaultConfig())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/transcode/invalid/events", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
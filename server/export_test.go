@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/angelorc/go-uploader/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StubTranscodeExists replaces the lookup of the signed transcodes for the
+// duration of the test.
+func StubTranscodeExists(t *testing.T, exists func(id primitive.ObjectID) (bool, error)) {
+	previous := transcodeExists
+	transcodeExists = exists
+
+	t.Cleanup(func() {
+		transcodeExists = previous
+	})
+}
+
+// OwnsTranscode reports whether the caller of r can access the transcode.
+func OwnsTranscode(r *http.Request, authEnabled bool, res *models.Transcoder) bool {
+	return ownsTranscode(r, authEnabled, res)
+}
+
+// TagLocks serializes the tags updates of each transcode.
+type TagLocks = tagLocks
+
+func NewTagLocks() *TagLocks {
+	return newTagLocks()
+}
+
+func (l *tagLocks) Lock(id string) func() {
+	return l.lock(id)
+}
+
+// Len returns the number of transcodes locked or waited for.
+func (l *tagLocks) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.locks)
+}
+
+// StubClaimGrant replaces the records of the used grants for the duration of
+// the test.
+func StubClaimGrant(t *testing.T, claim func(id primitive.ObjectID) (bool, error)) {
+	previous := claimGrant
+	claimGrant = claim
+
+	t.Cleanup(func() {
+		claimGrant = previous
+	})
+}
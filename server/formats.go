@@ -0,0 +1,39 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/angelorc/go-uploader/transcoder"
+)
+
+type FormatsResp struct {
+	// Accept is the value of the accept attribute of a file input.
+	Accept  string                   `json:"accept"`
+	Formats []transcoder.AudioFormat `json:"formats"`
+}
+
+// @Summary List accepted audio formats
+// @Description List the accepted audio formats with their MIME types, extensions and limits.
+// @Tags upload
+// @Produce json
+// @Success 200 {object} server.FormatsResp
+// @Router /formats [get]
+func getFormatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var accept []string
+		for _, f := range transcoder.AudioFormats {
+			accept = append(accept, f.MimeTypes...)
+			accept = append(accept, f.Extensions...)
+		}
+
+		res := FormatsResp{
+			Accept:  strings.Join(accept, ","),
+			Formats: transcoder.AudioFormats,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
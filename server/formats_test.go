@@ -0,0 +1,37 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/server"
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFormats(t *testing.T) {
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, config.DefaultConfig())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/formats", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var res server.FormatsResp
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Len(t, res.Formats, len(transcoder.AudioFormats))
+
+	accept := strings.Split(res.Accept, ",")
+	for _, v := range []string{"audio/mpeg", "audio/flac", "audio/ogg", "audio/x-aiff", "audio/mp4", ".flac", ".m4a"} {
+		require.Contains(t, accept, v)
+	}
+}
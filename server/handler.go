@@ -1,14 +1,23 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/angelorc/go-uploader/auth"
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
 	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/queue"
 	"github.com/angelorc/go-uploader/services"
+	"github.com/angelorc/go-uploader/storage"
 	"github.com/angelorc/go-uploader/transcoder"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
+	"os"
+	"strconv"
 
 	_ "github.com/angelorc/go-uploader/server/docs"
 	"github.com/gorilla/mux"
@@ -19,102 +28,189 @@ const (
 	methodGET  = "GET"
 	methodPOST = "POST"
 
-	MAX_AUDIO_LENGTH = 610
+	// seconds a client should wait before retrying when the queue is full
+	queueRetryAfter = 30
 )
 
-// RegisterRoutes registers all HTTP routes with the provided mux router.
-func RegisterRoutes(r *mux.Router, q chan *transcoder.Transcoder) {
+// RegisterRoutes registers all HTTP routes with the provided mux router. When
+// authn is nil the API is open to anyone, otherwise the caller identity is
+// attached to the requests and required by the protected routes. The outputs
+// of the transcodes are streamed from store. The background work of the
+// routes stops once ctx is done.
+func RegisterRoutes(ctx context.Context, r *mux.Router, q *queue.Queue, broker *events.Broker, store storage.Storage, authn auth.Authenticator, cfg config.Config) {
 	r.PathPrefix("/swagger/").Handler(httpswagger.WrapHandler)
 
-	r.HandleFunc("/api/v1/upload/audio", uploadAudioHandler(q)).Methods(methodPOST)
-	r.HandleFunc("api/v1/upload/image", uploadImageHandler()).Methods(methodPOST)
+	api := r.PathPrefix("/api/v1").Subrouter()
 
-	r.HandleFunc("/api/v1/transcode/{id}", getTranscodeHandler()).Methods(methodGET)
+	authEnabled := authn != nil
+	if authEnabled {
+		api.Use(auth.Middleware(authn, writeAuthError))
+	}
+
+	api.HandleFunc("/formats", getFormatsHandler()).Methods(methodGET)
+
+	signer := newSigner(cfg)
+	limits := newUploadLimits(ctx, cfg.Limits)
+
+	api.HandleFunc("/upload/sign", signUploadHandler(signer, cfg)).Methods(methodPOST)
+	api.HandleFunc("/upload/audio", uploadAudioHandler(q, broker, store, signer, limits, authEnabled, cfg)).Methods(methodPOST)
+	api.HandleFunc("/upload/image", requireIdentity(authEnabled, limits.limitRate(uploadImageHandler(store, cfg.Uploads.MaxSize)))).Methods(methodPOST)
+	registerTusRoutes(api, q, broker, signer, limits, authEnabled, cfg)
+
+	api.HandleFunc("/transcode/{id}", requireIdentity(authEnabled, getTranscodeHandler(authEnabled))).Methods(methodGET)
+	api.HandleFunc("/transcode/{id}/events", requireIdentity(authEnabled, transcodeEventsHandler(broker, authEnabled))).Methods(methodGET)
+	api.HandleFunc("/transcode/{id}/tags", requireIdentity(authEnabled, updateTagsHandler(store, authEnabled))).Methods(methodPATCH)
+	api.HandleFunc("/transcode/{id}/waveform", waveformHandler(store)).Methods(methodGET, methodHEAD)
+	api.HandleFunc("/stream/{id}/{file:.+}", streamHandler(store)).Methods(methodGET, methodHEAD)
+	api.HandleFunc("/image/{id}/{file}", imageHandler(store)).Methods(methodGET, methodHEAD)
+
+	registerAdminRoutes(api, cfg)
 }
 
 type UploadAudioResp struct {
 	Id       string  `json:"id"`
 	FileName string  `json:"file_name"`
 	Duration float32 `json:"duration"`
+	// Deduplicated is set when the original was already transcoded, Id is
+	// then the previous job and Cid and Manifests its outputs.
+	Deduplicated bool              `json:"deduplicated,omitempty"`
+	Cid          string            `json:"cid,omitempty"`
+	Manifests    []models.Manifest `json:"manifests,omitempty"`
+	// Metadata holds the format, streams and tags of the original.
+	Metadata *models.AudioMetadata `json:"metadata,omitempty"`
 }
 
 // @Summary Upload and transcode audio file
-// @Description Upload, transcode and publish to ipfs an audio
+// @Description Upload, transcode and publish to ipfs an audio, detected from the file content, in one of the formats listed by /formats
 // @Tags upload
 // @Produce json
 // @Param file formData file true "Transcoder file"
+// @Param profile formData string false "Output profile, default or cmaf unless configured otherwise"
+// @Param title formData string false "Title written into the MP3 download"
+// @Param artist formData string false "Artist written into the MP3 download"
+// @Param album formData string false "Album written into the MP3 download"
+// @Param isrc formData string false "ISRC written into the MP3 download"
+// @Param year formData string false "Year written into the MP3 download, YYYY or YYYY-MM-DD"
+// @Param keep_source_tags formData boolean false "Keep the tags of the original in the MP3 download, true by default"
+// @Param cover formData file false "Cover embedded in the MP3 download, jpeg or png"
+// @Param dedup query boolean false "Return the outputs of a previous job of the same original instead of transcoding it again, true when omitted and no tags are sent"
+// @Param job query string false "Signed upload job id"
+// @Param max_size query integer false "Signed upload max size"
+// @Param types query string false "Signed upload formats"
+// @Param expires query integer false "Signed upload expiry"
+// @Param signature query string false "Upload signature, required when uploads are signed"
 // @Success 200 {object} server.UploadAudioResp
 // @Failure 400 {object} server.ErrorResponse "Error"
+// @Failure 403 {object} server.ErrorResponse "Invalid upload signature"
+// @Failure 409 {object} server.ErrorResponse "Upload signature already used"
+// @Failure 413 {object} server.ErrorResponse "Upload too large"
+// @Failure 415 {object} server.ErrorResponse "Format not allowed by the signature"
+// @Failure 429 {object} server.ErrorResponse "Upload rate exceeded"
+// @Failure 503 {object} server.ErrorResponse "Transcode queue is full"
+// @Failure 507 {object} server.ErrorResponse "Storage quota exceeded"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /upload/audio [post]
-func uploadAudioHandler(q chan *transcoder.Transcoder) http.HandlerFunc {
+func uploadAudioHandler(q *queue.Queue, broker *events.Broker, store storage.Storage, signer *services.Signer, limits *uploadLimits, authEnabled bool, cfg config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		file, header, err := r.FormFile("file")
-		if err != nil {
-			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("file field is required"))
+		// reject early, before reading the body, when no worker can accept the job
+		if q.Full() {
+			writeRetryResponse(w, http.StatusServiceUnavailable, queueRetryAfter, queue.ErrQueueFull)
 			return
 		}
-		defer file.Close()
 
-		log.Info().Str("filename", header.Filename).Msg("handling new upload...")
+		ua, status, err := authorizeUpload(r, signer, authEnabled)
+		if err != nil {
+			writeErrorResponse(w, status, err)
+			return
+		}
 
-		uploader := services.NewUploader(file, header)
+		// rejected before reading the body of a replayed signed URL
+		if !checkGrantUnused(w, ua) {
+			return
+		}
 
-		// check if the file is audio
-		log.Info().Str("filename", header.Filename).Msg("check if the file is audio")
+		if !limits.allow(w, ua.Client) {
+			return
+		}
 
-		if !uploader.IsAudio() {
-			log.Error().Str("content-type", uploader.GetContentType()).Msg("Wrong content type")
+		dedup, err := parseDedup(r.URL.Query().Get("dedup"))
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
 
-			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("Wrong content type: %s", uploader.GetContentType()))
+		file, header, ok := readUploadForm(w, r, cfg.Uploads.MaxSize)
+		if !ok {
 			return
 		}
+		defer file.Close()
 
-		// save original file
-		_, err = uploader.SaveOriginal()
-		log.Info().Str("filename", header.Filename).Msg("file save original")
+		log.Info().Str("filename", header.Filename).Msg("handling new upload...")
 
+		profile, err := lookupProfile(cfg, r.FormValue("profile"))
 		if err != nil {
-			log.Error().Str("filename", uploader.Header.Filename).Msg("Cannot save audio file.")
-
-			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("Cannot save audio file %s", uploader.Header.Filename))
+			writeErrorResponse(w, http.StatusBadRequest, err)
 			return
 		}
 
-		// check file size
-		// check duration
-		tm := models.NewTranscoder()
-		if err := tm.Create(); err != nil {
-			writeErrorResponse(w, http.StatusBadRequest, err)
+		tags, cover, ok := readUploadTags(w, r)
+		if !ok {
 			return
 		}
 
-		audio := transcoder.NewTranscoder(uploader, tm.ID)
-		log.Info().Str("filename", header.Filename).Msg("check audio duration")
+		uploader := services.NewUploader(file, header)
 
-		duration, err := audio.GetDuration()
+		// detect the format from the content, the client Content-Type is not trusted
+		log.Info().Str("filename", header.Filename).Msg("check if the file is audio")
+
+		format, err := detectAudioFormat(uploader)
 		if err != nil {
-			log.Error().Str("filename", uploader.Header.Filename).Msg("Cannot get audio duration.")
+			log.Error().Err(err).Str("filename", header.Filename).Str("content-type", uploader.GetContentType()).Msg("Rejected audio file")
 
-			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("Cannot get audio duration"))
+			writeErrorResponse(w, http.StatusBadRequest, err)
 			return
 		}
 
-		if duration > MAX_AUDIO_LENGTH {
-			log.Error().Float32("duration", duration).Msg("File length is too big")
+		if status, err := checkGrant(ua.Grant, format, header.Size); err != nil {
+			writeErrorResponse(w, status, err)
+			return
+		}
 
-			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("File length is too big"))
+		if !useGrant(w, ua) {
 			return
 		}
 
-		// transcode audio
-		log.Info().Str("filename", header.Filename).Msg("transcode audio")
+		// save original file
+		original, err := uploader.SaveOriginal(cfg.Uploads.MaxSize)
+		log.Info().Str("filename", header.Filename).Str("format", format.Name).Msg("file save original")
 
-		q <- audio
+		var tooLarge *services.TooLargeError
+		if errors.As(err, &tooLarge) {
+			writeErrorResponse(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
 
-		res := UploadAudioResp{
-			Id: tm.ID.Hex(),
-			FileName: uploader.Header.Filename,
-			Duration: duration,
+		if err != nil {
+			log.Error().Str("filename", uploader.Header.Filename).Msg("Cannot save audio file.")
+
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("Cannot save audio file %s", uploader.Header.Filename))
+			return
+		}
+		original.Close()
+
+		// stored first, the download may be written as soon as enqueued
+		if cover != nil && !storeCover(w, store, ua.JobID.Hex(), cover) {
+			_ = os.RemoveAll(uploader.GetDir())
+			return
+		}
+
+		res, ok := enqueueAudio(w, q, broker, limits, uploader, format, ua, profile, dedup, tags, false)
+		if !ok && cover != nil {
+			_ = store.Delete(ua.JobID.Hex() + "/" + transcoder.CoverFileName)
+		}
+		if !ok {
+			return
 		}
 
 		bz, err := json.Marshal(res)
@@ -130,18 +226,340 @@ func uploadAudioHandler(q chan *transcoder.Transcoder) http.HandlerFunc {
 	}
 }
 
+// lookupProfile returns the requested output profile, the default one when
+// empty, or an error when it is not configured.
+func lookupProfile(cfg config.Config, profile string) (string, error) {
+	if profile == "" {
+		profile = transcoder.DefaultProfileName
+	}
+
+	if _, ok := cfg.Transcoder.Profiles[profile]; !ok {
+		return "", fmt.Errorf("unknown profile: %s", profile)
+	}
+
+	return profile, nil
+}
+
+// detectAudioFormat returns the registered format matching the content of the upload.
+func detectAudioFormat(uploader *services.Uploader) (transcoder.AudioFormat, error) {
+	sniff, err := uploader.Sniff(transcoder.SniffLength)
+	if err != nil {
+		return transcoder.AudioFormat{}, fmt.Errorf("cannot read audio file %s", uploader.Header.Filename)
+	}
+
+	name, err := transcoder.DetectAudioFormat(sniff)
+	if err != nil {
+		return transcoder.AudioFormat{}, fmt.Errorf("unsupported file: %w", err)
+	}
+
+	format, ok := transcoder.LookupAudioFormat(name)
+	if !ok {
+		return transcoder.AudioFormat{}, fmt.Errorf("unsupported file: %s is not accepted", name)
+	}
+
+	return format, nil
+}
+
+// parseDedup parses the dedup option of an upload, enabled when empty.
+func parseDedup(value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+
+	dedup, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid dedup: %s", value)
+	}
+
+	return dedup, nil
+}
+
+// findDuplicate returns the response of a previous job of the same owner and
+// profile which transcoded the same original, when dedup is enabled. The
+// uploads signed with a job id always create their job.
+func findDuplicate(tm *models.Transcoder, ua *uploadAuth, dedup bool) (*UploadAudioResp, error) {
+	if !dedup || ua.Grant != nil {
+		return nil, nil
+	}
+
+	dup, err := tm.FindDuplicate()
+	if err != nil || dup == nil {
+		return nil, err
+	}
+
+	return &UploadAudioResp{
+		Id:           dup.ID.Hex(),
+		Duration:     dup.Duration,
+		Deduplicated: true,
+		Cid:          dup.Cid,
+		Manifests:    dup.Manifests,
+		Metadata:     dup.Metadata,
+	}, nil
+}
+
+// enqueueAudio checks the saved original against the format rules, reserves
+// its storage, creates the transcode and enqueues it. An original already
+// transcoded returns the previous job instead, unless dedup is disabled or
+// tags are set, which make a download of its own. On failure the error
+// response is written, the upload removed and false returned. A resumable
+// upload is kept when the client can retry, which enqueues it again.
+func enqueueAudio(w http.ResponseWriter, q *queue.Queue, broker *events.Broker, limits *uploadLimits, uploader *services.Uploader, format transcoder.AudioFormat, ua *uploadAuth, profile string, dedup bool, tags *models.TrackTags, resumable bool) (UploadAudioResp, bool) {
+	header := uploader.Header
+
+	discard := func(retry bool) {
+		if !resumable || !retry {
+			_ = os.RemoveAll(uploader.GetDir())
+		}
+	}
+
+	tm := models.NewTranscoder()
+	tm.ID = ua.JobID
+	tm.Owner = ua.Owner
+	tm.Profile = profile
+	tm.UploadID = uploader.GetID()
+	tm.Tags = tags
+
+	sum, err := uploader.GetSha256()
+	if err != nil {
+		log.Error().Err(err).Str("filename", header.Filename).Msg("Cannot hash audio file.")
+
+		discard(true)
+
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot hash audio file"))
+		return UploadAudioResp{}, false
+	}
+	tm.Sha256 = sum
+
+	dup, err := findDuplicate(tm, ua, dedup && tags == nil)
+	if err != nil {
+		// not fatal, the original is transcoded again
+		log.Warn().Err(err).Str("sha256", sum).Msg("Cannot look up duplicate transcode.")
+	}
+
+	if dup != nil {
+		log.Info().Str("filename", header.Filename).Str("sha256", sum).Str("transcode", dup.Id).Msg("audio already transcoded")
+
+		// a resumable upload keeps its state, which records the job, until
+		// removed by the janitor
+		if !resumable {
+			_ = os.RemoveAll(uploader.GetDir())
+		}
+
+		dup.FileName = header.Filename
+		return *dup, true
+	}
+
+	audio := transcoder.NewTranscoder(uploader, tm.ID)
+
+	// the signature is confirmed by probing the streams
+	if err := audio.CheckAudio(); err != nil {
+		log.Error().Err(err).Str("filename", header.Filename).Msg("Rejected audio file")
+
+		discard(false)
+
+		writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("unsupported file: %w", err))
+		return UploadAudioResp{}, false
+	}
+
+	// check file size, codec and duration
+	log.Info().Str("filename", header.Filename).Msg("check audio duration")
+
+	duration, err := audio.GetDuration()
+	tm.Duration = duration
+	if err != nil {
+		log.Error().Str("filename", header.Filename).Msg("Cannot get audio duration.")
+
+		discard(false)
+
+		writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("Cannot get audio duration"))
+		return UploadAudioResp{}, false
+	}
+
+	if err := format.Accept(header.Size, audio.Format, audio.Streams); err != nil {
+		log.Error().Err(err).Float32("duration", duration).Int64("size", header.Size).Msg("Rejected audio file")
+
+		discard(false)
+
+		writeErrorResponse(w, http.StatusBadRequest, err)
+		return UploadAudioResp{}, false
+	}
+
+	metadata, err := audio.Metadata()
+	if err != nil {
+		// not fatal, the original was probed to be accepted
+		log.Warn().Err(err).Str("filename", header.Filename).Msg("Cannot get audio metadata.")
+	} else {
+		tm.Metadata = &metadata
+	}
+
+	if !limits.reserve(w, ua.Client, header.Size) {
+		discard(true)
+
+		return UploadAudioResp{}, false
+	}
+
+	if limits.quotas {
+		// released when the job fails
+		tm.QuotaKey = ua.Client
+		tm.Reserved = header.Size
+	}
+
+	if err := tm.Create(); err != nil {
+		// e.g. a signed URL used by two uploads at once
+		log.Error().Err(err).Str("filename", header.Filename).Str("transcode", tm.ID.Hex()).Msg("Cannot create transcode.")
+
+		limits.release(ua.Client, header.Size)
+		discard(false)
+
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot create transcode"))
+		return UploadAudioResp{}, false
+	}
+
+	// transcode audio
+	log.Info().Str("filename", header.Filename).Msg("transcode audio")
+
+	// published before enqueueing, a worker may pick the job right away
+	broker.Publish(tm.ID.Hex(), events.Event{
+		Type:   events.TypeStatus,
+		Status: models.StatusQueued,
+	})
+
+	err = q.Enqueue(queue.NewJob(tm.ID.Hex(), uploader.GetID(), header.Filename, profile))
+	if err != nil {
+		broker.Publish(tm.ID.Hex(), events.Event{
+			Type:   events.TypeStatus,
+			Status: models.StatusFailed,
+			Error:  err.Error(),
+		})
+	}
+
+	if err == queue.ErrQueueFull {
+		log.Warn().Str("filename", header.Filename).Msg("Transcode queue is full.")
+
+		_ = tm.Delete()
+		limits.release(ua.Client, header.Size)
+		discard(true)
+
+		writeRetryResponse(w, http.StatusServiceUnavailable, queueRetryAfter, err)
+		return UploadAudioResp{}, false
+	}
+
+	if err != nil {
+		log.Error().Err(err).Str("filename", header.Filename).Msg("Cannot enqueue transcode job.")
+
+		_ = tm.Delete()
+		limits.release(ua.Client, header.Size)
+		discard(true)
+
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot enqueue transcode job"))
+		return UploadAudioResp{}, false
+	}
+
+	return UploadAudioResp{
+		Id:       tm.ID.Hex(),
+		FileName: header.Filename,
+		Duration: duration,
+		Metadata: tm.Metadata,
+	}, true
+}
+
+type UploadImageResp struct {
+	Id       string                `json:"id"`
+	FileName string                `json:"file_name"`
+	Format   string                `json:"format"`
+	Variants []models.ImageVariant `json:"variants"`
+}
+
 // @Summary Upload and create image file
-// @Description Upload, create and publish to ipfs an image
+// @Description Upload an image (jpeg, png, webp or gif) and create the square cover variants, served by /image/{id}/{file}
 // @Tags upload
 // @Produce json
 // @Param file formData file true "Image file"
-// @Success 200 {object} server.UploadAudioResp
+// @Success 200 {object} server.UploadImageResp
 // @Failure 400 {object} server.ErrorResponse "Error"
+// @Failure 413 {object} server.ErrorResponse "Upload too large"
+// @Failure 429 {object} server.ErrorResponse "Upload rate exceeded"
+// @Failure 500 {object} server.ErrorResponse "Failure to save the image"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /upload/image [post]
-func uploadImageHandler() http.HandlerFunc {
+func uploadImageHandler(store storage.Storage, maxSize int64) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		file, header, ok := readUploadForm(w, r, maxSize)
+		if !ok {
+			return
+		}
+		defer file.Close()
+
+		log.Info().Str("filename", header.Filename).Msg("handling new image upload...")
+
+		uploader := services.NewUploader(file, header)
+
+		// the variants are stored, the working directory only holds the
+		// original and is removed whatever the outcome
+		defer os.RemoveAll(uploader.GetDir())
+
+		// save original file
+		original, err := uploader.SaveOriginal(maxSize)
+
+		var tooLarge *services.TooLargeError
+		if errors.As(err, &tooLarge) {
+			writeErrorResponse(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+
+		if err != nil {
+			log.Error().Str("filename", uploader.Header.Filename).Msg("Cannot save image file.")
+
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("Cannot save image file %s", uploader.Header.Filename))
+			return
+		}
+		original.Close()
+
+		im := models.NewImage()
+		img := transcoder.NewImage(uploader, im.ID, store)
+
+		log.Info().Str("filename", header.Filename).Msg("create image variants")
+
+		variants, err := img.CreateVariants()
+		if err != nil {
+			log.Error().Err(err).Str("filename", uploader.Header.Filename).Msg("Cannot create image variants.")
+
+			writeErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
+
+		im.Format = img.Format
+		im.Variants = variants
+
+		if err := im.Create(); err != nil {
+			log.Error().Err(err).Str("filename", header.Filename).Str("image", im.ID.Hex()).Msg("Cannot create image.")
+
+			if err := img.DeleteVariants(); err != nil {
+				log.Error().Err(err).Str("image", im.ID.Hex()).Msg("Cannot delete image variants.")
+			}
+
+			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot create image"))
+			return
+		}
+
+		res := UploadImageResp{
+			Id:       im.ID.Hex(),
+			FileName: uploader.Header.Filename,
+			Format:   im.Format,
+			Variants: im.Variants,
+		}
+
+		bz, err := json.Marshal(res)
+		if err != nil {
+			log.Error().Str("filename", uploader.Header.Filename).Msg("Failed to encode response")
+
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("failed to encode response: %w", err))
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
-		_, _ = w.Write([]byte("not implemented"))
+		_, _ = w.Write(bz)
 	}
 }
 
@@ -152,9 +570,12 @@ func uploadImageHandler() http.HandlerFunc {
 // @Param id path string true "ID"
 // @Success 200 {object} models.Transcoder
 // @Failure 400 {object} server.ErrorResponse "Failure to parse the id"
+// @Failure 401 {object} server.ErrorResponse "Authentication required"
 // @Failure 404 {object} server.ErrorResponse "Failure to find the id"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /transcode/{id} [get]
-func getTranscodeHandler() http.HandlerFunc {
+func getTranscodeHandler(authEnabled bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var params = mux.Vars(r)
 		id := params["id"]
@@ -166,7 +587,7 @@ func getTranscodeHandler() http.HandlerFunc {
 		}
 
 		tm := &models.Transcoder{
-			ID:         pid,
+			ID: pid,
 		}
 
 		res, err := tm.Get()
@@ -175,6 +596,12 @@ func getTranscodeHandler() http.HandlerFunc {
 			return
 		}
 
+		// the transcodes of other callers are reported as missing
+		if !ownsTranscode(r, authEnabled, res) {
+			writeErrorResponse(w, http.StatusNotFound, fmt.Errorf("id not found"))
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(res)
 	}
@@ -0,0 +1,198 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/auth"
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/queue"
+	"github.com/angelorc/go-uploader/server"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestQueue(t *testing.T, capacity int) *queue.Queue {
+	dir, err := ioutil.TempDir("", "queue")
+	require.NoError(t, err)
+
+	q, err := queue.Open(dir, capacity)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = q.Close()
+		_ = os.RemoveAll(dir)
+	})
+
+	return q
+}
+
+func TestUploadAudioQueueFull(t *testing.T) {
+	q := newTestQueue(t, 1)
+	require.NoError(t, q.Enqueue(queue.NewJob("job1", "upload1", "track.wav", "default")))
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, q, events.NewBroker(time.Minute), nil, nil, config.DefaultConfig())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/audio", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "30", rec.Header().Get("Retry-After"))
+
+	var res server.ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Equal(t, queue.ErrQueueFull.Error(), res.Error)
+}
+
+func TestUploadAudioUnknownProfile(t *testing.T) {
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, config.DefaultConfig())
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	require.NoError(t, mw.WriteField("profile", "dolby"))
+
+	fw, err := mw.CreateFormFile("file", "track.mp3")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte("ID3"))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/audio", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var res server.ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Equal(t, "unknown profile: dolby", res.Error)
+}
+
+func TestUploadAudioSpoofedContentType(t *testing.T) {
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, config.DefaultConfig())
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", `form-data; name="file"; filename="track.mp3"`)
+	h.Set("Content-Type", "audio/mp3")
+
+	fw, err := mw.CreatePart(h)
+	require.NoError(t, err)
+	_, err = fw.Write([]byte("MZ\x90\x00\x03\x00\x00\x00"))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/audio", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var res server.ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Equal(t, "unsupported file: executable files are not allowed", res.Error)
+}
+
+func TestUploadAudioInvalidDedup(t *testing.T) {
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, config.DefaultConfig())
+
+	rec := postAudio(router, "/api/v1/upload/audio?dedup=maybe", "track.mp3", []byte("ID3"))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var res server.ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Equal(t, "invalid dedup: maybe", res.Error)
+}
+
+func TestUploadAudioTooLarge(t *testing.T) {
+	chdirTemp(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Uploads.MaxSize = 16
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, cfg)
+
+	requireTooLarge := func(rec *httptest.ResponseRecorder) {
+		require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+		var res server.ErrorResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		require.Equal(t, "upload must not exceed 16 bytes", res.Error)
+	}
+
+	requireTooLarge(postAudio(router, "/api/v1/upload/audio", "track.mp3", make([]byte, 64)))
+
+	// bodies larger than the limit are cut while reading, with or without a
+	// Content-Length
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	fw, err := mw.CreateFormFile("file", "track.mp3")
+	require.NoError(t, err)
+	_, err = fw.Write(make([]byte, 2<<20))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	for _, length := range []int64{int64(body.Len()), -1} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/audio", bytes.NewReader(body.Bytes()))
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.ContentLength = length
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		requireTooLarge(rec)
+	}
+}
+
+func TestGetTranscodeRequiresIdentity(t *testing.T) {
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, auth.APIKeys{"backend": "key"}, config.DefaultConfig())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/transcode/5f5e1000a1b2c3d4e5f60718", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+
+	req.Header.Set(auth.APIKeyHeader, "wrong")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// the status stream too
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/transcode/5f5e1000a1b2c3d4e5f60718/events", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// formats are public
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/formats", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+}
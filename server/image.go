@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// isImageVariant reports whether file is the file name of an image variant.
+func isImageVariant(file string) bool {
+	for _, size := range transcoder.ImageSizes {
+		if file == transcoder.VariantFileName(size) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// @Summary Get an image variant
+// @Description Serve a square cover variant of an uploaded image from the storage, the file names are listed by the variants of the upload, e.g. cover_500.jpg.
+// @Description Range requests and conditional requests on the ETag are supported, the variants are cached as immutable.
+// @Tags upload
+// @Produce jpeg
+// @Param id path string true "Image ID"
+// @Param file path string true "Variant file name"
+// @Success 200 {string} string "Variant"
+// @Success 304 "Not modified"
+// @Failure 400 {object} server.ErrorResponse "Failure to parse the id"
+// @Failure 404 {object} server.ErrorResponse "Failure to find the variant"
+// @Router /image/{id}/{file} [get]
+func imageHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params = mux.Vars(r)
+
+		pid, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot decode id"))
+			return
+		}
+
+		file := params["file"]
+		if !isImageVariant(file) {
+			writeErrorResponse(w, http.StatusNotFound, fmt.Errorf("file not found"))
+			return
+		}
+
+		serveObject(w, r, store, pid.Hex()+"/"+file, "image/jpeg", segmentCacheControl)
+	}
+}
@@ -0,0 +1,36 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImage(t *testing.T) {
+	router := newStreamRouter(t)
+
+	rec := streamRequest(router, http.MethodGet, "/api/v1/image/"+streamID+"/cover_500.jpg", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+	require.Equal(t, "public, max-age=31536000, immutable", rec.Header().Get("Cache-Control"))
+	require.Equal(t, "\xFF\xD8\xFF", rec.Body.String())
+
+	tests := []struct {
+		name   string
+		target string
+		code   int
+	}{
+		{"invalid id", "/api/v1/image/nope/cover_500.jpg", http.StatusBadRequest},
+		{"missing variant", "/api/v1/image/" + streamID + "/cover_64.jpg", http.StatusNotFound},
+		{"unknown size", "/api/v1/image/" + streamID + "/cover_300.jpg", http.StatusNotFound},
+		{"not a variant", "/api/v1/image/" + streamID + "/master.m3u8", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := streamRequest(router, http.MethodGet, tt.target, nil)
+			require.Equal(t, tt.code, rec.Code)
+		})
+	}
+}
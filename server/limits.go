@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/ratelimit"
+	"github.com/angelorc/go-uploader/services"
+	"github.com/rs/zerolog/log"
+)
+
+// multipartOverhead is the room left to the multipart headers and the other
+// form fields in the body of an upload.
+const multipartOverhead = 1 << 20
+
+// pruneInterval is the delay between two removals of the full rate buckets.
+const pruneInterval = 10 * time.Minute
+
+// ErrRateLimited is returned when a caller starts uploads faster than allowed.
+var ErrRateLimited = fmt.Errorf("too many uploads, retry later")
+
+// uploadLimits enforces the upload rate and the storage quota of the callers.
+type uploadLimits struct {
+	// limiter is nil when the upload rate is unlimited.
+	limiter *ratelimit.Limiter
+	quotas  bool
+	// defaultQuota is the storage of the callers without a quota of their own.
+	defaultQuota int64
+}
+
+// newUploadLimits returns the limits of cfg, the rate buckets are pruned
+// until ctx is done.
+func newUploadLimits(ctx context.Context, cfg config.Limits) *uploadLimits {
+	l := &uploadLimits{
+		quotas:       cfg.Quotas,
+		defaultQuota: cfg.DefaultQuota,
+	}
+
+	if cfg.UploadsPerMinute > 0 {
+		l.limiter = ratelimit.New(cfg.UploadsPerMinute/60, cfg.UploadBurst)
+
+		// the callers seen once would otherwise keep their bucket forever
+		go func(limiter *ratelimit.Limiter) {
+			ticker := time.NewTicker(pruneInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					limiter.Prune()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(l.limiter)
+	}
+
+	return l
+}
+
+// clientKey identifies the caller of the limits, by its owner or by its
+// address when anonymous.
+func clientKey(r *http.Request, owner string) string {
+	if owner != "" {
+		return owner
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+// allow takes an upload from the rate of client, writing a 429 response with
+// the delay before the next one when the rate is exceeded.
+func (l *uploadLimits) allow(w http.ResponseWriter, client string) bool {
+	if l.limiter == nil {
+		return true
+	}
+
+	ok, wait := l.limiter.Allow(client)
+	if ok {
+		return true
+	}
+
+	log.Warn().Str("client", client).Dur("retry_after", wait).Msg("Upload rate exceeded.")
+
+	writeRetryResponse(w, http.StatusTooManyRequests, int(math.Ceil(wait.Seconds())), ErrRateLimited)
+	return false
+}
+
+// checkQuota rejects, without reserving it, an upload of size bytes which
+// does not fit the remaining quota of client.
+func (l *uploadLimits) checkQuota(w http.ResponseWriter, client string, size int64) bool {
+	if !l.quotas {
+		return true
+	}
+
+	quota, err := models.NewQuota(client, l.defaultQuota).Get()
+	if err != nil {
+		log.Error().Err(err).Str("client", client).Msg("Cannot get quota.")
+
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot get quota"))
+		return false
+	}
+
+	// not retried, the storage is only given back by the failed jobs or an admin
+	if remaining := quota.Remaining(); remaining >= 0 && size > remaining {
+		writeErrorResponse(w, http.StatusInsufficientStorage, models.ErrQuotaExceeded)
+		return false
+	}
+
+	return true
+}
+
+// reserve adds size bytes to the storage used by client, writing the error
+// response when the quota is exceeded.
+func (l *uploadLimits) reserve(w http.ResponseWriter, client string, size int64) bool {
+	if !l.quotas {
+		return true
+	}
+
+	err := models.NewQuota(client, l.defaultQuota).Reserve(size)
+	if err == models.ErrQuotaExceeded {
+		log.Warn().Str("client", client).Int64("size", size).Msg("Storage quota exceeded.")
+
+		writeErrorResponse(w, http.StatusInsufficientStorage, err)
+		return false
+	}
+	if err != nil {
+		log.Error().Err(err).Str("client", client).Msg("Cannot reserve quota.")
+
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot reserve quota"))
+		return false
+	}
+
+	return true
+}
+
+// release gives back a reservation of an upload which was not enqueued, the
+// reservation of an enqueued job is released by the worker when it fails.
+func (l *uploadLimits) release(client string, size int64) {
+	if !l.quotas {
+		return
+	}
+
+	if err := models.NewQuota(client, l.defaultQuota).Release(size); err != nil {
+		log.Error().Err(err).Str("client", client).Msg("Cannot release quota.")
+	}
+}
+
+// limitRate rejects the requests of the callers exceeding the upload rate.
+func (l *uploadLimits) limitRate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(w, clientKey(r, identityOwner(r))) {
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func writeTooLargeResponse(w http.ResponseWriter, maxSize int64) {
+	writeErrorResponse(w, http.StatusRequestEntityTooLarge, &services.TooLargeError{Limit: maxSize})
+}
+
+// limitedBody records the bytes read by http.MaxBytesReader from a body, to
+// tell its limit from the other read errors: it reads one byte past the
+// limit from the bodies which exceed it.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+
+	return n, err
+}
+
+func (b *limitedBody) exceeded() bool {
+	return b.read > b.limit
+}
+
+// limitBody limits the body of r to limit bytes, the returned body reports
+// whether it was exceeded.
+func limitBody(w http.ResponseWriter, r *http.Request, limit int64) *limitedBody {
+	body := &limitedBody{ReadCloser: r.Body, limit: limit}
+	r.Body = http.MaxBytesReader(w, body, limit)
+
+	return body
+}
+
+// readUploadForm returns the file field of a multipart upload of at most
+// maxSize bytes. Larger bodies are rejected with a 413 response before being
+// written to disk, on failure the error response is written and false
+// returned.
+func readUploadForm(w http.ResponseWriter, r *http.Request, maxSize int64) (multipart.File, *multipart.FileHeader, bool) {
+	limit := maxSize + multipartOverhead
+
+	if r.ContentLength > limit {
+		writeTooLargeResponse(w, maxSize)
+		return nil, nil, false
+	}
+
+	body := limitBody(w, r, limit)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		if body.exceeded() {
+			writeTooLargeResponse(w, maxSize)
+			return nil, nil, false
+		}
+
+		writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("file field is required"))
+		return nil, nil, false
+	}
+
+	if header.Size > maxSize {
+		file.Close()
+
+		writeTooLargeResponse(w, maxSize)
+		return nil, nil, false
+	}
+
+	return file, header, true
+}
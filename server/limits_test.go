@@ -0,0 +1,136 @@
+package server_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/auth"
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/server"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadAudioRateLimited(t *testing.T) {
+	chdirTemp(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Limits.UploadsPerMinute = 1
+	cfg.Limits.UploadBurst = 1
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, cfg)
+
+	// rejected as not audio, the upload still counts
+	rec := postAudio(router, "/api/v1/upload/audio", "song.mp3", []byte("not audio"))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = postAudio(router, "/api/v1/upload/audio", "song.mp3", []byte("not audio"))
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	// the rate is tracked by caller
+	var body bytes.Buffer
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/audio", &body)
+	req.RemoteAddr = "192.0.2.2:1234"
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAdminQuotas(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.APIKeys = map[string]string{"backend": "token", "ops": "admin-token"}
+	cfg.Auth.Admins = []string{"key:ops"}
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, auth.APIKeys(cfg.Auth.APIKeys), cfg)
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/quotas/key:backend", nil)
+		if token != "" {
+			req.Header.Set(auth.APIKeyHeader, token)
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	require.Equal(t, http.StatusUnauthorized, get("").Code)
+	require.Equal(t, http.StatusForbidden, get("token").Code)
+	require.Equal(t, http.StatusNotImplemented, get("admin-token").Code)
+}
+
+// paddedForm returns a multipart form without file of exactly size bytes.
+func paddedForm(t *testing.T, size int) ([]byte, string) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	require.NoError(t, mw.SetBoundary("boundary"))
+
+	fw, err := mw.CreateFormField("padding")
+	require.NoError(t, err)
+	_, err = fw.Write(bytes.Repeat([]byte("a"), size))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	// the headers and boundaries take body.Len() - size bytes
+	overhead := body.Len() - size
+
+	body.Reset()
+	mw = multipart.NewWriter(&body)
+	require.NoError(t, mw.SetBoundary("boundary"))
+
+	fw, err = mw.CreateFormField("padding")
+	require.NoError(t, err)
+	_, err = fw.Write(bytes.Repeat([]byte("a"), size-overhead))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+	require.Equal(t, size, body.Len())
+
+	return body.Bytes(), mw.FormDataContentType()
+}
+
+func TestUploadBodyLimit(t *testing.T) {
+	chdirTemp(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Uploads.MaxSize = 16
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, cfg)
+
+	// the body limit is the max size plus the room of the multipart headers
+	limit := 16 + 1<<20
+
+	tests := []struct {
+		name string
+		size int
+		code int
+	}{
+		{"at the limit", limit, http.StatusBadRequest},
+		{"past the limit", limit + 1, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, contentType := paddedForm(t, tt.size)
+
+			// without Content-Length, the body is cut while reading
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/audio", bytes.NewReader(body))
+			req.Header.Set("Content-Type", contentType)
+			req.ContentLength = -1
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			require.Equal(t, tt.code, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/angelorc/go-uploader/auth"
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/services"
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SignUploadReq struct {
+	// Owner is the identity stored on the transcode, the caller when empty,
+	// e.g. jwt:<subject> for a JWT user. It is set by the admins and the API
+	// key callers only, it is the caller for the others.
+	Owner string `json:"owner"`
+	// MaxSize is the largest accepted file in bytes, the format limits when 0.
+	MaxSize int64 `json:"max_size"`
+	// Types are the accepted audio formats, all of them when empty.
+	Types []string `json:"types"`
+	// TTL is the validity of the URL in seconds, the configured maximum when 0.
+	TTL int `json:"ttl"`
+}
+
+type SignUploadResp struct {
+	JobID     string    `json:"job_id"`
+	URL       string    `json:"url"`
+	TusURL    string    `json:"tus_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// newSigner returns the signer of the upload URLs, nil when uploads are not signed.
+func newSigner(cfg config.Config) *services.Signer {
+	if cfg.Uploads.Secret == "" {
+		return nil
+	}
+
+	return services.NewSigner(cfg.Uploads.Secret)
+}
+
+// transcodeExists reports whether the transcode has been created, it is
+// replaced by the tests.
+var transcodeExists = func(id primitive.ObjectID) (bool, error) {
+	return (&models.Transcoder{ID: id}).Exists()
+}
+
+// claimGrant records the use of the grant by an upload, it is replaced by the
+// tests.
+var claimGrant = func(id primitive.ObjectID) (bool, error) {
+	return (&models.Grant{JobID: id}).Claim()
+}
+
+// checkGrantUnused rejects the uploads signed with the id of an existing
+// transcode, a signed URL authorizes a single upload until it expires. On
+// failure the error response is written and false returned.
+func checkGrantUnused(w http.ResponseWriter, ua *uploadAuth) bool {
+	if ua.Grant == nil {
+		return true
+	}
+
+	exists, err := transcodeExists(ua.JobID)
+	if err != nil {
+		log.Error().Err(err).Str("transcode", ua.Grant.JobID).Msg("Cannot look up signed transcode.")
+
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot check upload signature"))
+		return false
+	}
+
+	if exists {
+		writeErrorResponse(w, http.StatusConflict, fmt.Errorf("upload signature already used"))
+		return false
+	}
+
+	return true
+}
+
+// useGrant records the grant of a signed upload as used, before the upload is
+// saved: the uploads of a signed URL used by another one, even one which is
+// not completed yet, are rejected. On failure the error response is written
+// and false returned.
+func useGrant(w http.ResponseWriter, ua *uploadAuth) bool {
+	if ua.Grant == nil {
+		return true
+	}
+
+	claimed, err := claimGrant(ua.JobID)
+	if err != nil {
+		log.Error().Err(err).Str("transcode", ua.Grant.JobID).Msg("Cannot claim upload signature.")
+
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot check upload signature"))
+		return false
+	}
+
+	if !claimed {
+		writeErrorResponse(w, http.StatusConflict, fmt.Errorf("upload signature already used"))
+		return false
+	}
+
+	return true
+}
+
+// checkGrant checks the size and format of the upload against its grant.
+func checkGrant(grant *services.UploadGrant, format transcoder.AudioFormat, size int64) (int, error) {
+	if grant == nil {
+		return http.StatusOK, nil
+	}
+
+	if size > grant.MaxSize {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("upload must not exceed %d bytes", grant.MaxSize)
+	}
+
+	if !grant.AllowsType(format.Name) {
+		return http.StatusUnsupportedMediaType, fmt.Errorf("%s files are not allowed by the upload signature", format.Name)
+	}
+
+	return http.StatusOK, nil
+}
+
+// @Summary Sign an upload URL
+// @Description Issue a short-lived signed URL authorizing a single audio upload, for the multipart and the tus endpoints.
+// @Tags upload
+// @Accept json
+// @Produce json
+// @Param body body server.SignUploadReq true "Upload constraints"
+// @Success 200 {object} server.SignUploadResp
+// @Failure 400 {object} server.ErrorResponse "Error"
+// @Failure 401 {object} server.ErrorResponse "Authentication required"
+// @Failure 501 {object} server.ErrorResponse "Upload signing is not configured"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /upload/sign [post]
+func signUploadHandler(signer *services.Signer, cfg config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if signer == nil {
+			writeErrorResponse(w, http.StatusNotImplemented, fmt.Errorf("upload signing is not configured"))
+			return
+		}
+
+		id := auth.FromContext(r.Context())
+		if id == nil {
+			writeAuthError(w, auth.ErrNoCredentials)
+			return
+		}
+
+		var req SignUploadReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot decode request"))
+			return
+		}
+
+		if req.MaxSize < 0 || req.MaxSize > cfg.Uploads.MaxSize {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("max size must be between 0 and %d bytes", cfg.Uploads.MaxSize))
+			return
+		}
+		if req.MaxSize == 0 {
+			req.MaxSize = cfg.Uploads.MaxSize
+		}
+
+		for _, t := range req.Types {
+			if _, ok := transcoder.LookupAudioFormat(t); !ok {
+				writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("unknown audio format: %s", t))
+				return
+			}
+		}
+
+		ttl := time.Duration(req.TTL) * time.Second
+		if ttl < 0 || ttl > cfg.Uploads.MaxTTL {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("ttl must be between 0 and %d seconds", int(cfg.Uploads.MaxTTL.Seconds())))
+			return
+		}
+		if ttl == 0 {
+			ttl = cfg.Uploads.MaxTTL
+		}
+
+		// the admins and the backends holding an API key sign uploads for
+		// their users, the other callers for themselves
+		if req.Owner == "" || (id.Method != auth.MethodAPIKey && !isAdmin(cfg.Auth.Admins, id)) {
+			req.Owner = id.Owner()
+		}
+
+		grant := services.UploadGrant{
+			JobID:   primitive.NewObjectID().Hex(),
+			Owner:   req.Owner,
+			MaxSize: req.MaxSize,
+			Types:   req.Types,
+			Expires: time.Now().Add(ttl).Truncate(time.Second).UTC(),
+		}
+
+		query := signer.Query(grant).Encode()
+
+		res := SignUploadResp{
+			JobID:     grant.JobID,
+			URL:       "/api/v1/upload/audio?" + query,
+			TusURL:    "/api/v1/tus?" + query,
+			ExpiresAt: grant.Expires,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
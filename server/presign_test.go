@@ -0,0 +1,323 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/auth"
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/server"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newSignedRouter(t *testing.T) *mux.Router {
+	chdirTemp(t)
+
+	server.StubTranscodeExists(t, func(primitive.ObjectID) (bool, error) {
+		return false, nil
+	})
+
+	var (
+		mu      sync.Mutex
+		claimed = make(map[primitive.ObjectID]bool)
+	)
+	server.StubClaimGrant(t, func(id primitive.ObjectID) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if claimed[id] {
+			return false, nil
+		}
+		claimed[id] = true
+
+		return true, nil
+	})
+
+	cfg := config.DefaultConfig()
+	cfg.Uploads.Secret = "secret"
+	cfg.Auth.APIKeys = map[string]string{"backend": "token"}
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, auth.APIKeys(cfg.Auth.APIKeys), cfg)
+
+	return router
+}
+
+func signUpload(t *testing.T, router *mux.Router, token string, req server.SignUploadReq) *httptest.ResponseRecorder {
+	bz, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/upload/sign", bytes.NewReader(bz))
+	if token != "" {
+		r.Header.Set(auth.APIKeyHeader, token)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, r)
+
+	return rec
+}
+
+func postAudio(router *mux.Router, target string, filename string, content []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	fw, _ := mw.CreateFormFile("file", filename)
+	_, _ = fw.Write(content)
+	_ = mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestSignUpload(t *testing.T) {
+	router := newSignedRouter(t)
+
+	rec := signUpload(t, router, "wrong", server.SignUploadReq{})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = signUpload(t, router, "", server.SignUploadReq{})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = signUpload(t, router, "token", server.SignUploadReq{Types: []string{"wma"}})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = signUpload(t, router, "token", server.SignUploadReq{TTL: 3600})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = signUpload(t, router, "token", server.SignUploadReq{MaxSize: 1024, Types: []string{"flac"}, TTL: 60})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var res server.SignUploadResp
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Len(t, res.JobID, 24)
+	require.True(t, strings.HasPrefix(res.URL, "/api/v1/upload/audio?"))
+	require.True(t, strings.HasPrefix(res.TusURL, "/api/v1/tus?"))
+	require.WithinDuration(t, time.Now().Add(time.Minute), res.ExpiresAt, 2*time.Second)
+
+	// the format is checked against the signature before saving the upload
+	rec = postAudio(router, res.URL, "track.mp3", []byte("ID3\x04\x00\x00\x00\x00\x00\x00"))
+	require.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+	rec = postAudio(router, res.URL, "track.flac", append([]byte("fLaC"), make([]byte, 2048)...))
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+// bearerSubject authenticates the bearer token as a JWT of that subject.
+type bearerSubject struct{}
+
+func (bearerSubject) Authenticate(r *http.Request) (*auth.Identity, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return nil, auth.ErrNoCredentials
+	}
+
+	return &auth.Identity{Subject: token, Method: auth.MethodJWT}, nil
+}
+
+func TestSignUploadOwner(t *testing.T) {
+	chdirTemp(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Uploads.Secret = "secret"
+	cfg.Auth.Admins = []string{"jwt:ops"}
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, auth.Chain{auth.APIKeys{"backend": "token"}, bearerSubject{}}, cfg)
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		owner  string
+	}{
+		{"api key", auth.APIKeyHeader, "token", "jwt:artist"},
+		{"admin", "Authorization", "Bearer ops", "jwt:artist"},
+		{"user", "Authorization", "Bearer mallory", "jwt:mallory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bz, err := json.Marshal(server.SignUploadReq{Owner: "jwt:artist"})
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/upload/sign", bytes.NewReader(bz))
+			r.Header.Set(tt.header, tt.value)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, r)
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			var res server.SignUploadResp
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+
+			signed, err := url.Parse(res.URL)
+			require.NoError(t, err)
+			require.Equal(t, tt.owner, signed.Query().Get("owner"))
+		})
+	}
+}
+
+func TestSignedUploadIsUsedOnce(t *testing.T) {
+	router := newSignedRouter(t)
+
+	rec := signUpload(t, router, "token", server.SignUploadReq{})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var signed server.SignUploadResp
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&signed))
+
+	// the transcode of the first upload exists
+	server.StubTranscodeExists(t, func(id primitive.ObjectID) (bool, error) {
+		return id.Hex() == signed.JobID, nil
+	})
+
+	rec = postAudio(router, signed.URL, "track.mp3", []byte("ID3\x04\x00\x00\x00\x00\x00\x00"))
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	var res server.ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Equal(t, "upload signature already used", res.Error)
+
+	req := httptest.NewRequest(http.MethodPost, signed.TusURL, nil)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", "10")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestSignedTusUploadIsCreatedOnce(t *testing.T) {
+	router := newSignedRouter(t)
+
+	rec := signUpload(t, router, "token", server.SignUploadReq{})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var signed server.SignUploadResp
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&signed))
+
+	create := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, signed.TusURL, nil)
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Upload-Length", "10")
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	require.Equal(t, http.StatusCreated, create().Code)
+
+	// the first upload is not completed, its transcode does not exist yet
+	rec = create()
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	var res server.ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Equal(t, "upload signature already used", res.Error)
+
+	rec = postAudio(router, signed.URL, "track.mp3", []byte("ID3\x04\x00\x00\x00\x00\x00\x00"))
+	require.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestUploadAudioRequiresSignature(t *testing.T) {
+	router := newSignedRouter(t)
+
+	rec := postAudio(router, "/api/v1/upload/audio", "track.mp3", []byte("ID3"))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = postAudio(router, "/api/v1/upload/audio?job=5f5e1000a1b2c3d4e5f60718&max_size=10&expires=4102444800&signature=00", "track.mp3", []byte("ID3"))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	var res server.ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Equal(t, "invalid upload signature", res.Error)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tus", nil)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", "10")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	// authenticated callers upload without a signature, tus creates the upload
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/tus", nil)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", "10")
+	req.Header.Set(auth.APIKeyHeader, "token")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+}
+
+func TestSignUploadNotConfigured(t *testing.T) {
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, config.DefaultConfig())
+
+	rec := signUpload(t, router, "", server.SignUploadReq{})
+	require.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestSignedTusUploadRequiresSignature(t *testing.T) {
+	router := newSignedRouter(t)
+
+	createSigned := func() string {
+		rec := signUpload(t, router, "token", server.SignUploadReq{})
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var signed server.SignUploadResp
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&signed))
+
+		req := httptest.NewRequest(http.MethodPost, signed.TusURL, nil)
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Upload-Length", "10")
+
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		return rec.Header().Get("Location")
+	}
+
+	head := func(target string) int {
+		req := httptest.NewRequest(http.MethodHead, target, nil)
+		req.Header.Set("Tus-Resumable", "1.0.0")
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	location := createSigned()
+	require.Equal(t, http.StatusOK, head(location))
+
+	upload, err := url.Parse(location)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, head(upload.Path))
+
+	// the signature of another upload does not authorize it
+	other, err := url.Parse(createSigned())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, head(upload.Path+"?"+other.RawQuery))
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// segmentCacheControl caches the segments for a year, they are stored
+	// once under the job and rendition and never change.
+	segmentCacheControl = "public, max-age=31536000, immutable"
+	// playlistCacheControl revalidates the playlists, manifests and the
+	// download on their ETag, the download is tagged again by the tags
+	// update.
+	playlistCacheControl = "public, no-cache"
+)
+
+// StreamHeaders are the response headers of the stream route, which browsers
+// can read only when exposed by CORS.
+var StreamHeaders = []string{"Accept-Ranges", "Content-Range", "ETag"}
+
+// streamTypes are the content types of the outputs served by the stream
+// route, by extension.
+var streamTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mpd":  "application/dash+xml",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+	".mp3":  "audio/mpeg",
+}
+
+// isMutable reports whether the output can change once stored: the
+// playlists listing the other outputs and the tagged download.
+func isMutable(ext string) bool {
+	return ext == ".m3u8" || ext == ".mpd" || ext == ".mp3"
+}
+
+// streamETag returns the entity tag of a stored object, which changes along
+// with its size or modification time.
+func streamETag(info storage.ObjectInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size)
+}
+
+// @Summary Stream a transcode output
+// @Description Serve a playlist, manifest, segment or the MP3 download of a transcode from the storage. The master playlist is master.m3u8, the download download.mp3, the media playlists and segments are below the rendition directories, e.g. 128k/list.m3u8 and 128k/segment000.ts or opus128k/list.m3u8.
+// @Description Range requests and conditional requests on the ETag are supported, segments are cached as immutable, the playlists, manifests and download are revalidated on their ETag.
+// @Tags transcode
+// @Produce application/vnd.apple.mpegurl
+// @Produce video/mp2t
+// @Produce audio/mpeg
+// @Param id path string true "ID"
+// @Param file path string true "Output file"
+// @Param Range header string false "Byte range"
+// @Success 200 {string} string "Output file"
+// @Success 206 {string} string "Partial content"
+// @Success 304 "Not modified"
+// @Failure 400 {object} server.ErrorResponse "Failure to parse the id"
+// @Failure 404 {object} server.ErrorResponse "Failure to find the file"
+// @Failure 416 "Invalid range"
+// @Router /stream/{id}/{file} [get]
+func streamHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params = mux.Vars(r)
+
+		pid, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot decode id"))
+			return
+		}
+
+		file := params["file"]
+		ext := path.Ext(file)
+
+		contentType, ok := streamTypes[ext]
+		if !ok {
+			writeErrorResponse(w, http.StatusNotFound, fmt.Errorf("file not found"))
+			return
+		}
+
+		cacheControl := segmentCacheControl
+		if isMutable(ext) {
+			cacheControl = playlistCacheControl
+		}
+
+		serveObject(w, r, store, pid.Hex()+"/"+file, contentType, cacheControl)
+	}
+}
+
+// serveObject serves the object of the storage at key, with range and
+// conditional requests support.
+func serveObject(w http.ResponseWriter, r *http.Request, store storage.Storage, key string, contentType string, cacheControl string) {
+	info, err := store.Stat(key)
+	if err == storage.ErrNotFound || err == storage.ErrInvalidKey {
+		writeErrorResponse(w, http.StatusNotFound, fmt.Errorf("file not found"))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("Cannot stat stored file.")
+
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot read file"))
+		return
+	}
+
+	obj, err := store.Get(key)
+	if err == storage.ErrNotFound {
+		writeErrorResponse(w, http.StatusNotFound, fmt.Errorf("file not found"))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("Cannot get stored file.")
+
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot read file"))
+		return
+	}
+	defer obj.Close()
+
+	// ranges are served by seeking, the objects of the remote backends
+	// are small enough to be buffered
+	content, ok := obj.(io.ReadSeeker)
+	if !ok {
+		bz, err := ioutil.ReadAll(obj)
+		if err != nil {
+			log.Error().Err(err).Str("key", key).Msg("Cannot read stored file.")
+
+			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot read file"))
+			return
+		}
+
+		content = bytes.NewReader(bz)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", streamETag(info))
+
+	http.ServeContent(w, r, path.Base(key), info.ModTime, content)
+}
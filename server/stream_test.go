@@ -0,0 +1,149 @@
+package server_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/server"
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+const streamID = "5f5e1000a1b2c3d4e5f60718"
+
+func newStreamRouter(t *testing.T) *mux.Router {
+	root, err := ioutil.TempDir("", "stream")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(root) })
+
+	store := storage.NewLocal(root)
+	require.NoError(t, store.Put(streamID+"/master.m3u8", bytes.NewReader([]byte("#EXTM3U\n"))))
+	require.NoError(t, store.Put(streamID+"/128k/segment000.ts", bytes.NewReader([]byte("0123456789"))))
+	require.NoError(t, store.Put(streamID+"/download.mp3", bytes.NewReader([]byte("ID3"))))
+	require.NoError(t, store.Put(streamID+"/cover_500.jpg", bytes.NewReader([]byte("\xFF\xD8\xFF"))))
+	require.NoError(t, store.Put(streamID+"/waveform/512.json", bytes.NewReader([]byte(`{"version":2}`))))
+	require.NoError(t, store.Put(streamID+"/waveform/2048.dat", bytes.NewReader([]byte("\x01\x00\x00\x00"))))
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), store, nil, config.DefaultConfig())
+
+	return router
+}
+
+func streamRequest(router *mux.Router, method string, target string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestStream(t *testing.T) {
+	router := newStreamRouter(t)
+
+	rec := streamRequest(router, http.MethodGet, "/api/v1/stream/"+streamID+"/master.m3u8", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/vnd.apple.mpegurl", rec.Header().Get("Content-Type"))
+	require.Equal(t, "public, no-cache", rec.Header().Get("Cache-Control"))
+	require.Equal(t, "#EXTM3U\n", rec.Body.String())
+
+	segment := "/api/v1/stream/" + streamID + "/128k/segment000.ts"
+
+	rec = streamRequest(router, http.MethodGet, segment, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "video/mp2t", rec.Header().Get("Content-Type"))
+	require.Equal(t, "public, max-age=31536000, immutable", rec.Header().Get("Cache-Control"))
+	require.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+	require.Equal(t, "0123456789", rec.Body.String())
+
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	rec = streamRequest(router, http.MethodGet, segment, map[string]string{"If-None-Match": etag})
+	require.Equal(t, http.StatusNotModified, rec.Code)
+
+	rec = streamRequest(router, http.MethodGet, segment, map[string]string{"Range": "bytes=2-5"})
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	require.Equal(t, "bytes 2-5/10", rec.Header().Get("Content-Range"))
+	require.Equal(t, "2345", rec.Body.String())
+
+	rec = streamRequest(router, http.MethodGet, segment, map[string]string{"Range": "bytes=20-"})
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+
+	rec = streamRequest(router, http.MethodGet, "/api/v1/stream/"+streamID+"/download.mp3", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "audio/mpeg", rec.Header().Get("Content-Type"))
+	require.Equal(t, "public, no-cache", rec.Header().Get("Cache-Control"))
+	require.Equal(t, "ID3", rec.Body.String())
+
+	rec = streamRequest(router, http.MethodHead, segment, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "10", rec.Header().Get("Content-Length"))
+}
+
+func TestStreamErrors(t *testing.T) {
+	router := newStreamRouter(t)
+
+	tests := []struct {
+		name   string
+		target string
+		code   int
+	}{
+		{"invalid id", "/api/v1/stream/nope/master.m3u8", http.StatusBadRequest},
+		{"missing file", "/api/v1/stream/" + streamID + "/128k/segment001.ts", http.StatusNotFound},
+		{"unknown job", "/api/v1/stream/5f5e1000a1b2c3d4e5f60719/master.m3u8", http.StatusNotFound},
+		{"not an output", "/api/v1/stream/" + streamID + "/original.wav", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := streamRequest(router, http.MethodGet, tt.target, nil)
+			require.Equal(t, tt.code, rec.Code)
+		})
+	}
+}
+
+func TestWaveform(t *testing.T) {
+	router := newStreamRouter(t)
+
+	rec := streamRequest(router, http.MethodGet, "/api/v1/transcode/"+streamID+"/waveform", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	require.NotEmpty(t, rec.Header().Get("ETag"))
+	require.Equal(t, `{"version":2}`, rec.Body.String())
+
+	rec = streamRequest(router, http.MethodGet, "/api/v1/transcode/"+streamID+"/waveform?samples_per_pixel=2048&format=dat", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+	require.Equal(t, "\x01\x00\x00\x00", rec.Body.String())
+
+	tests := []struct {
+		name  string
+		query string
+		code  int
+	}{
+		{"not generated", "?samples_per_pixel=1024", http.StatusNotFound},
+		{"unknown resolution", "?samples_per_pixel=100", http.StatusBadRequest},
+		{"invalid resolution", "?samples_per_pixel=big", http.StatusBadRequest},
+		{"unknown format", "?format=png", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := streamRequest(router, http.MethodGet, "/api/v1/transcode/"+streamID+"/waveform"+tt.query, nil)
+			require.Equal(t, tt.code, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/services"
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// maxCoverSize is the maximum size of a cover embedded in the download.
+	maxCoverSize = 10 << 20
+	// maxTagLength is the maximum length, in characters, of a tag.
+	maxTagLength = 256
+	// tagsRetryAfter is the delay, in seconds, suggested to the callers
+	// updating the tags of a running transcode.
+	tagsRetryAfter = 30
+)
+
+var (
+	// isrcPattern matches an ISRC without hyphens, e.g. USRC17607839.
+	isrcPattern = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$`)
+	// yearPattern matches the year, or the date, of the release.
+	yearPattern = regexp.MustCompile(`^[0-9]{4}(-[0-9]{2}(-[0-9]{2})?)?$`)
+
+	// coverTypes are the MIME types of the cover formats, by image format.
+	coverTypes = map[string]string{
+		transcoder.ImageFormatJPEG: "image/jpeg",
+		transcoder.ImageFormatPNG:  "image/png",
+	}
+)
+
+// tagLookup returns a field of the tags sent by the caller and whether it
+// was sent.
+type tagLookup func(key string) (string, bool)
+
+func formTags(form url.Values) tagLookup {
+	return func(key string) (string, bool) {
+		values, ok := form[key]
+		if !ok || len(values) == 0 {
+			return "", false
+		}
+
+		return values[0], true
+	}
+}
+
+func metadataTags(metadata map[string]string) tagLookup {
+	return func(key string) (string, bool) {
+		v, ok := metadata[key]
+		return v, ok
+	}
+}
+
+// applyTrackTags sets the tags sent by the caller, an empty value clearing
+// the tag. It returns whether any tag was sent.
+func applyTrackTags(tags *models.TrackTags, lookup tagLookup) (bool, error) {
+	sent := false
+
+	for _, field := range []struct {
+		key string
+		dst *string
+	}{
+		{"title", &tags.Title},
+		{"artist", &tags.Artist},
+		{"album", &tags.Album},
+		{"isrc", &tags.ISRC},
+		{"year", &tags.Year},
+	} {
+		v, ok := lookup(field.key)
+		if !ok {
+			continue
+		}
+
+		v = strings.TrimSpace(v)
+		if utf8.RuneCountInString(v) > maxTagLength {
+			return false, fmt.Errorf("%s must not exceed %d characters", field.key, maxTagLength)
+		}
+
+		*field.dst = v
+		sent = true
+	}
+
+	tags.ISRC = strings.ToUpper(strings.Replace(tags.ISRC, "-", "", -1))
+	if tags.ISRC != "" && !isrcPattern.MatchString(tags.ISRC) {
+		return false, fmt.Errorf("invalid isrc %q", tags.ISRC)
+	}
+
+	if tags.Year != "" && !yearPattern.MatchString(tags.Year) {
+		return false, fmt.Errorf("year must be formatted as YYYY or YYYY-MM-DD")
+	}
+
+	if v, ok := lookup("keep_source_tags"); ok {
+		keep, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("keep_source_tags must be a boolean")
+		}
+
+		tags.DropSourceTags = !keep
+		sent = true
+	}
+
+	return sent, nil
+}
+
+// readCover returns the cover field of a multipart form and its MIME type,
+// nil when not sent. The status of the error response is returned on
+// failure.
+func readCover(r *http.Request) ([]byte, string, int, error) {
+	if r.MultipartForm == nil || len(r.MultipartForm.File["cover"]) == 0 {
+		return nil, "", 0, nil
+	}
+
+	header := r.MultipartForm.File["cover"][0]
+	if header.Size > maxCoverSize {
+		return nil, "", http.StatusRequestEntityTooLarge, &services.TooLargeError{Limit: maxCoverSize}
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		return nil, "", http.StatusBadRequest, fmt.Errorf("cannot read cover")
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, "", http.StatusBadRequest, fmt.Errorf("cannot read cover")
+	}
+
+	mime, ok := coverTypes[transcoder.DetectImageFormat(data)]
+	if !ok {
+		return nil, "", http.StatusBadRequest, fmt.Errorf("cover must be a jpeg or png image")
+	}
+
+	return data, mime, 0, nil
+}
+
+// readUploadTags returns the tags and the cover sent along with an audio
+// upload, nil when none was sent. On failure the error response is written
+// and false returned.
+func readUploadTags(w http.ResponseWriter, r *http.Request) (*models.TrackTags, []byte, bool) {
+	tags := &models.TrackTags{}
+
+	var form url.Values
+	if r.MultipartForm != nil {
+		form = r.MultipartForm.Value
+	}
+
+	sent, err := applyTrackTags(tags, formTags(form))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+
+	cover, mime, status, err := readCover(r)
+	if err != nil {
+		writeErrorResponse(w, status, err)
+		return nil, nil, false
+	}
+
+	if cover != nil {
+		tags.CoverMIME = mime
+	} else if !sent {
+		return nil, nil, true
+	}
+
+	return tags, cover, true
+}
+
+// storeCover stores the cover of a transcode, embedded in its download.
+func storeCover(w http.ResponseWriter, store storage.Storage, id string, cover []byte) bool {
+	if err := store.Put(id+"/"+transcoder.CoverFileName, bytes.NewReader(cover)); err != nil {
+		log.Error().Err(err).Str("transcode", id).Msg("Cannot store cover.")
+
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot store cover"))
+		return false
+	}
+
+	return true
+}
+
+// tagLocks serializes the tags updates of each transcode, so that the
+// download is tagged with the tags stored last.
+type tagLocks struct {
+	mu    sync.Mutex
+	locks map[string]*tagLock
+}
+
+func newTagLocks() *tagLocks {
+	return &tagLocks{
+		locks: make(map[string]*tagLock),
+	}
+}
+
+type tagLock struct {
+	sync.Mutex
+	// refs counts the requests holding or waiting for the lock.
+	refs int
+}
+
+// lock blocks until the tags of the transcode id can be updated, it returns
+// the function releasing the lock.
+func (l *tagLocks) lock(id string) func() {
+	l.mu.Lock()
+	tl, ok := l.locks[id]
+	if !ok {
+		tl = &tagLock{}
+		l.locks[id] = tl
+	}
+	tl.refs++
+	l.mu.Unlock()
+
+	tl.Lock()
+
+	return func() {
+		tl.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		tl.refs--
+		if tl.refs == 0 {
+			delete(l.locks, id)
+		}
+	}
+}
+
+// @Summary Update transcode tags
+// @Description Update the ID3 tags and the cover written into the MP3 download of a transcode, only the fields sent are changed and an empty value clears a tag.
+// @Description The download of a done transcode is tagged again, the tags of a queued or failed one are written once transcoded.
+// @Tags transcode
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "ID"
+// @Param title formData string false "Title"
+// @Param artist formData string false "Artist"
+// @Param album formData string false "Album"
+// @Param isrc formData string false "ISRC"
+// @Param year formData string false "Year, YYYY or YYYY-MM-DD"
+// @Param keep_source_tags formData boolean false "Keep the tags of the original, true by default"
+// @Param cover formData file false "Cover, jpeg or png"
+// @Success 200 {object} models.Transcoder
+// @Failure 400 {object} server.ErrorResponse "Failure to parse the id or the tags"
+// @Failure 401 {object} server.ErrorResponse "Authentication required"
+// @Failure 404 {object} server.ErrorResponse "Failure to find the id"
+// @Failure 409 {object} server.ErrorResponse "Transcode is running"
+// @Failure 413 {object} server.ErrorResponse "Cover too large"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /transcode/{id}/tags [patch]
+func updateTagsHandler(store storage.Storage, authEnabled bool) http.HandlerFunc {
+	locks := newTagLocks()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params = mux.Vars(r)
+
+		pid, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot decode id"))
+			return
+		}
+
+		limit := int64(maxCoverSize + multipartOverhead)
+		if r.ContentLength > limit {
+			writeTooLargeResponse(w, maxCoverSize)
+			return
+		}
+
+		body := limitBody(w, r, limit)
+
+		err = r.ParseMultipartForm(multipartOverhead)
+		if err == http.ErrNotMultipart {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			if body.exceeded() {
+				writeTooLargeResponse(w, maxCoverSize)
+				return
+			}
+
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot parse form"))
+			return
+		}
+
+		// the tags are read, stored and written into the download by one
+		// update at a time
+		unlock := locks.lock(pid.Hex())
+		defer unlock()
+
+		tm := &models.Transcoder{
+			ID: pid,
+		}
+
+		res, err := tm.Get()
+		if err != nil {
+			writeErrorResponse(w, http.StatusNotFound, fmt.Errorf("id not found"))
+			return
+		}
+
+		// the transcodes of other callers are reported as missing
+		if !ownsTranscode(r, authEnabled, res) {
+			writeErrorResponse(w, http.StatusNotFound, fmt.Errorf("id not found"))
+			return
+		}
+
+		// checked again by the update, before storing the cover
+		if res.Status == models.StatusRunning {
+			writeRetryResponse(w, http.StatusConflict, tagsRetryAfter, models.ErrTranscodeRunning)
+			return
+		}
+
+		var tags models.TrackTags
+		if res.Tags != nil {
+			tags = *res.Tags
+		}
+
+		form := r.PostForm
+		if r.MultipartForm != nil {
+			form = r.MultipartForm.Value
+		}
+
+		sent, err := applyTrackTags(&tags, formTags(form))
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
+
+		cover, mime, status, err := readCover(r)
+		if err != nil {
+			writeErrorResponse(w, status, err)
+			return
+		}
+
+		if !sent && cover == nil {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("no tags to update"))
+			return
+		}
+
+		if cover != nil {
+			if !storeCover(w, store, pid.Hex(), cover) {
+				return
+			}
+
+			tags.CoverMIME = mime
+		}
+
+		// the job may have started since it was read
+		res, err = tm.UpdateTags(tags)
+		if err == models.ErrTranscodeRunning {
+			writeRetryResponse(w, http.StatusConflict, tagsRetryAfter, err)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Str("transcode", pid.Hex()).Msg("Cannot update tags.")
+
+			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot update tags"))
+			return
+		}
+
+		if res.Status == models.StatusDone {
+			err := transcoder.RetagDownload(store, pid.Hex(), res.Metadata, res.Tags)
+			if err == storage.ErrNotFound {
+				// transcoded before the downloads were written
+				log.Debug().Str("transcode", pid.Hex()).Msg("No download to tag.")
+			} else if err != nil {
+				log.Error().Err(err).Str("transcode", pid.Hex()).Msg("Cannot tag download.")
+
+				writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot tag download"))
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
@@ -0,0 +1,128 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/server"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func postTaggedAudio(t *testing.T, router *mux.Router, fields map[string]string, cover []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	for k, v := range fields {
+		require.NoError(t, mw.WriteField(k, v))
+	}
+
+	if cover != nil {
+		fw, err := mw.CreateFormFile("cover", "cover.jpg")
+		require.NoError(t, err)
+		_, err = fw.Write(cover)
+		require.NoError(t, err)
+	}
+
+	fw, err := mw.CreateFormFile("file", "track.mp3")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte("ID3"))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/audio", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestUploadAudioInvalidTags(t *testing.T) {
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, config.DefaultConfig())
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		cover  []byte
+		err    string
+	}{
+		{"isrc", map[string]string{"isrc": "US-RC1-76"}, nil, `invalid isrc "USRC176"`},
+		{"year", map[string]string{"year": "last year"}, nil, "year must be formatted as YYYY or YYYY-MM-DD"},
+		{"title", map[string]string{"title": strings.Repeat("a", 257)}, nil, "title must not exceed 256 characters"},
+		{"keep source tags", map[string]string{"keep_source_tags": "maybe"}, nil, "keep_source_tags must be a boolean"},
+		{"cover", nil, []byte("GIF89a"), "cover must be a jpeg or png image"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postTaggedAudio(t, router, tt.fields, tt.cover)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+
+			var res server.ErrorResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+			require.Equal(t, tt.err, res.Error)
+		})
+	}
+}
+
+func TestUpdateTagsErrors(t *testing.T) {
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, config.DefaultConfig())
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/transcode/nope/tags", strings.NewReader("title=Dawn"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPatch, "/api/v1/transcode/5f5e1000a1b2c3d4e5f60718/tags", strings.NewReader("title=Dawn"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ContentLength = 64 << 20
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func TestTagLocks(t *testing.T) {
+	locks := server.NewTagLocks()
+
+	unlock := locks.Lock("a")
+
+	// the other transcodes are not blocked
+	locks.Lock("b")()
+
+	acquired := make(chan struct{})
+	go func() {
+		locks.Lock("a")()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("the tags of a transcode were updated twice at once")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the lock")
+	}
+
+	require.Equal(t, 0, locks.Len())
+}
@@ -0,0 +1,583 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/queue"
+	"github.com/angelorc/go-uploader/services"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	methodHEAD    = "HEAD"
+	methodPATCH   = "PATCH"
+	methodDELETE  = "DELETE"
+	methodOPTIONS = "OPTIONS"
+
+	tusVersion            = "1.0.0"
+	tusExtensions         = "creation,termination,checksum"
+	tusChecksumAlgorithms = "md5,sha1,sha256"
+	tusContentType        = "application/offset+octet-stream"
+
+	// tusDefaultFileName names uploads created without a filename metadata.
+	tusDefaultFileName = "audio"
+
+	// statusChecksumMismatch is defined by the tus checksum extension.
+	statusChecksumMismatch = 460
+)
+
+// TusHeaders are the response headers of the tus protocol, which browsers can
+// read only when exposed by CORS.
+var TusHeaders = []string{
+	"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Tus-Checksum-Algorithm",
+	"Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Transcode-Id",
+}
+
+// registerTusRoutes registers the tus routes on the api router. The following
+// requests on an upload are authorized like its creation: with the signed
+// URL of the upload, or else by its owner.
+func registerTusRoutes(api *mux.Router, q *queue.Queue, broker *events.Broker, signer *services.Signer, limits *uploadLimits, authEnabled bool, cfg config.Config) {
+	locks := &uploadLocks{busy: make(map[string]bool)}
+
+	api.HandleFunc("/tus", tusOptionsHandler(cfg.Uploads.MaxSize)).Methods(methodOPTIONS)
+	api.HandleFunc("/tus", tusCreateHandler(q, signer, limits, authEnabled, cfg)).Methods(methodPOST)
+	api.HandleFunc("/tus/{id}", tusHeadHandler(signer)).Methods(methodHEAD)
+	api.HandleFunc("/tus/{id}", tusPatchHandler(q, broker, signer, limits, locks)).Methods(methodPATCH)
+	api.HandleFunc("/tus/{id}", tusDeleteHandler(signer, locks)).Methods(methodDELETE)
+}
+
+// uploadLocks serializes the requests writing to the same upload.
+type uploadLocks struct {
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+func (l *uploadLocks) lock(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.busy[id] {
+		return false
+	}
+	l.busy[id] = true
+
+	return true
+}
+
+func (l *uploadLocks) unlock(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.busy, id)
+}
+
+// checkTusResumable sets the Tus-Resumable header and checks that the client
+// speaks the same protocol version.
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		writeErrorResponse(w, http.StatusPreconditionFailed, fmt.Errorf("unsupported tus version"))
+		return false
+	}
+
+	return true
+}
+
+// parseTusMetadata decodes the Upload-Metadata header, a comma separated list
+// of keys each followed by an optional base64 encoded value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid Upload-Metadata")
+		}
+
+		var value []byte
+		if len(fields) == 2 {
+			var err error
+			value, err = base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %s", fields[0])
+			}
+		}
+
+		metadata[fields[0]] = string(value)
+	}
+
+	return metadata, nil
+}
+
+func encodeTusMetadata(metadata map[string]string) string {
+	var pairs []string
+	for k, v := range metadata {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// parseTusChecksum decodes the Upload-Checksum header, the algorithm followed
+// by the base64 encoded digest of the chunk.
+func parseTusChecksum(header string) (hash.Hash, []byte, error) {
+	fields := strings.Fields(header)
+	if len(fields) != 2 {
+		return nil, nil, fmt.Errorf("invalid Upload-Checksum")
+	}
+
+	var h hash.Hash
+	switch fields[0] {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm: %s", fields[0])
+	}
+
+	checksum, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid Upload-Checksum")
+	}
+
+	return h, checksum, nil
+}
+
+// @Summary Discover the tus server
+// @Description Get the tus protocol version, extensions and limits of the server.
+// @Tags tus
+// @Success 204
+// @Router /tus [options]
+func tusOptionsHandler(maxSize int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+		w.Header().Set("Tus-Checksum-Algorithm", tusChecksumAlgorithms)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary Create a resumable upload
+// @Description Create a tus 1.0 resumable audio upload, the filename, profile and dedup options are read from the metadata.
+// @Description The title, artist, album, isrc, year and keep_source_tags tags of the MP3 download are read from the metadata too, the cover is set by the tags update.
+// @Tags tus
+// @Param Tus-Resumable header string true "1.0.0"
+// @Param Upload-Length header integer true "Size of the upload in bytes"
+// @Param Upload-Metadata header string false "filename and profile, base64 encoded"
+// @Param signature query string false "Upload signature, required when uploads are signed"
+// @Success 201
+// @Failure 400 {object} server.ErrorResponse "Error"
+// @Failure 403 {object} server.ErrorResponse "Invalid upload signature"
+// @Failure 409 {object} server.ErrorResponse "Upload signature already used"
+// @Failure 412 {object} server.ErrorResponse "Unsupported tus version"
+// @Failure 413 {object} server.ErrorResponse "Upload too large"
+// @Failure 429 {object} server.ErrorResponse "Upload rate exceeded"
+// @Failure 503 {object} server.ErrorResponse "Transcode queue is full"
+// @Failure 507 {object} server.ErrorResponse "Storage quota exceeded"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /tus [post]
+func tusCreateHandler(q *queue.Queue, signer *services.Signer, limits *uploadLimits, authEnabled bool, cfg config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkTusResumable(w, r) {
+			return
+		}
+
+		if q.Full() {
+			writeRetryResponse(w, http.StatusServiceUnavailable, queueRetryAfter, queue.ErrQueueFull)
+			return
+		}
+
+		ua, status, err := authorizeUpload(r, signer, authEnabled)
+		if err != nil {
+			writeErrorResponse(w, status, err)
+			return
+		}
+
+		if !checkGrantUnused(w, ua) {
+			return
+		}
+
+		if !limits.allow(w, ua.Client) {
+			return
+		}
+
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length <= 0 {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid Upload-Length"))
+			return
+		}
+
+		if length > cfg.Uploads.MaxSize {
+			writeTooLargeResponse(w, cfg.Uploads.MaxSize)
+			return
+		}
+
+		if ua.Grant != nil && length > ua.Grant.MaxSize {
+			writeErrorResponse(w, http.StatusRequestEntityTooLarge, fmt.Errorf("upload must not exceed %d bytes", ua.Grant.MaxSize))
+			return
+		}
+
+		// reserved once completed, only checked to reject early
+		if !limits.checkQuota(w, ua.Client, length) {
+			return
+		}
+
+		metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
+
+		profile, err := lookupProfile(cfg, metadata["profile"])
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
+		metadata["profile"] = profile
+
+		if _, err := parseDedup(metadata["dedup"]); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if _, err := applyTrackTags(&models.TrackTags{}, metadataTags(metadata)); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if metadata["filename"] == "" {
+			metadata["filename"] = tusDefaultFileName
+		}
+
+		// used by this upload even though its transcode is created once
+		// completed
+		if !useGrant(w, ua) {
+			return
+		}
+
+		uploader, err := services.CreateTusUpload(metadata["filename"], services.TusInfo{
+			Length:    length,
+			Metadata:  metadata,
+			Grant:     ua.Grant,
+			Owner:     ua.Owner,
+			Client:    ua.Client,
+			CreatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Cannot create tus upload.")
+
+			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot create upload"))
+			return
+		}
+
+		log.Info().Str("upload", uploader.GetID()).Str("filename", metadata["filename"]).Int64("length", length).Msg("tus upload created")
+
+		// the signed URL authorizes the following requests of the upload
+		location := "/api/v1/tus/" + uploader.GetID()
+		if ua.Grant != nil {
+			location += "?" + signer.Query(*ua.Grant).Encode()
+		}
+
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// restoreTusUpload returns the upload of the request, writing the error
+// response when it cannot be found. The upload of another caller is not
+// found either.
+func restoreTusUpload(w http.ResponseWriter, r *http.Request, signer *services.Signer) (*services.Uploader, *services.TusInfo, bool) {
+	uploader, info, err := services.RestoreTusUpload(mux.Vars(r)["id"])
+	if err == services.ErrTusNotFound {
+		writeErrorResponse(w, http.StatusNotFound, err)
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot restore tus upload.")
+
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot read upload"))
+		return nil, nil, false
+	}
+
+	if !ownsTusUpload(r, signer, info) {
+		writeErrorResponse(w, http.StatusNotFound, services.ErrTusNotFound)
+		return nil, nil, false
+	}
+
+	return uploader, info, true
+}
+
+// ownsTusUpload reports whether the caller created the upload: a signed
+// upload requires the signature of its grant, the others the identity of
+// their owner.
+func ownsTusUpload(r *http.Request, signer *services.Signer, info *services.TusInfo) bool {
+	if info.Grant == nil {
+		return info.Owner == identityOwner(r)
+	}
+
+	if signer == nil {
+		return false
+	}
+
+	// the upload can outlive its signature, which was valid on creation
+	grant, err := signer.Verify(r.URL.Query(), info.CreatedAt)
+	if err != nil {
+		return false
+	}
+
+	return grant.JobID == info.Grant.JobID
+}
+
+// @Summary Get the offset of a resumable upload
+// @Description Get the number of bytes received, to resume the upload. Once completed the transcode id is returned in Upload-Transcode-Id.
+// @Tags tus
+// @Param Tus-Resumable header string true "1.0.0"
+// @Param id path string true "Upload ID"
+// @Param signature query string false "Upload signature, required for the uploads created with a signed URL"
+// @Success 200
+// @Failure 404 {object} server.ErrorResponse "Upload not found or created by another caller"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /tus/{id} [head]
+func tusHeadHandler(signer *services.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkTusResumable(w, r) {
+			return
+		}
+
+		uploader, info, ok := restoreTusUpload(w, r, signer)
+		if !ok {
+			return
+		}
+
+		offset, err := uploader.GetOffset()
+		if err != nil {
+			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot read upload"))
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+		w.Header().Set("Upload-Metadata", encodeTusMetadata(info.Metadata))
+
+		if info.TranscodeID != "" {
+			w.Header().Set("Upload-Transcode-Id", info.TranscodeID)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// @Summary Upload a chunk
+// @Description Append a chunk at Upload-Offset. The completed upload is checked and handed to the transcode queue, its id is returned in Upload-Transcode-Id.
+// @Description A completed upload which could not be handed to the queue, e.g. on a 503 or 507 response, is kept and handed again by an empty chunk at Upload-Length.
+// @Tags tus
+// @Accept application/offset+octet-stream
+// @Param Tus-Resumable header string true "1.0.0"
+// @Param Upload-Offset header integer true "Offset of the chunk"
+// @Param Upload-Checksum header string false "Checksum algorithm and base64 digest of the chunk"
+// @Param id path string true "Upload ID"
+// @Param signature query string false "Upload signature, required for the uploads created with a signed URL"
+// @Success 204
+// @Failure 400 {object} server.ErrorResponse "Error"
+// @Failure 404 {object} server.ErrorResponse "Upload not found or created by another caller"
+// @Failure 409 {object} server.ErrorResponse "Offset mismatch"
+// @Failure 415 {object} server.ErrorResponse "Wrong content type"
+// @Failure 423 {object} server.ErrorResponse "Upload in use by another request"
+// @Failure 460 {object} server.ErrorResponse "Checksum mismatch"
+// @Failure 503 {object} server.ErrorResponse "Transcode queue is full"
+// @Failure 507 {object} server.ErrorResponse "Storage quota exceeded"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /tus/{id} [patch]
+func tusPatchHandler(q *queue.Queue, broker *events.Broker, signer *services.Signer, limits *uploadLimits, locks *uploadLocks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkTusResumable(w, r) {
+			return
+		}
+
+		if r.Header.Get("Content-Type") != tusContentType {
+			writeErrorResponse(w, http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type must be %s", tusContentType))
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		if !locks.lock(id) {
+			writeErrorResponse(w, http.StatusLocked, fmt.Errorf("upload is in use"))
+			return
+		}
+		defer locks.unlock(id)
+
+		uploader, info, ok := restoreTusUpload(w, r, signer)
+		if !ok {
+			return
+		}
+
+		offset, err := uploader.GetOffset()
+		if err != nil {
+			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot read upload"))
+			return
+		}
+
+		if r.Header.Get("Upload-Offset") != strconv.FormatInt(offset, 10) || info.TranscodeID != "" {
+			w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+			writeErrorResponse(w, http.StatusConflict, fmt.Errorf("offset mismatch, expected %d", offset))
+			return
+		}
+
+		// a completed upload which could not be enqueued, e.g. as the queue
+		// was full, is enqueued again by a request at its length
+		if offset < info.Length {
+			var (
+				h        hash.Hash
+				checksum []byte
+			)
+			if v := r.Header.Get("Upload-Checksum"); v != "" {
+				h, checksum, err = parseTusChecksum(v)
+				if err != nil {
+					writeErrorResponse(w, http.StatusBadRequest, err)
+					return
+				}
+			}
+
+			offset, err = uploader.AppendChunk(offset, info.Length-offset, r.Body, h, checksum)
+			if errors.Is(err, services.ErrTusChecksumMismatch) {
+				writeErrorResponse(w, statusChecksumMismatch, err)
+				return
+			}
+			if err != nil {
+				// the bytes received so far are kept, the client resumes from HEAD
+				log.Warn().Err(err).Str("upload", id).Int64("offset", offset).Msg("tus chunk interrupted")
+
+				writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot write chunk"))
+				return
+			}
+
+			if offset < info.Length {
+				w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			log.Info().Str("upload", id).Str("filename", uploader.Header.Filename).Msg("tus upload completed")
+		}
+
+		format, err := detectAudioFormat(uploader)
+		if err != nil {
+			log.Error().Err(err).Str("filename", uploader.Header.Filename).Msg("Rejected audio file")
+
+			_ = os.RemoveAll(uploader.GetDir())
+
+			writeErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if status, err := checkGrant(info.Grant, format, info.Length); err != nil {
+			_ = os.RemoveAll(uploader.GetDir())
+
+			writeErrorResponse(w, status, err)
+			return
+		}
+
+		ua, err := newUploadAuth(info.Grant, info.Owner, info.Client)
+		if err != nil {
+			writeErrorResponse(w, http.StatusForbidden, err)
+			return
+		}
+
+		// validated on creation
+		dedup, _ := parseDedup(info.Metadata["dedup"])
+
+		tags := &models.TrackTags{}
+		if sent, _ := applyTrackTags(tags, metadataTags(info.Metadata)); !sent {
+			tags = nil
+		}
+
+		res, ok := enqueueAudio(w, q, broker, limits, uploader, format, ua, info.Metadata["profile"], dedup, tags, true)
+		if !ok {
+			return
+		}
+
+		info.TranscodeID = res.Id
+		if err := uploader.SaveTusInfo(*info); err != nil {
+			log.Error().Err(err).Str("upload", id).Msg("Cannot save tus upload.")
+		}
+
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		w.Header().Set("Upload-Transcode-Id", res.Id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary Terminate a resumable upload
+// @Description Remove an upload which has not been handed to the transcode queue yet.
+// @Tags tus
+// @Param Tus-Resumable header string true "1.0.0"
+// @Param id path string true "Upload ID"
+// @Param signature query string false "Upload signature, required for the uploads created with a signed URL"
+// @Success 204
+// @Failure 404 {object} server.ErrorResponse "Upload not found or created by another caller"
+// @Failure 409 {object} server.ErrorResponse "Upload already transcoding"
+// @Failure 423 {object} server.ErrorResponse "Upload in use by another request"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /tus/{id} [delete]
+func tusDeleteHandler(signer *services.Signer, locks *uploadLocks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkTusResumable(w, r) {
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		if !locks.lock(id) {
+			writeErrorResponse(w, http.StatusLocked, fmt.Errorf("upload is in use"))
+			return
+		}
+		defer locks.unlock(id)
+
+		uploader, info, ok := restoreTusUpload(w, r, signer)
+		if !ok {
+			return
+		}
+
+		if info.TranscodeID != "" {
+			writeErrorResponse(w, http.StatusConflict, fmt.Errorf("upload is already transcoding"))
+			return
+		}
+
+		if err := os.RemoveAll(uploader.GetDir()); err != nil {
+			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("cannot remove upload"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
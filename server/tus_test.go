@@ -0,0 +1,275 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/auth"
+	"github.com/angelorc/go-uploader/config"
+	"github.com/angelorc/go-uploader/events"
+	"github.com/angelorc/go-uploader/server"
+	"github.com/angelorc/go-uploader/services"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func chdirTemp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+		_ = os.RemoveAll(dir)
+	})
+}
+
+// testContext returns a context done once the test ends.
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+func newTusRouter(t *testing.T) *mux.Router {
+	chdirTemp(t)
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, nil, config.DefaultConfig())
+
+	return router
+}
+
+func tusRequest(router *mux.Router, method string, target string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func createTusUpload(t *testing.T, router *mux.Router, length int) string {
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("master.wav")) + ",profile"
+
+	rec := tusRequest(router, http.MethodPost, "/api/v1/tus", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": metadata,
+	})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Equal(t, "1.0.0", rec.Header().Get("Tus-Resumable"))
+
+	location := rec.Header().Get("Location")
+	require.Regexp(t, "^/api/v1/tus/[0-9a-f-]{36}$", location)
+
+	return location
+}
+
+func patchChunk(router *mux.Router, location string, offset int, chunk []byte, headers map[string]string) *httptest.ResponseRecorder {
+	h := map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	}
+	for k, v := range headers {
+		h[k] = v
+	}
+
+	return tusRequest(router, http.MethodPatch, location, chunk, h)
+}
+
+func TestTusOptions(t *testing.T) {
+	router := newTusRouter(t)
+
+	rec := tusRequest(router, http.MethodOptions, "/api/v1/tus", nil, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "1.0.0", rec.Header().Get("Tus-Version"))
+	require.Equal(t, "creation,termination,checksum", rec.Header().Get("Tus-Extension"))
+	require.Contains(t, rec.Header().Get("Tus-Checksum-Algorithm"), "sha1")
+	require.NotEmpty(t, rec.Header().Get("Tus-Max-Size"))
+}
+
+func TestTusResume(t *testing.T) {
+	router := newTusRouter(t)
+	location := createTusUpload(t, router, 10)
+
+	rec := tusRequest(router, http.MethodHead, location, nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "0", rec.Header().Get("Upload-Offset"))
+	require.Equal(t, "10", rec.Header().Get("Upload-Length"))
+	require.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+	rec = patchChunk(router, location, 0, []byte("RIFF"), nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "4", rec.Header().Get("Upload-Offset"))
+
+	// a chunk sent at a stale offset is refused
+	rec = patchChunk(router, location, 0, []byte("RIFF"), nil)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = tusRequest(router, http.MethodHead, location, nil, nil)
+	require.Equal(t, "4", rec.Header().Get("Upload-Offset"))
+
+	// a corrupted chunk is discarded
+	sum := sha1.Sum([]byte("\x00\x00\x00\x00"))
+	rec = patchChunk(router, location, 4, []byte("\x24\x00\x00\x00"), map[string]string{
+		"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(sum[:]),
+	})
+	require.Equal(t, 460, rec.Code)
+
+	rec = tusRequest(router, http.MethodHead, location, nil, nil)
+	require.Equal(t, "4", rec.Header().Get("Upload-Offset"))
+
+	sum = sha1.Sum([]byte("\x24\x00\x00\x00"))
+	rec = patchChunk(router, location, 4, []byte("\x24\x00\x00\x00"), map[string]string{
+		"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(sum[:]),
+	})
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "8", rec.Header().Get("Upload-Offset"))
+
+	rec = patchChunk(router, location, 8, []byte("x"), map[string]string{"Upload-Checksum": "crc32 AAAAAA=="})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestTusCompletedUploadIsChecked(t *testing.T) {
+	router := newTusRouter(t)
+
+	executable := []byte("MZ\x90\x00\x03\x00\x00\x00")
+	location := createTusUpload(t, router, len(executable))
+
+	rec := patchChunk(router, location, 0, executable, nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var res server.ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Equal(t, "unsupported file: executable files are not allowed", res.Error)
+
+	// rejected uploads are removed
+	rec = tusRequest(router, http.MethodHead, location, nil, nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestTusCompletedUploadIsEnqueuedAgain(t *testing.T) {
+	router := newTusRouter(t)
+
+	executable := []byte("MZ\x90\x00\x03\x00\x00\x00")
+	location := createTusUpload(t, router, len(executable))
+
+	// completed, as when kept after the queue was full
+	uploader, info, err := services.RestoreTusUpload(path.Base(location))
+	require.NoError(t, err)
+
+	_, err = uploader.AppendChunk(0, info.Length, bytes.NewReader(executable), nil, nil)
+	require.NoError(t, err)
+
+	rec := tusRequest(router, http.MethodHead, location, nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, strconv.Itoa(len(executable)), rec.Header().Get("Upload-Offset"))
+
+	// the offset must still match
+	rec = patchChunk(router, location, 0, nil, nil)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	// an empty request at the length checks and enqueues it again
+	rec = patchChunk(router, location, len(executable), nil, nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var res server.ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Equal(t, "unsupported file: executable files are not allowed", res.Error)
+}
+
+func TestTusTermination(t *testing.T) {
+	router := newTusRouter(t)
+	location := createTusUpload(t, router, 10)
+
+	rec := tusRequest(router, http.MethodDelete, location, nil, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = tusRequest(router, http.MethodHead, location, nil, nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = patchChunk(router, location, 0, []byte("RIFF"), nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestTusCreationErrors(t *testing.T) {
+	router := newTusRouter(t)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		code    int
+	}{
+		{"wrong version", map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "10"}, http.StatusPreconditionFailed},
+		{"missing length", nil, http.StatusBadRequest},
+		{"too large", map[string]string{"Upload-Length": "1099511627776"}, http.StatusRequestEntityTooLarge},
+		{"unknown profile", map[string]string{
+			"Upload-Length":   "10",
+			"Upload-Metadata": "profile " + base64.StdEncoding.EncodeToString([]byte("dolby")),
+		}, http.StatusBadRequest},
+		{"invalid metadata", map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename !!"}, http.StatusBadRequest},
+		{"invalid tags", map[string]string{
+			"Upload-Length":   "10",
+			"Upload-Metadata": "isrc " + base64.StdEncoding.EncodeToString([]byte("nope")),
+		}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := tusRequest(router, http.MethodPost, "/api/v1/tus", nil, tt.headers)
+			require.Equal(t, tt.code, rec.Code)
+		})
+	}
+}
+
+func TestTusRequiresOwner(t *testing.T) {
+	chdirTemp(t)
+
+	cfg := config.DefaultConfig()
+	keys := map[string]string{"alice": "alice-token", "bob": "bob-token"}
+
+	router := mux.NewRouter()
+	server.RegisterRoutes(testContext(t), router, newTestQueue(t, 1), events.NewBroker(time.Minute), nil, auth.APIKeys(keys), cfg)
+
+	rec := tusRequest(router, http.MethodPost, "/api/v1/tus", nil, map[string]string{
+		"Upload-Length":   "10",
+		auth.APIKeyHeader: "alice-token",
+	})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	location := rec.Header().Get("Location")
+
+	// the upload of another caller is not found
+	for _, headers := range []map[string]string{nil, {auth.APIKeyHeader: "bob-token"}} {
+		rec = tusRequest(router, http.MethodHead, location, nil, headers)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = patchChunk(router, location, 0, []byte("RIFF"), headers)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = tusRequest(router, http.MethodDelete, location, nil, headers)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	rec = tusRequest(router, http.MethodHead, location, nil, map[string]string{auth.APIKeyHeader: "alice-token"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "0", rec.Header().Get("Upload-Offset"))
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// waveformTypes are the content types of the waveform formats.
+var waveformTypes = map[string]string{
+	transcoder.WaveformJSON: "application/json",
+	transcoder.WaveformDat:  "application/octet-stream",
+}
+
+// @Summary Get transcode waveform
+// @Description Get the waveform peaks of a transcode, the min and max 8 bit sample of every bucket of samples_per_pixel samples, in the JSON or binary dat format of audiowaveform.
+// @Description The available samples per pixel are 512, 1024, 2048 and 4096. Range requests and conditional requests on the ETag are supported.
+// @Tags transcode
+// @Produce json
+// @Produce octet-stream
+// @Param id path string true "ID"
+// @Param samples_per_pixel query integer false "Samples per pixel, 512 by default"
+// @Param format query string false "json (default) or dat"
+// @Success 200 {string} string "Waveform"
+// @Success 304 "Not modified"
+// @Failure 400 {object} server.ErrorResponse "Failure to parse the id or the parameters"
+// @Failure 404 {object} server.ErrorResponse "Failure to find the waveform"
+// @Router /transcode/{id}/waveform [get]
+func waveformHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params = mux.Vars(r)
+
+		pid, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("cannot decode id"))
+			return
+		}
+
+		samplesPerPixel := transcoder.WaveformResolutions[0]
+		if v := r.URL.Query().Get("samples_per_pixel"); v != "" {
+			samplesPerPixel, err = strconv.Atoi(v)
+			if err != nil || !isWaveformResolution(samplesPerPixel) {
+				writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("samples_per_pixel must be one of %v", transcoder.WaveformResolutions))
+				return
+			}
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = transcoder.WaveformJSON
+		}
+
+		contentType, ok := waveformTypes[format]
+		if !ok {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("format must be %s or %s", transcoder.WaveformJSON, transcoder.WaveformDat))
+			return
+		}
+
+		key := pid.Hex() + "/" + transcoder.WaveformFileName(samplesPerPixel, format)
+
+		// revalidated, a transcode run again stores new waveforms
+		serveObject(w, r, store, key, contentType, playlistCacheControl)
+	}
+}
+
+func isWaveformResolution(samplesPerPixel int) bool {
+	for _, r := range transcoder.WaveformResolutions {
+		if r == samplesPerPixel {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,17 +1,33 @@
 package services
 
-import shell "github.com/ipfs/go-ipfs-api"
+import (
+	"os"
+
+	shell "github.com/ipfs/go-ipfs-api"
+)
 
 const (
 	IPFS_ENDPOINT = "https://ipfs.infura.io:5001"
+	IPFS_GATEWAY  = "https://ipfs.infura.io/ipfs/"
 )
 
 type Ipfs struct {
 	*shell.Shell
 }
 
-func NewIpfs() *Ipfs {
+func NewIpfs(endpoint string) *Ipfs {
 	return &Ipfs{
-		Shell: shell.NewShell(IPFS_ENDPOINT),
+		Shell: shell.NewShell(endpoint),
+	}
+}
+
+// AddFile adds the file stored at path to ipfs and returns its CID.
+func (i *Ipfs) AddFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
 	}
+	defer f.Close()
+
+	return i.Add(f)
 }
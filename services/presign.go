@@ -0,0 +1,133 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSignatureMissing = errors.New("upload signature is required")
+	ErrSignatureInvalid = errors.New("invalid upload signature")
+	ErrSignatureExpired = errors.New("upload signature has expired")
+)
+
+// UploadGrant authorizes a single upload, it is carried by the query of a
+// signed upload URL.
+type UploadGrant struct {
+	// JobID is the id of the transcode created by the upload.
+	JobID string `json:"job_id"`
+	// Owner is the identity stored on the transcode.
+	Owner string `json:"owner,omitempty"`
+	// MaxSize is the largest accepted file, in bytes.
+	MaxSize int64 `json:"max_size"`
+	// Types are the accepted audio format names, any registered format when empty.
+	Types   []string  `json:"types,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// AllowsType reports whether the grant accepts the audio format.
+func (g UploadGrant) AllowsType(format string) bool {
+	if len(g.Types) == 0 {
+		return true
+	}
+
+	for _, t := range g.Types {
+		if t == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Signer signs and verifies upload grants with HMAC-SHA256.
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret string) *Signer {
+	return &Signer{
+		secret: []byte(secret),
+	}
+}
+
+func (s *Signer) sign(g UploadGrant) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(strings.Join([]string{
+		"v1",
+		g.JobID,
+		g.Owner,
+		strconv.FormatInt(g.MaxSize, 10),
+		strings.Join(g.Types, ","),
+		strconv.FormatInt(g.Expires.Unix(), 10),
+	}, "\n")))
+
+	return mac.Sum(nil)
+}
+
+// Query returns the signed query parameters of the grant.
+func (s *Signer) Query(g UploadGrant) url.Values {
+	q := url.Values{}
+	q.Set("job", g.JobID)
+	if g.Owner != "" {
+		q.Set("owner", g.Owner)
+	}
+	q.Set("max_size", strconv.FormatInt(g.MaxSize, 10))
+	if len(g.Types) > 0 {
+		q.Set("types", strings.Join(g.Types, ","))
+	}
+	q.Set("expires", strconv.FormatInt(g.Expires.Unix(), 10))
+	q.Set("signature", hex.EncodeToString(s.sign(g)))
+
+	return q
+}
+
+// Verify returns the grant carried by the signed query parameters, when the
+// signature matches and has not expired at now.
+func (s *Signer) Verify(q url.Values, now time.Time) (*UploadGrant, error) {
+	if q.Get("signature") == "" {
+		return nil, ErrSignatureMissing
+	}
+
+	signature, err := hex.DecodeString(q.Get("signature"))
+	if err != nil {
+		return nil, ErrSignatureInvalid
+	}
+
+	maxSize, err := strconv.ParseInt(q.Get("max_size"), 10, 64)
+	if err != nil {
+		return nil, ErrSignatureInvalid
+	}
+
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return nil, ErrSignatureInvalid
+	}
+
+	g := UploadGrant{
+		JobID:   q.Get("job"),
+		Owner:   q.Get("owner"),
+		MaxSize: maxSize,
+		Expires: time.Unix(expires, 0).UTC(),
+	}
+
+	if types := q.Get("types"); types != "" {
+		g.Types = strings.Split(types, ",")
+	}
+
+	if !hmac.Equal(signature, s.sign(g)) {
+		return nil, ErrSignatureInvalid
+	}
+
+	if now.After(g.Expires) {
+		return nil, ErrSignatureExpired
+	}
+
+	return &g, nil
+}
@@ -0,0 +1,50 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/angelorc/go-uploader/services"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignerVerify(t *testing.T) {
+	signer := services.NewSigner("secret")
+	now := time.Unix(1600000000, 0)
+
+	grant := services.UploadGrant{
+		JobID:   "5f5e1000a1b2c3d4e5f60718",
+		MaxSize: 10 << 20,
+		Types:   []string{"flac", "wav"},
+		Expires: now.Add(time.Minute).UTC(),
+	}
+
+	verified, err := signer.Verify(signer.Query(grant), now)
+	require.NoError(t, err)
+	require.Equal(t, grant, *verified)
+	require.True(t, verified.AllowsType("flac"))
+	require.False(t, verified.AllowsType("mp3"))
+
+	_, err = signer.Verify(signer.Query(grant), now.Add(2*time.Minute))
+	require.Equal(t, services.ErrSignatureExpired, err)
+
+	_, err = services.NewSigner("other").Verify(signer.Query(grant), now)
+	require.Equal(t, services.ErrSignatureInvalid, err)
+
+	for _, param := range []string{"job", "max_size", "types", "expires"} {
+		q := signer.Query(grant)
+		q.Set(param, "1")
+
+		_, err := signer.Verify(q, now)
+		require.Equal(t, services.ErrSignatureInvalid, err, param)
+	}
+
+	q := signer.Query(grant)
+	q.Del("signature")
+	_, err = signer.Verify(q, now)
+	require.Equal(t, services.ErrSignatureMissing, err)
+}
+
+func TestUploadGrantAnyType(t *testing.T) {
+	require.True(t, services.UploadGrant{}.AllowsType("mp3"))
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const tusInfoFileName = "tus.json"
+
+var (
+	ErrTusNotFound         = errors.New("upload not found")
+	ErrTusChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// TusInfo is the state of a resumable upload, stored next to the partial
+// file. The offset is not stored, it is the size of the file on disk.
+type TusInfo struct {
+	Length   int64             `json:"length"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Grant is set when the upload was created with a signed URL.
+	Grant *UploadGrant `json:"grant,omitempty"`
+	// Owner is the identity of the authenticated caller creating the upload.
+	Owner string `json:"owner,omitempty"`
+	// Client identifies the caller of the upload rate and quota.
+	Client string `json:"client,omitempty"`
+	// TranscodeID is set once the completed upload is handed to the queue.
+	TranscodeID string    `json:"transcode_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CreateTusUpload creates the directory and the empty original file of a
+// resumable upload of the given length.
+func CreateTusUpload(filename string, info TusInfo) (*Uploader, error) {
+	u := NewUploader(nil, &multipart.FileHeader{
+		Filename: filename,
+		Size:     info.Length,
+	})
+
+	if err := u.createDir(u.GetDir()); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(u.GetTmpOriginalFileName())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := u.SaveTusInfo(info); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// RestoreTusUpload returns the uploader and the state of the resumable upload
+// with the given id.
+func RestoreTusUpload(id string) (*Uploader, *TusInfo, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, nil, ErrTusNotFound
+	}
+
+	u := &Uploader{
+		ID:     uid,
+		Header: &multipart.FileHeader{},
+	}
+
+	bz, err := ioutil.ReadFile(u.GetTusInfoFileName())
+	if os.IsNotExist(err) {
+		return nil, nil, ErrTusNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var info TusInfo
+	if err := json.Unmarshal(bz, &info); err != nil {
+		return nil, nil, err
+	}
+
+	u.Header.Filename = info.Metadata["filename"]
+	u.Header.Size = info.Length
+
+	return u, &info, nil
+}
+
+func (u *Uploader) GetTusInfoFileName() string {
+	return u.GetDir() + tusInfoFileName
+}
+
+func (u *Uploader) SaveTusInfo(info TusInfo) error {
+	bz, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(u.GetTusInfoFileName(), bz, 0644)
+}
+
+// GetOffset returns the number of bytes of the resumable upload received so far.
+func (u *Uploader) GetOffset() (int64, error) {
+	fi, err := os.Stat(u.GetTmpOriginalFileName())
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+// AppendChunk appends up to limit bytes of r to the original file, which must
+// be offset bytes long, and returns the new offset. When h is set the chunk is
+// kept only if its digest equals checksum, otherwise the file is truncated
+// back to offset and ErrTusChecksumMismatch is returned. Without a checksum
+// the bytes received before a read error are kept, so the upload can resume.
+func (u *Uploader) AppendChunk(offset int64, limit int64, r io.Reader, h hash.Hash, checksum []byte) (int64, error) {
+	f, err := os.OpenFile(u.GetTmpOriginalFileName(), os.O_WRONLY, 0644)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	var w io.Writer = f
+	if h != nil {
+		w = io.MultiWriter(f, h)
+	}
+
+	n, err := io.Copy(w, io.LimitReader(r, limit))
+	if err == nil && h != nil && !bytes.Equal(h.Sum(nil), checksum) {
+		err = ErrTusChecksumMismatch
+	}
+
+	if err != nil && h != nil {
+		if terr := f.Truncate(offset); terr != nil {
+			return offset, terr
+		}
+
+		return offset, err
+	}
+
+	return offset + n, err
+}
@@ -1,6 +1,9 @@
 package services
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"github.com/google/uuid"
 	"io"
 	"mime/multipart"
@@ -8,25 +11,46 @@ import (
 	"path/filepath"
 )
 
+// TooLargeError is returned when an upload exceeds the max size.
+type TooLargeError struct {
+	Limit int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("upload must not exceed %d bytes", e.Limit)
+}
+
 type Uploader struct {
 	ID     uuid.UUID
 	File   multipart.File
 	Header *multipart.FileHeader
+	// Sha256 is the hex encoded hash of the original, set once saved.
+	Sha256 string
 }
 
 func NewUploader(file multipart.File, header *multipart.FileHeader) *Uploader {
-	id, err := uuid.NewUUID()
-	if err != nil {
-		panic("cannot generate new uuid")
-	}
-
+	// random, the ids of the uploads must not be guessable
 	return &Uploader{
-		ID:     id,
+		ID:     uuid.New(),
 		File:   file,
 		Header: header,
 	}
 }
 
+// RestoreUploader returns the uploader of a previously saved upload, identified
+// by its id and the original file name.
+func RestoreUploader(id string, filename string) (*Uploader, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Uploader{
+		ID:     uid,
+		Header: &multipart.FileHeader{Filename: filename},
+	}, nil
+}
+
 func (u *Uploader) GetID() string {
 	return u.ID.String()
 }
@@ -39,13 +63,37 @@ func (u *Uploader) GetExtension() string {
 	return filepath.Ext(u.Header.Filename)
 }
 
-func (u *Uploader) IsAudio() bool {
-	contentType := u.GetContentType()
-	return contentType == "audio/aac" || contentType == "audio/wav" || contentType == "audio/mp3" || contentType == "application/octet-stream"
+// Sniff returns up to n leading bytes of the uploaded file, to detect its
+// format from the content rather than the client supplied Content-Type. The
+// saved original is read when the upload has no multipart file.
+func (u *Uploader) Sniff(n int) ([]byte, error) {
+	header := make([]byte, n)
+
+	var file io.ReaderAt = u.File
+	if u.File == nil {
+		f, err := os.Open(u.GetTmpOriginalFileName())
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		file = f
+	}
+
+	read, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return header[:read], nil
 }
 
+// UploadsDir is the working directory of the uploads, one directory per
+// upload id.
+const UploadsDir = ".bitsongms/uploader"
+
 func (u *Uploader) GetDir() string {
-	return ".bitsongms/uploader/" + u.GetID() + "/"
+	return UploadsDir + "/" + u.GetID() + "/"
 }
 
 func (u *Uploader) GetTmpOriginalFileName() string {
@@ -67,7 +115,10 @@ func (u *Uploader) createDir(path string) error {
 	return nil
 }
 
-func (u *Uploader) SaveOriginal() (*os.File, error) {
+// SaveOriginal writes the uploaded file to the original file, up to maxSize
+// bytes. A larger file is aborted while streaming: the partially written
+// upload is removed and a TooLargeError returned.
+func (u *Uploader) SaveOriginal(maxSize int64) (*os.File, error) {
 	// create tmp dir
 	if err := u.createDir(u.GetDir()); err != nil {
 		return nil, err
@@ -79,11 +130,60 @@ func (u *Uploader) SaveOriginal() (*os.File, error) {
 		return nil, err
 	}
 
-	// write the content from POST to the file
-	_, err = io.Copy(buff, u.File)
+	// write the content from POST to the file, hashing it on the way
+	h := sha256.New()
+
+	_, err = io.Copy(io.MultiWriter(buff, h), &countingReader{r: u.File, limit: maxSize})
 	if err != nil {
+		buff.Close()
+		_ = os.RemoveAll(u.GetDir())
+
 		return nil, err
 	}
 
+	u.Sha256 = hex.EncodeToString(h.Sum(nil))
+
 	return buff, nil
 }
+
+// GetSha256 returns the hex encoded hash of the saved original, it is read
+// from the file when the original was not saved by SaveOriginal.
+func (u *Uploader) GetSha256() (string, error) {
+	if u.Sha256 != "" {
+		return u.Sha256, nil
+	}
+
+	f, err := os.Open(u.GetTmpOriginalFileName())
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	u.Sha256 = hex.EncodeToString(h.Sum(nil))
+
+	return u.Sha256, nil
+}
+
+// countingReader counts the bytes read from r, failing with a TooLargeError
+// as soon as they exceed limit.
+type countingReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+
+	if c.read > c.limit {
+		return n, &TooLargeError{Limit: c.limit}
+	}
+
+	return n, err
+}
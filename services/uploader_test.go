@@ -0,0 +1,70 @@
+package services_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/angelorc/go-uploader/services"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveOriginalMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "services")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	src := filepath.Join(dir, "song.mp3")
+	require.NoError(t, ioutil.WriteFile(src, make([]byte, 64), 0644))
+
+	save := func(maxSize int64) (*services.Uploader, error) {
+		f, err := os.Open(src)
+		require.NoError(t, err)
+		defer f.Close()
+
+		// the multipart size is not trusted, the bytes are counted while saving
+		u := services.NewUploader(f, &multipart.FileHeader{Filename: "song.mp3", Size: 1})
+		original, err := u.SaveOriginal(maxSize)
+		if err == nil {
+			original.Close()
+		}
+
+		return u, err
+	}
+
+	u, err := save(16)
+	var tooLarge *services.TooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+	require.Equal(t, int64(16), tooLarge.Limit)
+	require.EqualError(t, err, "upload must not exceed 16 bytes")
+
+	// the partially written upload is removed
+	_, err = os.Stat(u.GetDir())
+	require.True(t, os.IsNotExist(err))
+
+	u, err = save(64)
+	require.NoError(t, err)
+
+	info, err := os.Stat(u.GetTmpOriginalFileName())
+	require.NoError(t, err)
+	require.Equal(t, int64(64), info.Size())
+
+	// sha256 of 64 zero bytes, hashed while saving or read from the file
+	const sum = "f5a5fd42d16a20302798ef6ed309979b43003d2320d9f0e8ea9831a92759fb4b"
+	require.Equal(t, sum, u.Sha256)
+
+	restored, err := services.RestoreUploader(u.GetID(), "song.mp3")
+	require.NoError(t, err)
+
+	got, err := restored.GetSha256()
+	require.NoError(t, err)
+	require.Equal(t, sum, got)
+}
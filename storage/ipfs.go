@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/angelorc/go-uploader/services"
+	shell "github.com/ipfs/go-ipfs-api"
+)
+
+// Ipfs stores the objects in the mutable file system of an ipfs node, below
+// Root. The stored files are added to ipfs, the CID of an object is
+// returned by CID.
+type Ipfs struct {
+	ipfs *services.Ipfs
+	Root string
+}
+
+func NewIpfs(ipfs *services.Ipfs, root string) *Ipfs {
+	return &Ipfs{
+		ipfs: ipfs,
+		Root: "/" + strings.Trim(root, "/"),
+	}
+}
+
+type ipfsStat struct {
+	Hash string `json:"Hash"`
+	Size int64  `json:"Size"`
+	Type string `json:"Type"`
+}
+
+type ipfsLs struct {
+	Entries []struct {
+		Name string `json:"Name"`
+		Type int    `json:"Type"`
+		Size int64  `json:"Size"`
+	} `json:"Entries"`
+}
+
+const ipfsTypeDirectory = 1
+
+func (i *Ipfs) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(i.Root, key), nil
+}
+
+// ipfsError maps the missing files to ErrNotFound.
+func ipfsError(err error) error {
+	if e, ok := err.(*shell.Error); ok && strings.Contains(e.Message, "does not exist") {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (i *Ipfs) Put(key string, r io.Reader) error {
+	p, err := i.path(key)
+	if err != nil {
+		return err
+	}
+
+	// streamed as the multipart file expected by files/write
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		fw, err := mw.CreateFormFile("file", path.Base(p))
+		if err == nil {
+			_, err = io.Copy(fw, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	err = i.ipfs.Request("files/write", p).
+		Option("create", true).
+		Option("parents", true).
+		Option("truncate", true).
+		Header("Content-Type", mw.FormDataContentType()).
+		Body(pr).
+		Exec(context.Background(), nil)
+
+	// unblocks the writer when the request failed early
+	pr.Close()
+
+	return ipfsError(err)
+}
+
+func (i *Ipfs) Get(key string) (io.ReadCloser, error) {
+	p, err := i.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := i.ipfs.Request("files/read", p).Send(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Error != nil {
+		res.Close()
+		return nil, ipfsError(res.Error)
+	}
+
+	return res.Output, nil
+}
+
+func (i *Ipfs) stat(p string) (ipfsStat, error) {
+	var stat ipfsStat
+	err := i.ipfs.Request("files/stat", p).Exec(context.Background(), &stat)
+
+	return stat, ipfsError(err)
+}
+
+func (i *Ipfs) Stat(key string) (ObjectInfo, error) {
+	p, err := i.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	stat, err := i.stat(p)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	if stat.Type == "directory" {
+		return ObjectInfo{}, ErrNotFound
+	}
+
+	return ObjectInfo{
+		Key:  key,
+		Size: stat.Size,
+	}, nil
+}
+
+// CID returns the CID of the stored object.
+func (i *Ipfs) CID(key string) (string, error) {
+	p, err := i.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	stat, err := i.stat(p)
+
+	return stat.Hash, err
+}
+
+// List walks the directories below the last directory of prefix.
+func (i *Ipfs) List(prefix string) ([]ObjectInfo, error) {
+	dir := ""
+	if n := strings.LastIndex(prefix, "/"); n >= 0 {
+		dir = prefix[:n]
+	}
+
+	objects := []ObjectInfo{}
+	if err := i.walk(dir, prefix, &objects); err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	return objects, nil
+}
+
+func (i *Ipfs) walk(dir string, prefix string, objects *[]ObjectInfo) error {
+	var ls ipfsLs
+	err := i.ipfs.Request("files/ls", path.Join(i.Root, dir)).
+		Option("long", true).
+		Exec(context.Background(), &ls)
+	if err != nil {
+		return ipfsError(err)
+	}
+
+	for _, e := range ls.Entries {
+		key := path.Join(dir, e.Name)
+
+		if e.Type == ipfsTypeDirectory {
+			// skipped when outside of the prefix
+			if strings.HasPrefix(key+"/", prefix) || strings.HasPrefix(prefix, key+"/") {
+				if err := i.walk(key, prefix, objects); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(key, prefix) {
+			*objects = append(*objects, ObjectInfo{Key: key, Size: e.Size})
+		}
+	}
+
+	return nil
+}
+
+func (i *Ipfs) Delete(key string) error {
+	p, err := i.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = ipfsError(i.ipfs.Request("files/rm", p).Exec(context.Background(), nil))
+	if err == ErrNotFound {
+		return nil
+	}
+
+	return err
+}
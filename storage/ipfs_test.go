@@ -0,0 +1,142 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/angelorc/go-uploader/services"
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMFS is a local stand-in for the files API of an ipfs node.
+type fakeMFS struct {
+	t *testing.T
+
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (f *fakeMFS) notFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"Message": "file does not exist", "Code": 0, "Type": "error"})
+}
+
+func (f *fakeMFS) isDir(p string) bool {
+	for name := range f.files {
+		if strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *fakeMFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p := r.URL.Query().Get("arg")
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/v0/") {
+	case "files/write":
+		require.Equal(f.t, "true", r.URL.Query().Get("create"))
+		require.Equal(f.t, "true", r.URL.Query().Get("truncate"))
+
+		file, _, err := r.FormFile("file")
+		require.NoError(f.t, err)
+
+		bz, err := ioutil.ReadAll(file)
+		require.NoError(f.t, err)
+		f.files[p] = bz
+
+	case "files/read":
+		bz, ok := f.files[p]
+		if !ok {
+			f.notFound(w)
+			return
+		}
+		_, _ = w.Write(bz)
+
+	case "files/stat":
+		bz, ok := f.files[p]
+		switch {
+		case ok:
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"Hash": fmt.Sprintf("Qm%d", len(bz)), "Size": len(bz), "Type": "file"})
+		case f.isDir(p):
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"Hash": "QmDir", "Type": "directory"})
+		default:
+			f.notFound(w)
+		}
+
+	case "files/ls":
+		if !f.isDir(p) {
+			f.notFound(w)
+			return
+		}
+
+		type entry struct {
+			Name string
+			Type int
+			Size int
+		}
+
+		seen := make(map[string]bool)
+		var entries []entry
+		for name, bz := range f.files {
+			if !strings.HasPrefix(name, p+"/") {
+				continue
+			}
+
+			parts := strings.SplitN(strings.TrimPrefix(name, p+"/"), "/", 2)
+			if seen[parts[0]] {
+				continue
+			}
+			seen[parts[0]] = true
+
+			if len(parts) == 2 {
+				entries = append(entries, entry{Name: parts[0], Type: 1})
+			} else {
+				entries = append(entries, entry{Name: parts[0], Size: len(bz)})
+			}
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"Entries": entries})
+
+	case "files/rm":
+		if _, ok := f.files[p]; !ok {
+			f.notFound(w)
+			return
+		}
+		delete(f.files, p)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestIpfs(t *testing.T) {
+	fake := &fakeMFS{t: t, files: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	s := storage.NewIpfs(services.NewIpfs(strings.TrimPrefix(srv.URL, "http://")), "/bitsongms/")
+	testStorage(t, s)
+
+	_, ok := fake.files[path.Join("/bitsongms", "job/master.m3u8")]
+	require.True(t, ok)
+
+	cid, err := s.CID("job/master.m3u8")
+	require.NoError(t, err)
+	require.Equal(t, "Qm25", cid)
+}
@@ -0,0 +1,65 @@
+// Package storage stores the outputs of the transcoder, by key, on the local
+// filesystem, an S3-compatible object store or ipfs.
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Storage backends.
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+	BackendIpfs  = "ipfs"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// ObjectInfo describes a stored object. ModTime is zero when the backend
+// does not record it.
+type ObjectInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Storage stores objects by key, a slash separated relative path. Get and
+// Stat return ErrNotFound for a missing key, Delete does not.
+type Storage interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	Stat(key string) (ObjectInfo, error)
+	// List returns the objects whose key starts with prefix, sorted by key.
+	List(prefix string) ([]ObjectInfo, error)
+	Delete(key string) error
+}
+
+// PutFile stores the file at path under key.
+func PutFile(s Storage, key string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.Put(key, f)
+}
+
+// cleanKey returns the canonical form of key, rejecting the keys escaping
+// the storage root.
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if cleaned == "" || cleaned != strings.TrimPrefix(key, "/") {
+		return "", ErrInvalidKey
+	}
+
+	return cleaned, nil
+}
@@ -0,0 +1,122 @@
+package storage_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorage runs the behaviour shared by every backend.
+func testStorage(t *testing.T, s storage.Storage) {
+	_, err := s.Get("job/master.m3u8")
+	require.Equal(t, storage.ErrNotFound, err)
+
+	_, err = s.Stat("job/master.m3u8")
+	require.Equal(t, storage.ErrNotFound, err)
+
+	require.NoError(t, s.Put("job/master.m3u8", strings.NewReader("#EXTM3U\n")))
+	require.NoError(t, s.Put("job/128k/list.m3u8", bytes.NewReader([]byte("#EXTM3U\n#EXTINF:5,\n"))))
+	require.NoError(t, s.Put("other/master.m3u8", strings.NewReader("#EXTM3U\n")))
+
+	// a file is streamed from its current offset
+	dir, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	segment := filepath.Join(dir, "segment.ts")
+	require.NoError(t, ioutil.WriteFile(segment, []byte("segment data"), 0644))
+	require.NoError(t, storage.PutFile(s, "job/128k/segment0.ts", segment))
+
+	// overwritten
+	require.NoError(t, s.Put("job/master.m3u8", strings.NewReader("#EXTM3U\n#EXT-X-VERSION:7\n")))
+
+	r, err := s.Get("job/master.m3u8")
+	require.NoError(t, err)
+	bz, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "#EXTM3U\n#EXT-X-VERSION:7\n", string(bz))
+
+	info, err := s.Stat("job/128k/segment0.ts")
+	require.NoError(t, err)
+	require.Equal(t, "job/128k/segment0.ts", info.Key)
+	require.Equal(t, int64(len("segment data")), info.Size)
+
+	objects, err := s.List("job/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"job/128k/list.m3u8", "job/128k/segment0.ts", "job/master.m3u8"}, keys(objects))
+
+	objects, err = s.List("")
+	require.NoError(t, err)
+	require.Len(t, objects, 4)
+
+	require.NoError(t, s.Delete("job/128k/segment0.ts"))
+	require.NoError(t, s.Delete("job/128k/segment0.ts"))
+
+	_, err = s.Stat("job/128k/segment0.ts")
+	require.Equal(t, storage.ErrNotFound, err)
+
+	objects, err = s.List("job/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"job/128k/list.m3u8", "job/master.m3u8"}, keys(objects))
+
+	for _, key := range []string{"", "../escape", "job/../../escape", "job//master.m3u8"} {
+		require.Equal(t, storage.ErrInvalidKey, s.Put(key, strings.NewReader("x")), key)
+	}
+}
+
+func keys(objects []storage.ObjectInfo) []string {
+	keys := make([]string, len(objects))
+	for i, o := range objects {
+		keys[i] = o.Key
+	}
+
+	return keys
+}
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "root")
+	testStorage(t, storage.NewLocal(root))
+
+	// the directories left empty are removed
+	_, err = os.Stat(filepath.Join(root, "job", "128k", "segment0.ts"))
+	require.True(t, os.IsNotExist(err))
+
+	s := storage.NewLocal(root)
+
+	// the directory of the prefix is walked, partial names are matched
+	require.NoError(t, s.Put("job/list.m3u8", strings.NewReader("list")))
+	require.NoError(t, s.Put("jobs/list.m3u8", strings.NewReader("list")))
+
+	objects, err := s.List("job/li")
+	require.NoError(t, err)
+	require.Equal(t, []string{"job/list.m3u8"}, keys(objects))
+
+	objects, err = s.List("missing/")
+	require.NoError(t, err)
+	require.Empty(t, objects)
+
+	_, err = s.List("../job/")
+	require.Equal(t, storage.ErrInvalidKey, err)
+
+	require.NoError(t, s.Delete("job/list.m3u8"))
+	require.NoError(t, s.Delete("jobs/list.m3u8"))
+
+	require.NoError(t, s.Delete("other/master.m3u8"))
+
+	_, err = os.Stat(filepath.Join(root, "other"))
+	require.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(root)
+	require.NoError(t, err)
+}
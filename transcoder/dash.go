@@ -0,0 +1,183 @@
+package transcoder
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"path"
+)
+
+const (
+	dashManifestFileName = "manifest.mpd"
+
+	// dashTimescale is the sample rate of every rendition, segment times of
+	// the manifest are expressed in samples.
+	dashTimescale = 48000
+)
+
+// MPD is the root of a static MPEG-DASH manifest describing the fMP4
+// renditions with a SegmentList, which lets the publisher point every
+// segment to its own URL.
+type MPD struct {
+	XMLName                   xml.Name `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Profiles                  string   `xml:"profiles,attr"`
+	Type                      string   `xml:"type,attr"`
+	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string   `xml:"minBufferTime,attr"`
+	Periods                   []Period `xml:"Period"`
+}
+
+type Period struct {
+	ID             string          `xml:"id,attr"`
+	Start          string          `xml:"start,attr"`
+	AdaptationSets []AdaptationSet `xml:"AdaptationSet"`
+}
+
+type AdaptationSet struct {
+	ID               int              `xml:"id,attr"`
+	ContentType      string           `xml:"contentType,attr"`
+	MimeType         string           `xml:"mimeType,attr"`
+	Codecs           string           `xml:"codecs,attr"`
+	SegmentAlignment bool             `xml:"segmentAlignment,attr"`
+	Representations  []Representation `xml:"Representation"`
+}
+
+type Representation struct {
+	ID                string      `xml:"id,attr"`
+	Bandwidth         int         `xml:"bandwidth,attr"`
+	AudioSamplingRate int         `xml:"audioSamplingRate,attr"`
+	SegmentList       SegmentList `xml:"SegmentList"`
+}
+
+type SegmentList struct {
+	Timescale       int             `xml:"timescale,attr"`
+	Initialization  *URLType        `xml:"Initialization,omitempty"`
+	SegmentTimeline SegmentTimeline `xml:"SegmentTimeline"`
+	SegmentURLs     []SegmentURL    `xml:"SegmentURL"`
+}
+
+type URLType struct {
+	SourceURL string `xml:"sourceURL,attr"`
+}
+
+type SegmentTimeline struct {
+	S []S `xml:"S"`
+}
+
+// S is an entry of the segment timeline, R is the number of following
+// segments with the same duration D.
+type S struct {
+	T *int64 `xml:"t,attr,omitempty"`
+	D int64  `xml:"d,attr"`
+	R int    `xml:"r,attr,omitempty"`
+}
+
+type SegmentURL struct {
+	Media string `xml:"media,attr"`
+}
+
+// HasDash reports whether the profile output can be described by a DASH
+// manifest, which requires fMP4 segments.
+func (p Profile) HasDash() bool {
+	return p.SegmentType == SegmentTypeFMP4
+}
+
+// WriteDashManifest stores the DASH manifest referencing the segments of the
+// HLS media playlists, renditions sharing a codec are grouped in the same
+// adaptation set.
+func (a *Transcoder) WriteDashManifest() error {
+	if !a.Profile.HasDash() {
+		return fmt.Errorf("dash requires %s segments", SegmentTypeFMP4)
+	}
+
+	period := Period{
+		ID:    "0",
+		Start: "PT0S",
+	}
+
+	sets := make(map[string]int)
+
+	var duration float64
+	for _, r := range a.Profile.Ladder {
+		media, err := a.readMediaPlaylist(r)
+		if err != nil {
+			return err
+		}
+
+		if d := media.Duration(); d > duration {
+			duration = d
+		}
+
+		i, ok := sets[r.Codec]
+		if !ok {
+			i = len(period.AdaptationSets)
+			sets[r.Codec] = i
+
+			period.AdaptationSets = append(period.AdaptationSets, AdaptationSet{
+				ID:               i,
+				ContentType:      "audio",
+				MimeType:         "audio/mp4",
+				Codecs:           r.codecs(),
+				SegmentAlignment: true,
+			})
+		}
+
+		peak, _ := a.bandwidth(r)
+
+		period.AdaptationSets[i].Representations = append(period.AdaptationSets[i].Representations, Representation{
+			ID:                r.Name,
+			Bandwidth:         peak,
+			AudioSamplingRate: dashTimescale,
+			SegmentList:       newSegmentList(r.Name, media),
+		})
+	}
+
+	mpd := MPD{
+		Profiles:                  "urn:mpeg:dash:profile:isoff-main:2011",
+		Type:                      "static",
+		MediaPresentationDuration: fmt.Sprintf("PT%.3fS", duration),
+		MinBufferTime:             "PT5S",
+		Periods:                   []Period{period},
+	}
+
+	bz, err := xml.MarshalIndent(mpd, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return a.putOutput(dashManifestFileName, append([]byte(xml.Header), bz...))
+}
+
+// newSegmentList returns the segment list of the media playlist, URLs are
+// relative to the manifest, stored in the parent directory of dir.
+func newSegmentList(dir string, media *mediaPlaylist) SegmentList {
+	list := SegmentList{
+		Timescale: dashTimescale,
+	}
+
+	if media.Init != "" {
+		list.Initialization = &URLType{SourceURL: path.Join(dir, media.Init)}
+	}
+
+	var t int64
+	for i, segment := range media.Segments {
+		d := int64(math.Round(segment.Duration * dashTimescale))
+
+		timeline := list.SegmentTimeline.S
+		switch {
+		case i == 0:
+			start := t
+			list.SegmentTimeline.S = append(timeline, S{T: &start, D: d})
+		case timeline[len(timeline)-1].D == d:
+			timeline[len(timeline)-1].R++
+		default:
+			list.SegmentTimeline.S = append(timeline, S{D: d})
+		}
+
+		t += d
+
+		list.SegmentURLs = append(list.SegmentURLs, SegmentURL{Media: path.Join(dir, segment.URI)})
+	}
+
+	return list
+}
@@ -0,0 +1,93 @@
+package transcoder_test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestFMP4Rendition stores an fMP4 media playlist with segments of the
+// given durations, in seconds.
+func writeTestFMP4Rendition(t *testing.T, tr *transcoder.Transcoder, r transcoder.Rendition, durations ...float64) {
+	putTestOutput(t, tr, r.Name+"/init.mp4", "init")
+
+	playlist := "#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-TARGETDURATION:5\n#EXT-X-MAP:URI=\"init.mp4\"\n"
+	for i, d := range durations {
+		name := fmt.Sprintf("segment%03d.m4s", i)
+		playlist += fmt.Sprintf("#EXTINF:%f,\n%s\n", d, name)
+
+		putTestOutput(t, tr, r.Name+"/"+name, strings.Repeat("a", 1000))
+	}
+	playlist += "#EXT-X-ENDLIST\n"
+
+	putTestOutput(t, tr, r.Name+"/list.m3u8", playlist)
+}
+
+func TestWriteDashManifest(t *testing.T) {
+	chdirTemp(t)
+
+	tr := newTestTranscoder(t, "track.flac")
+	tr.Profile = transcoder.DefaultProfiles["cmaf"]
+
+	for _, r := range tr.Profile.Ladder {
+		writeTestFMP4Rendition(t, tr, r, 5, 5, 5, 2.5)
+	}
+
+	require.NoError(t, tr.WriteDashManifest())
+
+	var mpd transcoder.MPD
+	require.NoError(t, xml.Unmarshal(readTestOutput(t, tr, "manifest.mpd"), &mpd))
+
+	require.Equal(t, "urn:mpeg:dash:schema:mpd:2011", mpd.XMLName.Space)
+	require.Equal(t, "static", mpd.Type)
+	require.Equal(t, "PT17.500S", mpd.MediaPresentationDuration)
+	require.Len(t, mpd.Periods, 1)
+
+	// aac and opus renditions are in separate adaptation sets
+	sets := mpd.Periods[0].AdaptationSets
+	require.Len(t, sets, 2)
+	require.Equal(t, "mp4a.40.2", sets[0].Codecs)
+	require.Len(t, sets[0].Representations, 3)
+	require.Equal(t, "opus", sets[1].Codecs)
+	require.Len(t, sets[1].Representations, 1)
+
+	for _, set := range sets {
+		require.Equal(t, "audio/mp4", set.MimeType)
+
+		for _, rep := range set.Representations {
+			list := rep.SegmentList
+			require.Equal(t, 48000, list.Timescale)
+			require.Equal(t, rep.ID+"/init.mp4", list.Initialization.SourceURL)
+
+			// three segments of 5s followed by one of 2.5s
+			timeline := list.SegmentTimeline.S
+			require.Len(t, timeline, 2)
+			require.NotNil(t, timeline[0].T)
+			require.Equal(t, int64(0), *timeline[0].T)
+			require.Equal(t, int64(240000), timeline[0].D)
+			require.Equal(t, 2, timeline[0].R)
+			require.Nil(t, timeline[1].T)
+			require.Equal(t, int64(120000), timeline[1].D)
+			require.Equal(t, 0, timeline[1].R)
+
+			require.Len(t, list.SegmentURLs, 4)
+			for i, u := range list.SegmentURLs {
+				require.Equal(t, fmt.Sprintf("%s/segment%03d.m4s", rep.ID, i), u.Media)
+			}
+
+			// 1000 bytes in 2.5s
+			require.Equal(t, 3200, rep.Bandwidth)
+		}
+	}
+}
+
+func TestWriteDashManifestMPEGTS(t *testing.T) {
+	chdirTemp(t)
+
+	tr := newTestTranscoder(t, "track.mp3")
+	require.Error(t, tr.WriteDashManifest())
+}
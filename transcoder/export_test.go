@@ -0,0 +1,7 @@
+package transcoder
+
+// StoreRendition moves the files written by ffmpeg for the rendition to the
+// storage.
+func (a *Transcoder) StoreRendition(r Rendition) error {
+	return a.storeRendition(r)
+}
@@ -0,0 +1,101 @@
+package transcoder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+
+	"github.com/rs/zerolog/log"
+)
+
+// stderrTailSize is the maximum size of the ffmpeg stderr kept on failures.
+const stderrTailSize = 2048
+
+// FFmpegError is returned when an ffmpeg command fails, it carries the tail
+// of the ffmpeg stderr to help finding out what went wrong.
+type FFmpegError struct {
+	Err    error
+	Stderr string
+}
+
+func newFFmpegError(err error, stderr []byte) *FFmpegError {
+	return &FFmpegError{
+		Err:    err,
+		Stderr: tail(stderr, stderrTailSize),
+	}
+}
+
+func (e *FFmpegError) Error() string {
+	return fmt.Sprintf("ffmpeg failed: %s", e.Err)
+}
+
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// tail returns the last size bytes of b, starting from a line boundary.
+func tail(b []byte, size int) string {
+	b = bytes.TrimSpace(b)
+	if len(b) <= size {
+		return string(b)
+	}
+
+	b = b[len(b)-size:]
+	if i := bytes.IndexByte(b, '\n'); i >= 0 && i < len(b)-1 {
+		b = b[i+1:]
+	}
+
+	return string(b)
+}
+
+// runFFmpeg runs ffmpeg with the given arguments, reporting its progress to
+// the progress callback.
+func (a *Transcoder) runFFmpeg(progress func(percentage int), args ...string) error {
+	_, err := a.runFFmpegStderr(progress, args...)
+
+	return err
+}
+
+// runFFmpegStderr runs ffmpeg like runFFmpeg and returns its stderr, where
+// the filters print their reports.
+func (a *Transcoder) runFFmpegStderr(progress func(percentage int), args ...string) ([]byte, error) {
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	cmd := exec.Command("ffmpeg", args...)
+
+	var ffmpegStdErr bytes.Buffer
+	cmd.Stderr = &ffmpegStdErr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, newFFmpegError(err, nil)
+	}
+
+	if a.OnProgress != nil {
+		duration, err := a.GetDuration()
+		if err != nil {
+			log.Warn().Err(err).Msg("cannot get duration, progress will not be reported")
+		}
+
+		if err := ReadProgress(stdout, duration, progress); err != nil {
+			log.Warn().Err(err).Msg("cannot read ffmpeg progress")
+		}
+	}
+
+	// drain the progress output, ffmpeg blocks when the pipe is full
+	_, _ = io.Copy(ioutil.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		log.Print("FFMpeg error ", err)
+		log.Print(string(ffmpegStdErr.Bytes()))
+
+		return nil, newFFmpegError(err, ffmpegStdErr.Bytes())
+	}
+
+	return ffmpegStdErr.Bytes(), nil
+}
@@ -0,0 +1,186 @@
+package transcoder
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultMaxDuration is the longest accepted audio, in seconds.
+	DefaultMaxDuration = 610
+
+	maxLossySize    = 100 << 20
+	maxLosslessSize = 500 << 20
+)
+
+// AudioFormat describes an accepted upload format. MimeTypes and Extensions
+// are the values a file picker should accept, ProbeNames the ffprobe format
+// names and Codecs the ffprobe codec names the file must match. Lossless is
+// set for the formats which are always lossless, LosslessCodecs lists the
+// lossless codecs of the others.
+type AudioFormat struct {
+	Name           string   `json:"name"`
+	MimeTypes      []string `json:"mime_types"`
+	Extensions     []string `json:"extensions"`
+	ProbeNames     []string `json:"ffprobe_formats"`
+	Codecs         []string `json:"codecs"`
+	Lossless       bool     `json:"lossless"`
+	LosslessCodecs []string `json:"lossless_codecs,omitempty"`
+	// MaxDuration is the longest accepted audio, in seconds.
+	MaxDuration int `json:"max_duration"`
+	// MaxSize is the largest accepted file, in bytes.
+	MaxSize int64 `json:"max_size"`
+}
+
+// AudioFormats is the registry of the accepted audio formats, keyed by the
+// names returned by DetectAudioFormat.
+var AudioFormats = []AudioFormat{
+	{
+		Name:        AudioFormatMP3,
+		MimeTypes:   []string{"audio/mpeg", "audio/mp3"},
+		Extensions:  []string{".mp3"},
+		ProbeNames:  []string{"mp3"},
+		Codecs:      []string{"mp3"},
+		MaxDuration: DefaultMaxDuration,
+		MaxSize:     maxLossySize,
+	},
+	{
+		Name:        AudioFormatAAC,
+		MimeTypes:   []string{"audio/aac", "audio/x-aac"},
+		Extensions:  []string{".aac"},
+		ProbeNames:  []string{"aac"},
+		Codecs:      []string{"aac"},
+		MaxDuration: DefaultMaxDuration,
+		MaxSize:     maxLossySize,
+	},
+	{
+		Name:           AudioFormatM4A,
+		MimeTypes:      []string{"audio/mp4", "audio/x-m4a"},
+		Extensions:     []string{".m4a", ".mp4"},
+		ProbeNames:     []string{"mov", "mp4", "m4a"},
+		Codecs:         []string{"aac", "alac"},
+		LosslessCodecs: []string{"alac"},
+		MaxDuration:    DefaultMaxDuration,
+		MaxSize:        maxLosslessSize,
+	},
+	{
+		Name:           AudioFormatOGG,
+		MimeTypes:      []string{"audio/ogg", "audio/opus"},
+		Extensions:     []string{".ogg", ".oga", ".opus"},
+		ProbeNames:     []string{"ogg"},
+		Codecs:         []string{"vorbis", "opus", "flac"},
+		LosslessCodecs: []string{"flac"},
+		MaxDuration:    DefaultMaxDuration,
+		MaxSize:        maxLosslessSize,
+	},
+	{
+		Name:        AudioFormatFLAC,
+		MimeTypes:   []string{"audio/flac", "audio/x-flac"},
+		Extensions:  []string{".flac"},
+		ProbeNames:  []string{"flac"},
+		Codecs:      []string{"flac"},
+		Lossless:    true,
+		MaxDuration: DefaultMaxDuration,
+		MaxSize:     maxLosslessSize,
+	},
+	{
+		Name:        AudioFormatWAV,
+		MimeTypes:   []string{"audio/wav", "audio/x-wav", "audio/wave", "audio/vnd.wave"},
+		Extensions:  []string{".wav", ".wave"},
+		ProbeNames:  []string{"wav"},
+		Codecs:      []string{"pcm_s16le", "pcm_s24le", "pcm_s32le", "pcm_f32le", "pcm_f64le"},
+		Lossless:    true,
+		MaxDuration: DefaultMaxDuration,
+		MaxSize:     maxLosslessSize,
+	},
+	{
+		Name:        AudioFormatAIFF,
+		MimeTypes:   []string{"audio/aiff", "audio/x-aiff"},
+		Extensions:  []string{".aif", ".aiff", ".aifc"},
+		ProbeNames:  []string{"aiff"},
+		Codecs:      []string{"pcm_s16be", "pcm_s24be", "pcm_s32be", "pcm_f32be", "pcm_f64be"},
+		Lossless:    true,
+		MaxDuration: DefaultMaxDuration,
+		MaxSize:     maxLosslessSize,
+	},
+}
+
+// LookupAudioFormat returns the registered format with the given name.
+func LookupAudioFormat(name string) (AudioFormat, bool) {
+	for _, f := range AudioFormats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+
+	return AudioFormat{}, false
+}
+
+// MaxAudioSize returns the largest file accepted by any registered format.
+func MaxAudioSize() int64 {
+	var max int64
+	for _, f := range AudioFormats {
+		if f.MaxSize > max {
+			max = f.MaxSize
+		}
+	}
+
+	return max
+}
+
+// IsLossless reports whether the audio of the format coded with codec is
+// lossless.
+func (f AudioFormat) IsLossless(codec string) bool {
+	return f.Lossless || containsAny(f.LosslessCodecs, []string{codec})
+}
+
+// Accept checks the upload size and the ffprobe output against the rules of
+// the format. The files holding a video, e.g. an MP4 video sharing the
+// generic brands of the MPEG-4 audio files, are rejected, only the cover art
+// is accepted along the audio.
+func (f AudioFormat) Accept(size int64, probe FFProbeFormat, streams []FFProbeStream) error {
+	if f.MaxSize > 0 && size > f.MaxSize {
+		return fmt.Errorf("%s files must not exceed %d bytes", f.Name, f.MaxSize)
+	}
+
+	// ffprobe reports the names of the demuxer, e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+	if !containsAny(f.ProbeNames, strings.Split(probe.Format, ",")) {
+		return fmt.Errorf("file is not a valid %s file", f.Name)
+	}
+
+	var codec string
+	for _, s := range streams {
+		if s.CodecType == "audio" {
+			codec = s.CodecName
+			break
+		}
+	}
+
+	if !containsAny(f.Codecs, []string{codec}) {
+		return fmt.Errorf("unsupported %s codec: %s", f.Name, codec)
+	}
+
+	for _, s := range streams {
+		if s.CodecType == "video" && s.Disposition.AttachedPic == 0 {
+			return fmt.Errorf("%s files must not hold a video", f.Name)
+		}
+	}
+
+	if f.MaxDuration > 0 && probe.Duration > float32(f.MaxDuration) {
+		return fmt.Errorf("audio must not exceed %d seconds", f.MaxDuration)
+	}
+
+	return nil
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, v := range values {
+		for _, c := range candidates {
+			if v == c {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package transcoder_test
+
+import (
+	"testing"
+
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudioFormatsRegistry(t *testing.T) {
+	names := make(map[string]bool)
+	for _, f := range transcoder.AudioFormats {
+		require.False(t, names[f.Name], "duplicated format %s", f.Name)
+		names[f.Name] = true
+
+		require.NotEmpty(t, f.MimeTypes, f.Name)
+		require.NotEmpty(t, f.Extensions, f.Name)
+		require.NotEmpty(t, f.ProbeNames, f.Name)
+		require.NotEmpty(t, f.Codecs, f.Name)
+	}
+
+	// every format detected from the content is registered
+	for _, name := range []string{
+		transcoder.AudioFormatMP3, transcoder.AudioFormatAAC, transcoder.AudioFormatWAV, transcoder.AudioFormatAIFF,
+		transcoder.AudioFormatFLAC, transcoder.AudioFormatOGG, transcoder.AudioFormatM4A,
+	} {
+		_, ok := transcoder.LookupAudioFormat(name)
+		require.True(t, ok, name)
+	}
+
+	_, ok := transcoder.LookupAudioFormat("wma")
+	require.False(t, ok)
+}
+
+func TestAudioFormatAccept(t *testing.T) {
+	audio := func(codec string) []transcoder.FFProbeStream {
+		return []transcoder.FFProbeStream{{Index: 0, CodecType: "audio", CodecName: codec}}
+	}
+
+	tests := []struct {
+		name    string
+		format  string
+		size    int64
+		probe   transcoder.FFProbeFormat
+		streams []transcoder.FFProbeStream
+		err     string
+	}{
+		{"mp3", transcoder.AudioFormatMP3, 5 << 20, transcoder.FFProbeFormat{Format: "mp3", Duration: 200}, audio("mp3"), ""},
+		{"flac", transcoder.AudioFormatFLAC, 50 << 20, transcoder.FFProbeFormat{Format: "flac", Duration: 200}, audio("flac"), ""},
+		{"alac", transcoder.AudioFormatM4A, 50 << 20, transcoder.FFProbeFormat{Format: "mov,mp4,m4a,3gp,3g2,mj2", Duration: 200}, audio("alac"), ""},
+		{"opus", transcoder.AudioFormatOGG, 5 << 20, transcoder.FFProbeFormat{Format: "ogg", Duration: 200}, audio("opus"), ""},
+		{"24 bit aiff", transcoder.AudioFormatAIFF, 50 << 20, transcoder.FFProbeFormat{Format: "aiff", Duration: 200}, audio("pcm_s24be"), ""},
+		{"wav", transcoder.AudioFormatWAV, 50 << 20, transcoder.FFProbeFormat{Format: "wav", Duration: 200}, audio("pcm_s16le"), ""},
+		{"m4a with a cover", transcoder.AudioFormatM4A, 5 << 20, transcoder.FFProbeFormat{Format: "mov,mp4,m4a,3gp,3g2,mj2", Duration: 200}, []transcoder.FFProbeStream{{Index: 0, CodecType: "audio", CodecName: "aac"}, {Index: 1, CodecType: "video", CodecName: "mjpeg", Disposition: transcoder.FFProbeDisposition{AttachedPic: 1}}}, ""},
+
+		{"signature not matching the demuxer", transcoder.AudioFormatFLAC, 1 << 20, transcoder.FFProbeFormat{Format: "mp3", Duration: 200}, audio("mp3"), "file is not a valid flac file"},
+		{"mp4 video", transcoder.AudioFormatM4A, 1 << 20, transcoder.FFProbeFormat{Format: "mov,mp4,m4a,3gp,3g2,mj2", Duration: 200}, []transcoder.FFProbeStream{{Index: 0, CodecType: "video", CodecName: "h264"}}, "unsupported m4a codec: "},
+		{"mp4 video with audio", transcoder.AudioFormatM4A, 1 << 20, transcoder.FFProbeFormat{Format: "mov,mp4,m4a,3gp,3g2,mj2", Duration: 200}, []transcoder.FFProbeStream{{Index: 0, CodecType: "video", CodecName: "h264"}, {Index: 1, CodecType: "audio", CodecName: "aac"}}, "m4a files must not hold a video"},
+		{"adpcm wav", transcoder.AudioFormatWAV, 1 << 20, transcoder.FFProbeFormat{Format: "wav", Duration: 200}, audio("adpcm_ms"), "unsupported wav codec: adpcm_ms"},
+		{"too long", transcoder.AudioFormatMP3, 1 << 20, transcoder.FFProbeFormat{Format: "mp3", Duration: 611}, audio("mp3"), "audio must not exceed 610 seconds"},
+		{"too large", transcoder.AudioFormatMP3, 101 << 20, transcoder.FFProbeFormat{Format: "mp3", Duration: 200}, audio("mp3"), "mp3 files must not exceed 104857600 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, ok := transcoder.LookupAudioFormat(tt.format)
+			require.True(t, ok)
+
+			err := f.Accept(tt.size, tt.probe, tt.streams)
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAudioFormatIsLossless(t *testing.T) {
+	m4a, _ := transcoder.LookupAudioFormat(transcoder.AudioFormatM4A)
+	require.True(t, m4a.IsLossless("alac"))
+	require.False(t, m4a.IsLossless("aac"))
+
+	ogg, _ := transcoder.LookupAudioFormat(transcoder.AudioFormatOGG)
+	require.True(t, ogg.IsLossless("flac"))
+	require.False(t, ogg.IsLossless("opus"))
+
+	wav, _ := transcoder.LookupAudioFormat(transcoder.AudioFormatWAV)
+	require.True(t, wav.IsLossless("pcm_s16le"))
+}
@@ -0,0 +1,321 @@
+package transcoder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/storage"
+)
+
+const (
+	// DownloadFileName is the MP3 download of the job, tagged with ID3v2.4
+	// frames, stored next to the playlists.
+	DownloadFileName = "download.mp3"
+	// CoverFileName is the cover art of the job, embedded in the download.
+	CoverFileName = "cover"
+
+	id3HeaderSize = 10
+	// id3FlagFooter flags the footer following the frames of an ID3v2.4 tag.
+	id3FlagFooter = 0x10
+	// id3EncodingUTF8 is the text encoding of the written frames.
+	id3EncodingUTF8 = 3
+	// id3PictureFrontCover is the APIC picture type of the cover.
+	id3PictureFrontCover = 3
+	// id3MaxSize is the maximum size of a tag, its size is a 28 bit number.
+	id3MaxSize = 1<<28 - 1
+)
+
+// id3TextFrames are the text frames of the source tags reported by ffprobe,
+// by tag key. The other source tags are written as TXXX frames.
+var id3TextFrames = map[string]string{
+	"title":        "TIT2",
+	"artist":       "TPE1",
+	"album":        "TALB",
+	"album_artist": "TPE2",
+	"albumartist":  "TPE2",
+	"genre":        "TCON",
+	"date":         "TDRC",
+	"year":         "TDRC",
+	"track":        "TRCK",
+	"tracknumber":  "TRCK",
+	"disc":         "TPOS",
+	"discnumber":   "TPOS",
+	"composer":     "TCOM",
+	"copyright":    "TCOP",
+	"publisher":    "TPUB",
+	"isrc":         "TSRC",
+	"tsrc":         "TSRC",
+}
+
+// id3IgnoredTags are the source tags describing the encoding of the source,
+// which do not apply to the download.
+var id3IgnoredTags = map[string]bool{
+	"encoder":    true,
+	"encoded_by": true,
+}
+
+// ID3Picture is an attached picture of an ID3 tag.
+type ID3Picture struct {
+	MIME string
+	Data []byte
+}
+
+// ID3Tags are the frames of an ID3v2.4 tag. Text holds the text frames by
+// frame id, UserText the TXXX frames by description.
+type ID3Tags struct {
+	Text     map[string]string
+	UserText map[string]string
+	Cover    *ID3Picture
+}
+
+// NewID3Tags returns the tags of the download: the source tags, unless
+// dropped, overridden by the track tags. The cover is not set.
+func NewID3Tags(source *models.AudioMetadata, tags *models.TrackTags) ID3Tags {
+	t := ID3Tags{
+		Text:     make(map[string]string),
+		UserText: make(map[string]string),
+	}
+
+	if source != nil && (tags == nil || !tags.DropSourceTags) {
+		// sorted, the first of the keys of a frame wins
+		keys := make([]string, 0, len(source.Tags))
+		for k := range source.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			v := source.Tags[k]
+			if v == "" || id3IgnoredTags[k] {
+				continue
+			}
+
+			frame, ok := id3TextFrames[k]
+			if !ok {
+				t.UserText[k] = v
+				continue
+			}
+
+			if _, ok := t.Text[frame]; !ok {
+				t.Text[frame] = v
+			}
+		}
+	}
+
+	if tags != nil {
+		for frame, v := range map[string]string{
+			"TIT2": tags.Title,
+			"TPE1": tags.Artist,
+			"TALB": tags.Album,
+			"TSRC": tags.ISRC,
+			"TDRC": tags.Year,
+		} {
+			if v != "" {
+				t.Text[frame] = v
+			}
+		}
+	}
+
+	return t
+}
+
+// WriteID3 writes the ID3v2.4 tag of the frames, sorted by id.
+func WriteID3(w io.Writer, tags ID3Tags) error {
+	var frames bytes.Buffer
+
+	ids := make([]string, 0, len(tags.Text))
+	for id := range tags.Text {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		writeID3Frame(&frames, id, append([]byte{id3EncodingUTF8}, tags.Text[id]...))
+	}
+
+	descriptions := make([]string, 0, len(tags.UserText))
+	for d := range tags.UserText {
+		descriptions = append(descriptions, d)
+	}
+	sort.Strings(descriptions)
+
+	for _, d := range descriptions {
+		data := []byte{id3EncodingUTF8}
+		data = append(data, d...)
+		data = append(data, 0)
+		data = append(data, tags.UserText[d]...)
+
+		writeID3Frame(&frames, "TXXX", data)
+	}
+
+	if tags.Cover != nil {
+		data := []byte{id3EncodingUTF8}
+		data = append(data, tags.Cover.MIME...)
+		// empty description
+		data = append(data, 0, id3PictureFrontCover, 0)
+		data = append(data, tags.Cover.Data...)
+
+		writeID3Frame(&frames, "APIC", data)
+	}
+
+	if frames.Len() > id3MaxSize {
+		return fmt.Errorf("id3 tag must not exceed %d bytes", id3MaxSize)
+	}
+
+	header := []byte{'I', 'D', '3', 4, 0, 0}
+	header = append(header, syncsafe(frames.Len())...)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	_, err := frames.WriteTo(w)
+	return err
+}
+
+func writeID3Frame(w *bytes.Buffer, id string, data []byte) {
+	w.WriteString(id)
+	w.Write(syncsafe(len(data)))
+	// no flags
+	w.Write([]byte{0, 0})
+	w.Write(data)
+}
+
+// syncsafe encodes n on 4 bytes of 7 bits, as the ID3v2.4 sizes.
+func syncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// StripID3 returns the MP3 of mp3 without its leading ID3v2 tags.
+func StripID3(mp3 []byte) []byte {
+	for len(mp3) >= id3HeaderSize && bytes.HasPrefix(mp3, []byte("ID3")) {
+		size := int(mp3[6])<<21 | int(mp3[7])<<14 | int(mp3[8])<<7 | int(mp3[9])
+		size += id3HeaderSize
+
+		if mp3[5]&id3FlagFooter != 0 {
+			size += id3HeaderSize
+		}
+
+		if size > len(mp3) {
+			// truncated, kept as is
+			return mp3
+		}
+
+		mp3 = mp3[size:]
+	}
+
+	return mp3
+}
+
+// TagMP3 writes the MP3 of src, stripped from its tags, to w after the new
+// tags.
+func TagMP3(w io.Writer, src []byte, tags ID3Tags) error {
+	if err := WriteID3(w, tags); err != nil {
+		return err
+	}
+
+	_, err := w.Write(StripID3(src))
+	return err
+}
+
+// LoadID3Tags returns the tags of the download of the job, with the cover
+// stored by the upload or the tags update.
+func LoadID3Tags(s storage.Storage, id string, source *models.AudioMetadata, tags *models.TrackTags) (ID3Tags, error) {
+	t := NewID3Tags(source, tags)
+
+	if tags == nil || tags.CoverMIME == "" {
+		return t, nil
+	}
+
+	r, err := s.Get(id + "/" + CoverFileName)
+	if err != nil {
+		return ID3Tags{}, fmt.Errorf("cannot get cover: %w", err)
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return ID3Tags{}, fmt.Errorf("cannot read cover: %w", err)
+	}
+
+	t.Cover = &ID3Picture{
+		MIME: tags.CoverMIME,
+		Data: data,
+	}
+
+	return t, nil
+}
+
+// RetagDownload rewrites the tags of the stored download of the job. It
+// returns storage.ErrNotFound when the job has no download.
+func RetagDownload(s storage.Storage, id string, source *models.AudioMetadata, tags *models.TrackTags) error {
+	key := id + "/" + DownloadFileName
+
+	r, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+
+	mp3, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	t, err := LoadID3Tags(s, id, source, tags)
+	if err != nil {
+		return err
+	}
+
+	var tagged bytes.Buffer
+	if err := TagMP3(&tagged, mp3, t); err != nil {
+		return err
+	}
+
+	return s.Put(key, &tagged)
+}
+
+// WriteDownload stores the MP3 download of the converted file, tagged with
+// the source tags, unless dropped, and the track tags of the job.
+func (a *Transcoder) WriteDownload(source *models.AudioMetadata, tags *models.TrackTags) error {
+	t, err := LoadID3Tags(a.Storage, a.Id.Hex(), source, tags)
+	if err != nil {
+		return err
+	}
+
+	// the audio stream alone, the tags are written below
+	raw := a.Uploader.GetDir() + "download.raw.mp3"
+	defer os.Remove(raw)
+
+	err = a.runFFmpeg(
+		func(int) {},
+		"-i", a.Uploader.GetTmpConvertedFileName(),
+		"-map", "0:a:0",
+		"-c:a", "copy",
+		"-map_metadata", "-1",
+		"-id3v2_version", "0",
+		"-f", "mp3",
+		"-y",
+		raw,
+	)
+	if err != nil {
+		return err
+	}
+
+	mp3, err := ioutil.ReadFile(raw)
+	if err != nil {
+		return err
+	}
+
+	var tagged bytes.Buffer
+	if err := TagMP3(&tagged, mp3, t); err != nil {
+		return err
+	}
+
+	return a.putOutput(DownloadFileName, tagged.Bytes())
+}
@@ -0,0 +1,110 @@
+package transcoder_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/stretchr/testify/require"
+)
+
+// mp3Frame stands for the audio of an MP3, an MPEG frame header.
+var mp3Frame = []byte{0xff, 0xfb, 0x90, 0x64, 0x00}
+
+func TestWriteID3(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, transcoder.WriteID3(&buf, transcoder.ID3Tags{
+		Text:     map[string]string{"TPE1": "Bitsong", "TIT2": "Dawn"},
+		UserText: map[string]string{"mood": "calm"},
+		Cover:    &transcoder.ID3Picture{MIME: "image/png", Data: []byte("PNG")},
+	}))
+
+	expected := []byte("ID3\x04\x00\x00\x00\x00\x00\x4f")
+	expected = append(expected, "TIT2\x00\x00\x00\x05\x00\x00\x03Dawn"...)
+	expected = append(expected, "TPE1\x00\x00\x00\x08\x00\x00\x03Bitsong"...)
+	expected = append(expected, "TXXX\x00\x00\x00\x0a\x00\x00\x03mood\x00calm"...)
+	expected = append(expected, "APIC\x00\x00\x00\x10\x00\x00\x03image/png\x00\x03\x00PNG"...)
+
+	require.Equal(t, expected, buf.Bytes())
+}
+
+func TestStripID3(t *testing.T) {
+	var tagged bytes.Buffer
+	require.NoError(t, transcoder.TagMP3(&tagged, mp3Frame, transcoder.ID3Tags{Text: map[string]string{"TIT2": "Dawn"}}))
+
+	// a tag with a footer, followed by the tag written above
+	footer := []byte("ID3\x04\x00\x10\x00\x00\x00\x00" + "3DI\x04\x00\x10\x00\x00\x00\x00")
+	mp3 := append(footer, tagged.Bytes()...)
+
+	require.Equal(t, mp3Frame, transcoder.StripID3(mp3))
+	require.Equal(t, mp3Frame, transcoder.StripID3(mp3Frame))
+
+	truncated := []byte("ID3\x04\x00\x00\x00\x00\x01\x00")
+	require.Equal(t, truncated, transcoder.StripID3(truncated))
+}
+
+func TestNewID3Tags(t *testing.T) {
+	source := &models.AudioMetadata{
+		Tags: map[string]string{
+			"title":       "Untitled",
+			"artist":      "Bitsong",
+			"tracknumber": "3",
+			"encoder":     "Lavf58.29.100",
+			"mood":        "calm",
+		},
+	}
+
+	tags := transcoder.NewID3Tags(source, &models.TrackTags{Title: "Dawn", ISRC: "USRC17607839"})
+	require.Equal(t, map[string]string{
+		"TIT2": "Dawn",
+		"TPE1": "Bitsong",
+		"TRCK": "3",
+		"TSRC": "USRC17607839",
+	}, tags.Text)
+	require.Equal(t, map[string]string{"mood": "calm"}, tags.UserText)
+
+	tags = transcoder.NewID3Tags(source, &models.TrackTags{Title: "Dawn", DropSourceTags: true})
+	require.Equal(t, map[string]string{"TIT2": "Dawn"}, tags.Text)
+	require.Empty(t, tags.UserText)
+
+	tags = transcoder.NewID3Tags(nil, nil)
+	require.Empty(t, tags.Text)
+}
+
+func TestRetagDownload(t *testing.T) {
+	root, err := ioutil.TempDir("", "id3")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	store := storage.NewLocal(root)
+	id := "5f5e1000a1b2c3d4e5f60718"
+
+	err = transcoder.RetagDownload(store, id, nil, &models.TrackTags{Title: "Dawn"})
+	require.Equal(t, storage.ErrNotFound, err)
+
+	var tagged bytes.Buffer
+	require.NoError(t, transcoder.TagMP3(&tagged, mp3Frame, transcoder.ID3Tags{Text: map[string]string{"TIT2": "Untitled"}}))
+	require.NoError(t, store.Put(id+"/"+transcoder.DownloadFileName, &tagged))
+	require.NoError(t, store.Put(id+"/"+transcoder.CoverFileName, bytes.NewReader([]byte("JPEG"))))
+
+	tags := &models.TrackTags{Title: "Dawn", CoverMIME: "image/jpeg"}
+	require.NoError(t, transcoder.RetagDownload(store, id, nil, tags))
+
+	var expected bytes.Buffer
+	require.NoError(t, transcoder.TagMP3(&expected, mp3Frame, transcoder.ID3Tags{
+		Text:  map[string]string{"TIT2": "Dawn"},
+		Cover: &transcoder.ID3Picture{MIME: "image/jpeg", Data: []byte("JPEG")},
+	}))
+
+	r, err := store.Get(id + "/" + transcoder.DownloadFileName)
+	require.NoError(t, err)
+	defer r.Close()
+
+	bz, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, expected.Bytes(), bz)
+}
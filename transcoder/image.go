@@ -0,0 +1,212 @@
+package transcoder
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"strconv"
+
+	// register the decoders accepted by the image pipeline
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/services"
+	"github.com/angelorc/go-uploader/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	ImageFormatJPEG = "jpeg"
+	ImageFormatPNG  = "png"
+	ImageFormatGIF  = "gif"
+	ImageFormatWEBP = "webp"
+
+	imageQuality = 90
+	// maxImagePixels is the maximum width times height of an uploaded image,
+	// decoding allocates 4 bytes per pixel.
+	maxImagePixels = 25 * 1000 * 1000
+)
+
+// ImageSizes are the square cover-art sizes generated for every uploaded image.
+var ImageSizes = []int{1000, 500, 250, 64}
+
+// DetectImageFormat returns the image format matching the magic bytes at the
+// beginning of header, or an empty string when the format is not supported.
+func DetectImageFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return ImageFormatJPEG
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return ImageFormatPNG
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return ImageFormatGIF
+	case len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return ImageFormatWEBP
+	}
+
+	return ""
+}
+
+type Image struct {
+	Uploader *services.Uploader
+	Id       primitive.ObjectID
+	Format   string
+	// Storage receives the variants, the working directory of the upload
+	// only holds the original.
+	Storage storage.Storage
+}
+
+func NewImage(u *services.Uploader, id primitive.ObjectID, s storage.Storage) *Image {
+	return &Image{
+		Uploader: u,
+		Id:       id,
+		Storage:  s,
+	}
+}
+
+// VariantFileName returns the file name of the variant of an image at size.
+func VariantFileName(size int) string {
+	return "cover_" + strconv.Itoa(size) + ".jpg"
+}
+
+// GetVariantKey returns the key of the variant at size in the storage, below
+// the id of the image.
+func (i *Image) GetVariantKey(size int) string {
+	return i.Id.Hex() + "/" + VariantFileName(size)
+}
+
+// CreateVariants decodes the original upload, crops it to a centered square and
+// stores a JPEG rendition for every size in ImageSizes which does not exceed
+// the source. Re-encoding drops any EXIF data, the original file is removed
+// once every variant has been stored.
+func (i *Image) CreateVariants() ([]models.ImageVariant, error) {
+	f, err := os.Open(i.Uploader.GetTmpOriginalFileName())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, fmt.Errorf("unsupported image format")
+	}
+
+	i.Format = DetectImageFormat(header)
+	if i.Format == "" {
+		return nil, fmt.Errorf("unsupported image format")
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// the dimensions are checked before decoding, a small file can declare
+	// dimensions large enough to exhaust the memory
+	config, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode image: %w", err)
+	}
+
+	if format != i.Format {
+		return nil, fmt.Errorf("image content does not match its signature")
+	}
+
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, fmt.Errorf("image is too large, maximum size is %d pixels", maxImagePixels)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode image: %w", err)
+	}
+
+	crop := squareCrop(src.Bounds())
+
+	var variants []models.ImageVariant
+	for _, size := range ImageSizes {
+		if size > crop.Dx() {
+			continue
+		}
+
+		variant, err := i.writeVariant(src, crop, size)
+		if err != nil {
+			_ = i.DeleteVariants()
+			return nil, err
+		}
+
+		variants = append(variants, variant)
+	}
+
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("image is too small, minimum size is %dx%d", ImageSizes[len(ImageSizes)-1], ImageSizes[len(ImageSizes)-1])
+	}
+
+	f.Close()
+	if err := os.Remove(i.Uploader.GetTmpOriginalFileName()); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+func (i *Image) writeVariant(src image.Image, crop image.Rectangle, size int) (models.ImageVariant, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	// flatten transparent images on a white background
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: imageQuality}); err != nil {
+		return models.ImageVariant{}, err
+	}
+
+	n := int64(buf.Len())
+	if err := i.Storage.Put(i.GetVariantKey(size), &buf); err != nil {
+		return models.ImageVariant{}, err
+	}
+
+	return models.ImageVariant{
+		Size:     size,
+		Width:    size,
+		Height:   size,
+		Bytes:    n,
+		FileName: VariantFileName(size),
+	}, nil
+}
+
+// DeleteVariants removes the stored variants of the image, such as the ones
+// of an image which could not be saved.
+func (i *Image) DeleteVariants() error {
+	for _, size := range ImageSizes {
+		if err := i.Storage.Delete(i.GetVariantKey(size)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// squareCrop returns the largest square centered in bounds.
+func squareCrop(bounds image.Rectangle) image.Rectangle {
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	return image.Rect(x, y, x+side, y+side)
+}
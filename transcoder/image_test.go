@@ -0,0 +1,197 @@
+package transcoder_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	return img
+}
+
+// withExif inserts an APP1 Exif segment right after the JPEG SOI marker.
+func withExif(jpg []byte) []byte {
+	payload := append([]byte("Exif\x00\x00"), []byte("GPS secret location")...)
+	length := len(payload) + 2
+
+	segment := append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, payload...)
+
+	return append(append(append([]byte{}, jpg[:2]...), segment...), jpg[2:]...)
+}
+
+// readVariant returns the stored variant of img at size.
+func readVariant(t *testing.T, img *transcoder.Image, size int) []byte {
+	r, err := img.Storage.Get(img.GetVariantKey(size))
+	require.NoError(t, err)
+	defer r.Close()
+
+	bz, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	return bz
+}
+
+func TestDetectImageFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		format string
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0, 0, 0, 0, 0, 0, 0}, transcoder.ImageFormatJPEG},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0d"), transcoder.ImageFormatPNG},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00"), transcoder.ImageFormatGIF},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBP"), transcoder.ImageFormatWEBP},
+		{"wav", []byte("RIFF\x24\x00\x00\x00WAVE"), ""},
+		{"text", []byte("hello world!"), ""},
+		{"empty", []byte{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.format, transcoder.DetectImageFormat(tt.header))
+		})
+	}
+}
+
+func TestCreateVariants(t *testing.T) {
+	chdirTemp(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(1200, 800), nil))
+
+	u := newTestUploader(t, "cover.jpg")
+	require.NoError(t, ioutil.WriteFile(u.GetTmpOriginalFileName(), withExif(buf.Bytes()), 0644))
+
+	img := transcoder.NewImage(u, primitive.NewObjectID(), storage.NewLocal("storage"))
+
+	variants, err := img.CreateVariants()
+	require.NoError(t, err)
+	require.Equal(t, transcoder.ImageFormatJPEG, img.Format)
+
+	// 1000 is larger than the 800px square crop and is skipped
+	require.Len(t, variants, 3)
+
+	for i, size := range []int{500, 250, 64} {
+		v := variants[i]
+		require.Equal(t, size, v.Size)
+
+		require.Equal(t, transcoder.VariantFileName(size), v.FileName)
+
+		bz := readVariant(t, img, size)
+		require.EqualValues(t, len(bz), v.Bytes)
+		require.NotContains(t, string(bz), "Exif")
+
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(bz))
+		require.NoError(t, err)
+		require.Equal(t, size, cfg.Width)
+		require.Equal(t, size, cfg.Height)
+	}
+
+	_, err = os.Stat(u.GetTmpOriginalFileName())
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, img.DeleteVariants())
+
+	_, err = img.Storage.Stat(img.GetVariantKey(500))
+	require.Equal(t, storage.ErrNotFound, err)
+}
+
+func TestCreateVariantsTransparentPng(t *testing.T) {
+	chdirTemp(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 300, 300))))
+
+	u := newTestUploader(t, "cover.png")
+	require.NoError(t, ioutil.WriteFile(u.GetTmpOriginalFileName(), buf.Bytes(), 0644))
+
+	img := transcoder.NewImage(u, primitive.NewObjectID(), storage.NewLocal("storage"))
+
+	variants, err := img.CreateVariants()
+	require.NoError(t, err)
+	require.Len(t, variants, 2)
+
+	decoded, err := jpeg.Decode(bytes.NewReader(readVariant(t, img, 250)))
+	require.NoError(t, err)
+
+	r, g, b, _ := decoded.At(125, 125).RGBA()
+	require.True(t, r > 0xF000 && g > 0xF000 && b > 0xF000, "transparent pixels must be flattened on white")
+}
+
+func TestCreateVariantsRejectsSpoofedImage(t *testing.T) {
+	chdirTemp(t)
+
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+	}{
+		{"text as png", "cover.png", []byte("this is definitely not an image")},
+		{"truncated png", "cover.png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")},
+		{"too small", "cover.jpg", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := tt.content
+			if content == nil {
+				var buf bytes.Buffer
+				require.NoError(t, jpeg.Encode(&buf, testImage(32, 32), nil))
+				content = buf.Bytes()
+			}
+
+			u := newTestUploader(t, tt.filename)
+			require.NoError(t, ioutil.WriteFile(u.GetTmpOriginalFileName(), content, 0644))
+
+			_, err := transcoder.NewImage(u, primitive.NewObjectID(), storage.NewLocal("storage")).CreateVariants()
+			require.Error(t, err)
+		})
+	}
+}
+
+// withDimensions rewrites the dimensions declared by the IHDR chunk of a PNG.
+func withDimensions(pngData []byte, width, height uint32) []byte {
+	out := append([]byte{}, pngData...)
+
+	// the IHDR data follows the signature, the chunk length and its type
+	ihdr := out[16:29]
+	binary.BigEndian.PutUint32(ihdr[0:4], width)
+	binary.BigEndian.PutUint32(ihdr[4:8], height)
+	binary.BigEndian.PutUint32(out[29:33], crc32.ChecksumIEEE(out[12:29]))
+
+	return out
+}
+
+func TestCreateVariantsRejectsDecompressionBomb(t *testing.T) {
+	chdirTemp(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, testImage(1, 1)))
+
+	u := newTestUploader(t, "cover.png")
+	require.NoError(t, ioutil.WriteFile(u.GetTmpOriginalFileName(), withDimensions(buf.Bytes(), 100000, 100000), 0644))
+
+	_, err := transcoder.NewImage(u, primitive.NewObjectID(), storage.NewLocal("storage")).CreateVariants()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "image is too large")
+}
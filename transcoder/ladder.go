@@ -0,0 +1,144 @@
+package transcoder
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CodecAAC  = "aac"
+	CodecMP3  = "mp3"
+	CodecOpus = "opus"
+)
+
+// Rendition is a step of the adaptive HLS ladder.
+type Rendition struct {
+	// Name is the directory holding the rendition segments.
+	Name string `yaml:"name"`
+	// Bitrate is the audio bitrate in kbit/s.
+	Bitrate int    `yaml:"bitrate"`
+	Codec   string `yaml:"codec"`
+}
+
+// DefaultLadder is the HLS ladder used when none is configured, from the
+// highest to the lowest quality.
+var DefaultLadder = []Rendition{
+	{Name: "320k", Bitrate: 320, Codec: CodecAAC},
+	{Name: "192k", Bitrate: 192, Codec: CodecAAC},
+	{Name: "128k", Bitrate: 128, Codec: CodecAAC},
+	{Name: "64k", Bitrate: 64, Codec: CodecAAC},
+}
+
+// ValidateLadder checks that the ladder can be transcoded.
+func ValidateLadder(ladder []Rendition) error {
+	if len(ladder) == 0 {
+		return fmt.Errorf("ladder must have at least one rendition")
+	}
+
+	names := make(map[string]bool)
+	for _, r := range ladder {
+		if r.Name == "" || r.Name != filepath.Base(r.Name) || strings.HasPrefix(r.Name, ".") {
+			return fmt.Errorf("invalid rendition name %q", r.Name)
+		}
+
+		if names[r.Name] {
+			return fmt.Errorf("duplicated rendition name %q", r.Name)
+		}
+		names[r.Name] = true
+
+		if r.Bitrate <= 0 {
+			return fmt.Errorf("rendition %s: bitrate must be positive", r.Name)
+		}
+
+		if r.Codec != CodecAAC && r.Codec != CodecMP3 && r.Codec != CodecOpus {
+			return fmt.Errorf("rendition %s: unsupported codec %q", r.Name, r.Codec)
+		}
+	}
+
+	return nil
+}
+
+// encoder returns the ffmpeg encoder of the rendition codec.
+func (r Rendition) encoder() string {
+	switch r.Codec {
+	case CodecMP3:
+		return "libmp3lame"
+	case CodecOpus:
+		return "libopus"
+	}
+
+	return "aac"
+}
+
+// codecs returns the RFC 6381 codec of the rendition, as expected by the
+// CODECS attribute of the master playlist.
+func (r Rendition) codecs() string {
+	switch r.Codec {
+	case CodecMP3:
+		return "mp4a.40.34"
+	case CodecOpus:
+		return "opus"
+	}
+
+	// AAC-LC
+	return "mp4a.40.2"
+}
+
+// bandwidth measures the peak and average bitrate, in bit/s, of the stored
+// segments listed in the media playlist of the rendition. The nominal
+// bitrate is returned when the segments cannot be measured.
+func (a *Transcoder) bandwidth(r Rendition) (peak int, average int) {
+	nominal := r.Bitrate * 1000
+
+	media, err := a.readMediaPlaylist(r)
+	if err != nil {
+		return nominal, nominal
+	}
+
+	var (
+		totalBits     float64
+		totalDuration float64
+	)
+
+	for _, segment := range media.Segments {
+		info, err := a.Storage.Stat(a.GetStorageKey(path.Join(r.Name, segment.URI)))
+		if err != nil || segment.Duration <= 0 {
+			continue
+		}
+
+		bits := float64(info.Size * 8)
+		if rate := int(bits / segment.Duration); rate > peak {
+			peak = rate
+		}
+
+		totalBits += bits
+		totalDuration += segment.Duration
+	}
+
+	if peak == 0 || totalDuration == 0 {
+		return nominal, nominal
+	}
+
+	return peak, int(totalBits / totalDuration)
+}
+
+// WriteMasterPlaylist stores the master playlist referencing the media
+// playlist of every rendition of the ladder.
+func (a *Transcoder) WriteMasterPlaylist() error {
+	var master bytes.Buffer
+
+	master.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&master, "#EXT-X-VERSION:%d\n", a.Profile.playlistVersion())
+
+	for _, r := range a.Profile.Ladder {
+		peak, average := a.bandwidth(r)
+
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"%s\"\n", peak, average, r.codecs())
+		master.WriteString(r.Name + "/" + playlistFileName + "\n")
+	}
+
+	return a.putOutput(masterPlaylistFileName, master.Bytes())
+}
@@ -0,0 +1,98 @@
+package transcoder_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLadder(t *testing.T) {
+	tests := []struct {
+		name   string
+		ladder []transcoder.Rendition
+		valid  bool
+	}{
+		{"default", transcoder.DefaultLadder, true},
+		{"mp3", []transcoder.Rendition{{Name: "128k", Bitrate: 128, Codec: transcoder.CodecMP3}}, true},
+		{"empty", nil, false},
+		{"no name", []transcoder.Rendition{{Bitrate: 128, Codec: transcoder.CodecAAC}}, false},
+		{"path name", []transcoder.Rendition{{Name: "../128k", Bitrate: 128, Codec: transcoder.CodecAAC}}, false},
+		{"duplicated name", []transcoder.Rendition{{Name: "a", Bitrate: 128, Codec: transcoder.CodecAAC}, {Name: "a", Bitrate: 64, Codec: transcoder.CodecAAC}}, false},
+		{"no bitrate", []transcoder.Rendition{{Name: "a", Codec: transcoder.CodecAAC}}, false},
+		{"unknown codec", []transcoder.Rendition{{Name: "a", Bitrate: 128, Codec: "vorbis"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := transcoder.ValidateLadder(tt.ladder)
+			if tt.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestWriteMasterPlaylist(t *testing.T) {
+	chdirTemp(t)
+
+	tr := newTestTranscoder(t, "track.wav")
+	tr.Profile.Ladder = []transcoder.Rendition{
+		{Name: "320k", Bitrate: 320, Codec: transcoder.CodecAAC},
+		{Name: "128k", Bitrate: 128, Codec: transcoder.CodecMP3},
+	}
+
+	// 5s segments of 250000 and 125000 bytes, 400 and 200 kbit/s
+	writeTestRendition(t, tr, tr.Profile.Ladder[0], strings.Repeat("a", 250000), strings.Repeat("a", 125000))
+
+	require.NoError(t, tr.WriteMasterPlaylist())
+
+	bz := readTestOutput(t, tr, "master.m3u8")
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=400000,AVERAGE-BANDWIDTH=300000,CODECS=\"mp4a.40.2\"\n" +
+		"320k/list.m3u8\n" +
+		// not transcoded, the nominal bitrate is used
+		"#EXT-X-STREAM-INF:BANDWIDTH=128000,AVERAGE-BANDWIDTH=128000,CODECS=\"mp4a.40.34\"\n" +
+		"128k/list.m3u8\n"
+	require.Equal(t, expected, string(bz))
+}
+
+func TestValidateProfile(t *testing.T) {
+	for name, p := range transcoder.DefaultProfiles {
+		require.NoError(t, transcoder.ValidateProfile(p), name)
+	}
+
+	opus := []transcoder.Rendition{{Name: "opus", Bitrate: 128, Codec: transcoder.CodecOpus}}
+	mp3 := []transcoder.Rendition{{Name: "mp3", Bitrate: 128, Codec: transcoder.CodecMP3}}
+
+	require.Error(t, transcoder.ValidateProfile(transcoder.Profile{SegmentType: transcoder.SegmentTypeMPEGTS, Ladder: opus}))
+	require.Error(t, transcoder.ValidateProfile(transcoder.Profile{SegmentType: transcoder.SegmentTypeFMP4, Ladder: mp3}))
+	require.Error(t, transcoder.ValidateProfile(transcoder.Profile{SegmentType: "webm", Ladder: transcoder.DefaultLadder}))
+}
+
+func TestWriteMasterPlaylistFMP4(t *testing.T) {
+	chdirTemp(t)
+
+	tr := newTestTranscoder(t, "track.wav")
+	tr.Profile = transcoder.Profile{
+		SegmentType: transcoder.SegmentTypeFMP4,
+		Ladder: []transcoder.Rendition{
+			{Name: "opus", Bitrate: 96, Codec: transcoder.CodecOpus},
+		},
+	}
+
+	require.NoError(t, tr.WriteMasterPlaylist())
+
+	bz := readTestOutput(t, tr, "master.m3u8")
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:6\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=96000,AVERAGE-BANDWIDTH=96000,CODECS=\"opus\"\n" +
+		"opus/list.m3u8\n"
+	require.Equal(t, expected, string(bz))
+}
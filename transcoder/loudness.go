@@ -0,0 +1,258 @@
+package transcoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/angelorc/go-uploader/models"
+)
+
+const (
+	// DefaultTargetLUFS is the EBU R128 integrated loudness, used by the
+	// measurement of the profiles which are not normalized.
+	DefaultTargetLUFS = -23
+	// DefaultTruePeak is the maximum true peak of the normalized outputs, in
+	// dBTP.
+	DefaultTruePeak = -1
+	// DefaultLRA is the loudness range of the normalized outputs, in LU,
+	// wide enough to keep the dynamics of most music.
+	DefaultLRA = 20
+
+	// replayGainReference is the loudness ReplayGain 2.0 players normalize
+	// to, in LUFS.
+	replayGainReference = -18
+)
+
+// ErrSilentAudio is returned when the loudness of an audio cannot be
+// measured, as it is silent.
+var ErrSilentAudio = errors.New("audio is silent")
+
+// LoudnessTarget normalizes the outputs of a profile to an integrated
+// loudness, in LUFS. TruePeak and LRA default to DefaultTruePeak and
+// DefaultLRA when zero.
+type LoudnessTarget struct {
+	Integrated float64 `yaml:"integrated"`
+	TruePeak   float64 `yaml:"true_peak"`
+	LRA        float64 `yaml:"lra"`
+}
+
+// withDefaults returns the target with the defaults of the unset values.
+func (t LoudnessTarget) withDefaults() LoudnessTarget {
+	if t.TruePeak == 0 {
+		t.TruePeak = DefaultTruePeak
+	}
+
+	if t.LRA == 0 {
+		t.LRA = DefaultLRA
+	}
+
+	return t
+}
+
+// validate checks the target against the ranges of the loudnorm filter.
+func (t LoudnessTarget) validate() error {
+	t = t.withDefaults()
+
+	switch {
+	case t.Integrated < -70 || t.Integrated > -5:
+		return fmt.Errorf("loudness integrated must be between -70 and -5 LUFS")
+	case t.TruePeak < -9 || t.TruePeak > 0:
+		return fmt.Errorf("loudness true_peak must be between -9 and 0 dBTP")
+	case t.LRA < 1 || t.LRA > 50:
+		return fmt.Errorf("loudness lra must be between 1 and 50 LU")
+	}
+
+	return nil
+}
+
+// filter returns the loudnorm filter of the target.
+func (t LoudnessTarget) filter() string {
+	return fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s", formatFloat(t.Integrated), formatFloat(t.TruePeak), formatFloat(t.LRA))
+}
+
+// LoudnormReport is the report printed by the loudnorm filter, the loudness
+// of its input and output along with the gain applied to reach the target.
+type LoudnormReport struct {
+	InputI       float64
+	InputTP      float64
+	InputLRA     float64
+	InputThresh  float64
+	OutputI      float64
+	OutputTP     float64
+	TargetOffset float64
+}
+
+// ParseLoudnormReport parses the last JSON report of the loudnorm filter in
+// the ffmpeg stderr. It returns ErrSilentAudio when the input loudness is
+// not finite.
+func ParseLoudnormReport(stderr []byte) (*LoudnormReport, error) {
+	i := bytes.LastIndex(stderr, []byte("[Parsed_loudnorm"))
+	if i < 0 {
+		return nil, fmt.Errorf("loudnorm report not found")
+	}
+
+	report := stderr[i:]
+
+	start := bytes.IndexByte(report, '{')
+	end := bytes.IndexByte(report, '}')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("loudnorm report not found")
+	}
+
+	var values struct {
+		InputI       string `json:"input_i"`
+		InputTP      string `json:"input_tp"`
+		InputLRA     string `json:"input_lra"`
+		InputThresh  string `json:"input_thresh"`
+		OutputI      string `json:"output_i"`
+		OutputTP     string `json:"output_tp"`
+		TargetOffset string `json:"target_offset"`
+	}
+
+	if err := json.Unmarshal(report[start:end+1], &values); err != nil {
+		return nil, fmt.Errorf("cannot decode loudnorm report: %w", err)
+	}
+
+	r := &LoudnormReport{}
+
+	fields := []struct {
+		name  string
+		value string
+		dst   *float64
+	}{
+		{"input_i", values.InputI, &r.InputI},
+		{"input_tp", values.InputTP, &r.InputTP},
+		{"input_lra", values.InputLRA, &r.InputLRA},
+		{"input_thresh", values.InputThresh, &r.InputThresh},
+		{"output_i", values.OutputI, &r.OutputI},
+		{"output_tp", values.OutputTP, &r.OutputTP},
+		{"target_offset", values.TargetOffset, &r.TargetOffset},
+	}
+
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(f.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode loudnorm %s %q", f.name, f.value)
+		}
+
+		*f.dst = v
+	}
+
+	if math.IsInf(r.InputI, 0) || math.IsInf(r.InputTP, 0) {
+		return nil, ErrSilentAudio
+	}
+
+	return r, nil
+}
+
+// TrackGain returns the ReplayGain 2.0 track gain, in dB, of an audio of
+// the given integrated loudness.
+func TrackGain(integrated float64) float64 {
+	return round(replayGainReference-integrated, 2)
+}
+
+// TrackPeak returns the ReplayGain linear peak of a true peak, in dBTP.
+func TrackPeak(truePeak float64) float64 {
+	return round(math.Pow(10, truePeak/20), 6)
+}
+
+// loudnessTarget returns the target of the profile, the EBU R128 one when
+// the profile is not normalized.
+func (a *Transcoder) loudnessTarget() LoudnessTarget {
+	if a.Profile.Loudness != nil {
+		return a.Profile.Loudness.withDefaults()
+	}
+
+	return LoudnessTarget{Integrated: DefaultTargetLUFS}.withDefaults()
+}
+
+// MeasureLoudness runs the first loudnorm pass on the original and sets the
+// Loudness of the transcoder, required by TranscodeToMp3 to normalize the
+// outputs. It returns ErrSilentAudio when the original is silent, the
+// outputs are then not normalized.
+func (a *Transcoder) MeasureLoudness() error {
+	stderr, err := a.runFFmpegStderr(
+		func(percentage int) {
+			a.progress(StageAnalyze, percentage)
+		},
+		"-i", a.Uploader.GetTmpOriginalFileName(),
+		"-threads", strconv.Itoa(a.Threads),
+		"-vn",
+		"-af", a.loudnessTarget().filter()+":print_format=json",
+		"-f", "null",
+		"-",
+	)
+	if err != nil {
+		return err
+	}
+
+	report, err := ParseLoudnormReport(stderr)
+	if err != nil {
+		return err
+	}
+
+	a.measured = report
+	a.Loudness = &models.Loudness{
+		Integrated: report.InputI,
+		TruePeak:   report.InputTP,
+		LRA:        report.InputLRA,
+		Threshold:  report.InputThresh,
+		TrackGain:  TrackGain(report.InputI),
+		TrackPeak:  TrackPeak(report.InputTP),
+	}
+
+	return nil
+}
+
+// normalizeFilter returns the second loudnorm pass applying the gain
+// measured by MeasureLoudness, linear unless the target range or peak
+// cannot be reached without compressing.
+func (a *Transcoder) normalizeFilter() string {
+	m := a.measured
+
+	return fmt.Sprintf(
+		"%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true:print_format=json",
+		a.loudnessTarget().filter(),
+		formatFloat(m.InputI), formatFloat(m.InputTP), formatFloat(m.InputLRA), formatFloat(m.InputThresh), formatFloat(m.TargetOffset),
+	)
+}
+
+// normalizes reports whether the outputs are normalized, which requires the
+// loudness of the original.
+func (a *Transcoder) normalizes() bool {
+	return a.Profile.Loudness != nil && a.measured != nil
+}
+
+// setNormalized records the loudness of the outputs reported by the second
+// loudnorm pass, or the target when it cannot be read.
+func (a *Transcoder) setNormalized(stderr []byte) error {
+	target := a.loudnessTarget()
+	a.Loudness.Target = target.Integrated
+
+	report, err := ParseLoudnormReport(stderr)
+	if err != nil {
+		a.Loudness.TrackGain = TrackGain(target.Integrated)
+		a.Loudness.TrackPeak = TrackPeak(target.TruePeak)
+
+		return err
+	}
+
+	a.Loudness.TrackGain = TrackGain(report.OutputI)
+	a.Loudness.TrackPeak = TrackPeak(report.OutputTP)
+
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func round(f float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+
+	return math.Round(f*p) / p
+}
@@ -0,0 +1,89 @@
+package transcoder_test
+
+import (
+	"testing"
+
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/stretchr/testify/require"
+)
+
+const loudnormStderr = `Input #0, wav, from 'original':
+  Duration: 00:03:12.00, bitrate: 1411 kb/s
+Stream mapping:
+  Stream #0:0 -> #0:0 (pcm_s16le (native) -> pcm_s16le (native))
+Output #0, null, to 'pipe:':
+size=N/A time=00:03:12.00 bitrate=N/A speed= 412x
+video:0kB audio:36000kB subtitle:0kB other streams:0kB global headers:0kB muxing overhead: unknown
+[Parsed_loudnorm_0 @ 0x5581d6a4bb40] 
+{
+	"input_i" : "-9.87",
+	"input_tp" : "0.42",
+	"input_lra" : "5.30",
+	"input_thresh" : "-19.97",
+	"output_i" : "-23.04",
+	"output_tp" : "-12.71",
+	"output_lra" : "4.90",
+	"output_thresh" : "-33.13",
+	"normalization_type" : "linear",
+	"target_offset" : "0.04"
+}
+`
+
+func TestParseLoudnormReport(t *testing.T) {
+	report, err := transcoder.ParseLoudnormReport([]byte(loudnormStderr))
+	require.NoError(t, err)
+	require.Equal(t, transcoder.LoudnormReport{
+		InputI:       -9.87,
+		InputTP:      0.42,
+		InputLRA:     5.3,
+		InputThresh:  -19.97,
+		OutputI:      -23.04,
+		OutputTP:     -12.71,
+		TargetOffset: 0.04,
+	}, *report)
+
+	silent := []byte(`[Parsed_loudnorm_0 @ 0x5581d6a4bb40] 
+{
+	"input_i" : "-inf",
+	"input_tp" : "-inf",
+	"input_lra" : "0.00",
+	"input_thresh" : "-70.00",
+	"output_i" : "-inf",
+	"output_tp" : "-inf",
+	"output_lra" : "0.00",
+	"output_thresh" : "-70.00",
+	"normalization_type" : "dynamic",
+	"target_offset" : "inf"
+}`)
+
+	_, err = transcoder.ParseLoudnormReport(silent)
+	require.Equal(t, transcoder.ErrSilentAudio, err)
+
+	_, err = transcoder.ParseLoudnormReport([]byte("Stream mapping:\n"))
+	require.Error(t, err)
+}
+
+func TestReplayGain(t *testing.T) {
+	require.Equal(t, -8.13, transcoder.TrackGain(-9.87))
+	require.Equal(t, 5.0, transcoder.TrackGain(-23))
+	require.Equal(t, 1.049542, transcoder.TrackPeak(0.42))
+	require.Equal(t, 0.891251, transcoder.TrackPeak(-1))
+}
+
+func TestValidateProfileLoudness(t *testing.T) {
+	p := transcoder.DefaultProfiles[transcoder.DefaultProfileName]
+
+	p.Loudness = &transcoder.LoudnessTarget{Integrated: -14}
+	require.NoError(t, transcoder.ValidateProfile(p))
+
+	for _, target := range []transcoder.LoudnessTarget{
+		{},
+		{Integrated: -3},
+		{Integrated: -14, TruePeak: 1},
+		{Integrated: -14, LRA: 60},
+	} {
+		target := target
+		p.Loudness = &target
+		require.Error(t, transcoder.ValidateProfile(p), target)
+	}
+}
@@ -0,0 +1,147 @@
+package transcoder
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/angelorc/go-uploader/models"
+)
+
+// maxTagLength is the maximum length, in characters, of a stored tag value,
+// longer ones such as lyrics are truncated.
+const maxTagLength = 1024
+
+// commonTags are the keys of the tags set to the named metadata fields, ID3
+// ones first then the Vorbis comments.
+var commonTags = []struct {
+	keys []string
+	dst  func(m *models.AudioMetadata) *string
+}{
+	{[]string{"title"}, func(m *models.AudioMetadata) *string { return &m.Title }},
+	{[]string{"artist"}, func(m *models.AudioMetadata) *string { return &m.Artist }},
+	{[]string{"album"}, func(m *models.AudioMetadata) *string { return &m.Album }},
+	{[]string{"album_artist", "albumartist", "album artist"}, func(m *models.AudioMetadata) *string { return &m.AlbumArtist }},
+	{[]string{"genre"}, func(m *models.AudioMetadata) *string { return &m.Genre }},
+	{[]string{"date", "year"}, func(m *models.AudioMetadata) *string { return &m.Date }},
+	{[]string{"track", "tracknumber"}, func(m *models.AudioMetadata) *string { return &m.Track }},
+	{[]string{"disc", "discnumber"}, func(m *models.AudioMetadata) *string { return &m.Disc }},
+}
+
+// NewAudioMetadata returns the metadata of the ffprobe output. The tags of
+// the first audio stream, where ogg files keep their Vorbis comments, are
+// merged into the tags of the container.
+func NewAudioMetadata(format FFProbeFormat, streams []FFProbeStream) models.AudioMetadata {
+	m := models.AudioMetadata{
+		Format:   format.Format,
+		Duration: float64(format.Duration),
+		BitRate:  parseInt(format.BitRate),
+		Tags:     normalizeTags(format.Tags),
+		Streams:  make([]models.StreamMetadata, 0, len(streams)),
+	}
+
+	merged := false
+	for _, s := range streams {
+		sm := models.StreamMetadata{
+			Index:         s.Index,
+			CodecType:     s.CodecType,
+			CodecName:     s.CodecName,
+			Profile:       s.Profile,
+			SampleRate:    int(parseInt(s.SampleRate)),
+			Channels:      s.Channels,
+			ChannelLayout: s.ChannelLayout,
+			BitDepth:      int(parseInt(s.BitsPerRawSample)),
+			BitRate:       parseInt(s.BitRate),
+			Duration:      parseFloat(s.Duration),
+			Tags:          normalizeTags(s.Tags),
+		}
+
+		if sm.BitDepth == 0 {
+			sm.BitDepth = s.BitsPerSample
+		}
+
+		if s.CodecType == "audio" && !merged {
+			merged = true
+
+			if m.Tags == nil && len(sm.Tags) > 0 {
+				m.Tags = make(map[string]string, len(sm.Tags))
+			}
+
+			for k, v := range sm.Tags {
+				if _, ok := m.Tags[k]; !ok {
+					m.Tags[k] = v
+				}
+			}
+		}
+
+		m.Streams = append(m.Streams, sm)
+	}
+
+	for _, common := range commonTags {
+		for _, k := range common.keys {
+			if v, ok := m.Tags[k]; ok && v != "" {
+				*common.dst(&m) = v
+				break
+			}
+		}
+	}
+
+	return m
+}
+
+// Metadata returns the metadata of the original, probing it when not yet
+// probed.
+func (a *Transcoder) Metadata() (models.AudioMetadata, error) {
+	if !a.Format.ready {
+		if err := a.ffprobe(); err != nil {
+			return models.AudioMetadata{}, err
+		}
+	}
+
+	return NewAudioMetadata(a.Format, a.Streams), nil
+}
+
+// normalizeTags lower cases the keys of the tags, replacing the characters
+// mongo does not accept in keys, and truncates the long values.
+func normalizeTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	normalized := make(map[string]string, len(tags))
+	for k, v := range tags {
+		k = strings.ToLower(strings.TrimSpace(k))
+		k = strings.NewReplacer(".", "_", "$", "_").Replace(k)
+		if k == "" {
+			continue
+		}
+
+		if r := []rune(v); len(r) > maxTagLength {
+			v = string(r[:maxTagLength])
+		}
+
+		normalized[k] = strings.TrimSpace(v)
+	}
+
+	return normalized
+}
+
+// parseInt returns the integer reported by ffprobe, 0 when unknown.
+func parseInt(s string) int64 {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return i
+}
+
+// parseFloat returns the number reported by ffprobe, 0 when unknown.
+func parseFloat(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0
+	}
+
+	return f
+}
@@ -0,0 +1,142 @@
+package transcoder_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/stretchr/testify/require"
+)
+
+const mp3Probe = `{
+	"streams": [
+		{
+			"index": 0,
+			"codec_name": "mp3",
+			"codec_type": "audio",
+			"sample_fmt": "fltp",
+			"sample_rate": "44100",
+			"channels": 2,
+			"channel_layout": "stereo",
+			"bits_per_sample": 0,
+			"duration": "187.036735",
+			"bit_rate": "320000"
+		},
+		{
+			"index": 1,
+			"codec_name": "mjpeg",
+			"codec_type": "video",
+			"profile": "Baseline",
+			"bits_per_raw_sample": "8",
+			"duration": "N/A",
+			"tags": {"comment": "Cover (front)"}
+		}
+	],
+	"format": {
+		"nb_streams": 2,
+		"format_name": "mp3",
+		"duration": "187.036735",
+		"bit_rate": "321120",
+		"tags": {
+			"title": "Lullaby",
+			"artist": "Bitsong",
+			"album": "Night",
+			"album_artist": "Various Artists",
+			"track": "3/12",
+			"date": "2020",
+			"lyrics.eng": "la la la"
+		}
+	}
+}`
+
+const oggProbe = `{
+	"streams": [
+		{
+			"index": 0,
+			"codec_name": "flac",
+			"codec_type": "audio",
+			"sample_rate": "96000",
+			"channels": 2,
+			"bits_per_raw_sample": "24",
+			"bit_rate": "N/A",
+			"tags": {"TITLE": "Dawn", "ARTIST": "Bitsong", "TRACKNUMBER": "1", "ALBUMARTIST": "Bitsong"}
+		}
+	],
+	"format": {
+		"nb_streams": 1,
+		"format_name": "ogg",
+		"duration": "60.000000",
+		"bit_rate": "N/A",
+		"tags": {"ENCODER": "Lavf58.29.100", "ARTIST": "Bitsong & Friends"}
+	}
+}`
+
+func probe(t *testing.T, output string) models.AudioMetadata {
+	var tr transcoder.Transcoder
+	require.NoError(t, json.Unmarshal([]byte(output), &tr))
+
+	return transcoder.NewAudioMetadata(tr.Format, tr.Streams)
+}
+
+func TestNewAudioMetadata(t *testing.T) {
+	m := probe(t, mp3Probe)
+
+	require.Equal(t, "mp3", m.Format)
+	require.InDelta(t, 187.036, m.Duration, 0.001)
+	require.Equal(t, int64(321120), m.BitRate)
+	require.Equal(t, "Lullaby", m.Title)
+	require.Equal(t, "Bitsong", m.Artist)
+	require.Equal(t, "Night", m.Album)
+	require.Equal(t, "Various Artists", m.AlbumArtist)
+	require.Equal(t, "3/12", m.Track)
+	require.Equal(t, "2020", m.Date)
+	// dots are not allowed in mongo keys
+	require.Equal(t, "la la la", m.Tags["lyrics_eng"])
+
+	require.Equal(t, []models.StreamMetadata{
+		{
+			Index:         0,
+			CodecType:     "audio",
+			CodecName:     "mp3",
+			SampleRate:    44100,
+			Channels:      2,
+			ChannelLayout: "stereo",
+			BitRate:       320000,
+			Duration:      187.036735,
+		},
+		{
+			Index:     1,
+			CodecType: "video",
+			CodecName: "mjpeg",
+			Profile:   "Baseline",
+			BitDepth:  8,
+			Tags:      map[string]string{"comment": "Cover (front)"},
+		},
+	}, m.Streams)
+}
+
+func TestNewAudioMetadataVorbisComments(t *testing.T) {
+	m := probe(t, oggProbe)
+
+	require.Equal(t, int64(0), m.BitRate)
+	require.Equal(t, 24, m.Streams[0].BitDepth)
+	require.Equal(t, 96000, m.Streams[0].SampleRate)
+
+	// the container tags take precedence over the stream ones
+	require.Equal(t, "Bitsong & Friends", m.Artist)
+	require.Equal(t, "Dawn", m.Title)
+	require.Equal(t, "1", m.Track)
+	require.Equal(t, "Bitsong", m.AlbumArtist)
+	require.Equal(t, "Lavf58.29.100", m.Tags["encoder"])
+}
+
+func TestNewAudioMetadataLongTags(t *testing.T) {
+	var tr transcoder.Transcoder
+	tr.Format.Tags = map[string]string{"lyrics": strings.Repeat("é", 2000)}
+
+	m := transcoder.NewAudioMetadata(tr.Format, nil)
+	require.Len(t, []rune(m.Tags["lyrics"]), 1024)
+	require.Empty(t, m.Streams)
+}
@@ -0,0 +1,78 @@
+package transcoder
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// mediaSegment is a segment listed in a media playlist.
+type mediaSegment struct {
+	URI string
+	// Duration is the EXTINF duration, in seconds.
+	Duration float64
+}
+
+// mediaPlaylist holds the files referenced by an HLS media playlist, URIs
+// are relative to the playlist.
+type mediaPlaylist struct {
+	Init     string
+	Segments []mediaSegment
+}
+
+// readMediaPlaylist returns the stored media playlist of the rendition.
+func (a *Transcoder) readMediaPlaylist(r Rendition) (*mediaPlaylist, error) {
+	bz, err := a.readOutput(r.Name + "/" + playlistFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseMediaPlaylist(bz)
+}
+
+func parseMediaPlaylist(bz []byte) (*mediaPlaylist, error) {
+	var (
+		playlist mediaPlaylist
+		duration float64
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(bz))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0]
+			duration, _ = strconv.ParseFloat(value, 64)
+
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			if m := tagURI.FindStringSubmatch(line); m != nil {
+				playlist.Init = m[1]
+			}
+
+		case line != "" && !strings.HasPrefix(line, "#"):
+			playlist.Segments = append(playlist.Segments, mediaSegment{
+				URI:      line,
+				Duration: duration,
+			})
+			duration = 0
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &playlist, nil
+}
+
+// Duration returns the total duration of the segments, in seconds.
+func (p *mediaPlaylist) Duration() float64 {
+	var total float64
+	for _, s := range p.Segments {
+		total += s.Duration
+	}
+
+	return total
+}
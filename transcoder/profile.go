@@ -0,0 +1,87 @@
+package transcoder
+
+import (
+	"fmt"
+)
+
+const (
+	// SegmentTypeMPEGTS outputs MPEG-2 Transport Stream segments, compatible
+	// with every HLS version.
+	SegmentTypeMPEGTS = "mpegts"
+	// SegmentTypeFMP4 outputs fragmented MP4 (CMAF) segments along with an
+	// init segment, which can be served to both HLS and DASH players.
+	SegmentTypeFMP4 = "fmp4"
+
+	// DefaultProfileName is the profile used when a job does not select one.
+	DefaultProfileName = "default"
+)
+
+// Profile defines the HLS output of a job. Loudness, when set, normalizes
+// the outputs, which otherwise keep the levels of the original.
+type Profile struct {
+	SegmentType string          `yaml:"segment_type"`
+	Ladder      []Rendition     `yaml:"ladder"`
+	Loudness    *LoudnessTarget `yaml:"loudness,omitempty"`
+}
+
+// DefaultProfiles are the profiles available when none is configured.
+var DefaultProfiles = map[string]Profile{
+	DefaultProfileName: {
+		SegmentType: SegmentTypeMPEGTS,
+		Ladder:      DefaultLadder,
+	},
+	"cmaf": {
+		SegmentType: SegmentTypeFMP4,
+		Ladder: []Rendition{
+			{Name: "256k", Bitrate: 256, Codec: CodecAAC},
+			{Name: "128k", Bitrate: 128, Codec: CodecAAC},
+			{Name: "64k", Bitrate: 64, Codec: CodecAAC},
+			{Name: "opus128k", Bitrate: 128, Codec: CodecOpus},
+		},
+	},
+}
+
+// ValidateProfile checks that the profile can be transcoded.
+func ValidateProfile(p Profile) error {
+	if err := ValidateLadder(p.Ladder); err != nil {
+		return err
+	}
+
+	if p.Loudness != nil {
+		if err := p.Loudness.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range p.Ladder {
+		switch {
+		case p.SegmentType == SegmentTypeMPEGTS && r.Codec == CodecOpus:
+			return fmt.Errorf("rendition %s: opus requires %s segments", r.Name, SegmentTypeFMP4)
+		case p.SegmentType == SegmentTypeFMP4 && r.Codec == CodecMP3:
+			return fmt.Errorf("rendition %s: mp3 requires %s segments", r.Name, SegmentTypeMPEGTS)
+		case p.SegmentType != SegmentTypeMPEGTS && p.SegmentType != SegmentTypeFMP4:
+			return fmt.Errorf("unsupported segment type %q", p.SegmentType)
+		}
+	}
+
+	return nil
+}
+
+// segmentFileName returns the ffmpeg pattern of the segment files.
+func (p Profile) segmentFileName() string {
+	if p.SegmentType == SegmentTypeFMP4 {
+		return "segment%03d.m4s"
+	}
+
+	return "segment%03d.ts"
+}
+
+// playlistVersion returns the minimum HLS version required by the profile.
+func (p Profile) playlistVersion() int {
+	if p.SegmentType == SegmentTypeFMP4 {
+		// EXT-X-MAP in media playlists without I-frames only
+		return 6
+	}
+
+	return 3
+}
@@ -0,0 +1,72 @@
+package transcoder
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Transcoding stages reported to the ProgressFunc.
+const (
+	StageAnalyze   = "analyze"
+	StageTranscode = "transcode"
+	StageWaveform  = "waveform"
+	StageSegment   = "segment"
+	StagePublish   = "publish"
+)
+
+// ProgressFunc receives the percentage, between 0 and 100, of a stage.
+type ProgressFunc func(stage string, percentage int)
+
+// ReadProgress parses the key=value blocks written by ffmpeg -progress and
+// calls fn with the percentage of out_time over duration, in seconds. It
+// returns when r is drained.
+func ReadProgress(r io.Reader, duration float32, fn func(percentage int)) error {
+	last := -1
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		percentage := -1
+
+		switch kv[0] {
+		// both values are expressed in microseconds, out_time_ms is kept by
+		// ffmpeg for compatibility
+		case "out_time_us", "out_time_ms":
+			us, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || us < 0 || duration <= 0 {
+				continue
+			}
+
+			percentage = int(float64(us) / 1e6 / float64(duration) * 100)
+			if percentage > 99 {
+				// 100 is reported only once ffmpeg is done
+				percentage = 99
+			}
+
+		case "progress":
+			if kv[1] == "end" {
+				percentage = 100
+			}
+		}
+
+		if percentage > last {
+			last = percentage
+			fn(percentage)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// progress reports the percentage of the stage to OnProgress, when set.
+func (a *Transcoder) progress(stage string, percentage int) {
+	if a.OnProgress != nil {
+		a.OnProgress(stage, percentage)
+	}
+}
@@ -0,0 +1,51 @@
+package transcoder_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/stretchr/testify/require"
+)
+
+const ffmpegProgress = `bitrate= 320.0kbits/s
+total_size=4194304
+out_time_us=2500000
+out_time_ms=2500000
+out_time=00:00:02.500000
+speed=25x
+progress=continue
+bitrate= 320.0kbits/s
+out_time_us=5000000
+out_time_ms=5000000
+progress=continue
+out_time_us=N/A
+out_time_ms=N/A
+progress=continue
+out_time_us=10000000
+out_time_ms=10000000
+progress=continue
+out_time_us=10020000
+out_time_ms=10020000
+progress=end
+`
+
+func TestReadProgress(t *testing.T) {
+	var got []int
+	err := transcoder.ReadProgress(strings.NewReader(ffmpegProgress), 10, func(percentage int) {
+		got = append(got, percentage)
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, []int{25, 50, 99, 100}, got)
+}
+
+func TestReadProgressUnknownDuration(t *testing.T) {
+	var got []int
+	err := transcoder.ReadProgress(strings.NewReader(ffmpegProgress), 0, func(percentage int) {
+		got = append(got, percentage)
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, []int{100}, got)
+}
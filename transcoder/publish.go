@@ -0,0 +1,250 @@
+package transcoder
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/services"
+	"github.com/rs/zerolog/log"
+)
+
+// PublishToIpfs adds the stored HLS output to ipfs, starting from the master
+// playlist. Every playlist is rewritten so that each URI points to gateway +
+// CID of the published file, media playlists are published before the
+// playlists referencing them. When gateway is empty the bare CID is written,
+// which resolves relative to the playlist when it is served from /ipfs/<cid>.
+// The DASH manifest, when the profile has one, is rewritten the same way and
+// shares the published segments. It returns the published manifests, the HLS
+// master playlist first.
+func (a *Transcoder) PublishToIpfs(ipfs *services.Ipfs, gateway string) ([]models.Manifest, error) {
+	segments, err := a.countSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &publisher{
+		transcoder: a,
+		ipfs:       ipfs,
+		gateway:    gateway,
+		segments:   segments,
+		cids:       make(map[string]string),
+	}
+
+	cid, err := p.publishPlaylist(masterPlaylistFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := []models.Manifest{
+		{Type: models.ManifestHLS, FileName: masterPlaylistFileName, Cid: cid},
+	}
+
+	if a.Profile.HasDash() {
+		cid, err := p.publishDash(dashManifestFileName)
+		if err != nil {
+			return nil, err
+		}
+
+		manifests = append(manifests, models.Manifest{Type: models.ManifestDash, FileName: dashManifestFileName, Cid: cid})
+	}
+
+	a.progress(StagePublish, 100)
+
+	return manifests, nil
+}
+
+type publisher struct {
+	transcoder *Transcoder
+	ipfs       *services.Ipfs
+	gateway    string
+
+	segments  int
+	published int
+
+	// cids holds the CID of the files already published, by name.
+	cids map[string]string
+}
+
+// publishPlaylist publishes the files referenced by the stored playlist
+// name, then the rewritten playlist, and returns its CID.
+func (p *publisher) publishPlaylist(name string) (string, error) {
+	playlist, err := p.transcoder.readOutput(name)
+	if err != nil {
+		return "", err
+	}
+
+	dir := path.Dir(name)
+
+	var rewritten bytes.Buffer
+
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// tags referencing a file, such as the EXT-X-MAP init segment, are
+		// rewritten, other tags, comments and blank lines are copied as they are
+		if line == "" || strings.HasPrefix(line, "#") {
+			line, err = p.rewriteTagURI(dir, line)
+			if err != nil {
+				return "", err
+			}
+
+			rewritten.WriteString(line + "\n")
+			continue
+		}
+
+		var cid string
+		if strings.HasSuffix(line, ".m3u8") {
+			cid, err = p.publishPlaylist(path.Join(dir, line))
+		} else {
+			cid, err = p.publishSegment(path.Join(dir, line))
+		}
+		if err != nil {
+			return "", err
+		}
+
+		rewritten.WriteString(p.gateway + cid + "\n")
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	cid, err := p.addRewritten(strings.TrimSuffix(name, ".m3u8")+".ipfs.m3u8", rewritten.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug().Str("playlist", name).Str("cid", cid).Msg("playlist published to ipfs")
+
+	return cid, nil
+}
+
+// tagURI matches the URI attribute of a playlist tag.
+var tagURI = regexp.MustCompile(`URI="([^"]+)"`)
+
+// rewriteTagURI publishes the file referenced by the URI attribute of the tag,
+// if any, and points the attribute to its CID.
+func (p *publisher) rewriteTagURI(dir string, tag string) (string, error) {
+	m := tagURI.FindStringSubmatchIndex(tag)
+	if m == nil {
+		return tag, nil
+	}
+
+	cid, err := p.publishFile(path.Join(dir, tag[m[2]:m[3]]))
+	if err != nil {
+		return "", err
+	}
+
+	return tag[:m[2]] + p.gateway + cid + tag[m[3]:], nil
+}
+
+// dashURL matches the attributes of a DASH manifest referencing a segment.
+var dashURL = regexp.MustCompile(`(media|sourceURL)="([^"]+)"`)
+
+// publishDash publishes the stored DASH manifest name with every segment
+// URL pointing to the CID of the segment, and returns its CID.
+func (p *publisher) publishDash(name string) (string, error) {
+	manifest, err := p.transcoder.readOutput(name)
+	if err != nil {
+		return "", err
+	}
+
+	dir := path.Dir(name)
+
+	var rewritten bytes.Buffer
+
+	last := 0
+	for _, m := range dashURL.FindAllSubmatchIndex(manifest, -1) {
+		uri := string(manifest[m[4]:m[5]])
+
+		var cid string
+		if string(manifest[m[2]:m[3]]) == "media" {
+			cid, err = p.publishSegment(path.Join(dir, uri))
+		} else {
+			cid, err = p.publishFile(path.Join(dir, uri))
+		}
+		if err != nil {
+			return "", err
+		}
+
+		rewritten.Write(manifest[last:m[4]])
+		rewritten.WriteString(p.gateway + cid)
+		last = m[5]
+	}
+	rewritten.Write(manifest[last:])
+
+	cid, err := p.addRewritten(strings.TrimSuffix(name, ".mpd")+".ipfs.mpd", rewritten.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug().Str("manifest", name).Str("cid", cid).Msg("dash manifest published to ipfs")
+
+	return cid, nil
+}
+
+// addRewritten stores the playlist or manifest rewritten with the CIDs under
+// name, then adds it to ipfs.
+func (p *publisher) addRewritten(name string, data []byte) (string, error) {
+	if err := p.transcoder.putOutput(name, data); err != nil {
+		return "", err
+	}
+
+	return p.ipfs.Add(bytes.NewReader(data))
+}
+
+// add adds the stored output name to ipfs.
+func (p *publisher) add(name string) (string, error) {
+	r, err := p.transcoder.Storage.Get(p.transcoder.GetStorageKey(name))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	return p.ipfs.Add(r)
+}
+
+// publishFile adds the file to ipfs unless it has already been published.
+func (p *publisher) publishFile(name string) (string, error) {
+	if cid, ok := p.cids[name]; ok {
+		return cid, nil
+	}
+
+	cid, err := p.add(name)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug().Str("file", name).Str("cid", cid).Msg("file published to ipfs")
+
+	p.cids[name] = cid
+
+	return cid, nil
+}
+
+func (p *publisher) publishSegment(name string) (string, error) {
+	if cid, ok := p.cids[name]; ok {
+		return cid, nil
+	}
+
+	cid, err := p.add(name)
+	if err != nil {
+		return "", err
+	}
+
+	p.cids[name] = cid
+
+	log.Debug().Str("segment", name).Str("cid", cid).Msg("segment published to ipfs")
+
+	p.published++
+	if p.published < p.segments {
+		p.transcoder.progress(StagePublish, p.published*100/p.segments)
+	}
+
+	return cid, nil
+}
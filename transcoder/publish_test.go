@@ -0,0 +1,267 @@
+package transcoder_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/angelorc/go-uploader/models"
+	"github.com/angelorc/go-uploader/services"
+	"github.com/angelorc/go-uploader/storage"
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeIpfs is a local stand-in for the ipfs HTTP API which stores the added
+// files in memory, keyed by a fake CID derived from their content.
+type fakeIpfs struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (f *fakeIpfs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v0/add" {
+		http.NotFound(w, r)
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	part, err := reader.NextPart()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bz, err := ioutil.ReadAll(part)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sum := sha256.Sum256(bz)
+	cid := "Qm" + hex.EncodeToString(sum[:])[:44]
+
+	f.mu.Lock()
+	f.files[cid] = bz
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"Hash": cid})
+}
+
+func chdirTemp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transcoder")
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+		_ = os.RemoveAll(dir)
+	})
+}
+
+func newTestUploader(t *testing.T, filename string) *services.Uploader {
+	u := &services.Uploader{
+		ID:     uuid.New(),
+		Header: &multipart.FileHeader{Filename: filename},
+	}
+	require.NoError(t, os.MkdirAll(u.GetDir(), 0755))
+
+	return u
+}
+
+// newTestTranscoder returns a transcoder storing its outputs in the storage
+// directory of the working directory.
+func newTestTranscoder(t *testing.T, filename string) *transcoder.Transcoder {
+	tr := transcoder.NewTranscoder(newTestUploader(t, filename), primitive.NewObjectID())
+	tr.Storage = storage.NewLocal("storage")
+
+	return tr
+}
+
+// putTestOutput stores an output of the transcoder, as written by a previous stage.
+func putTestOutput(t *testing.T, tr *transcoder.Transcoder, name string, content string) {
+	require.NoError(t, tr.Storage.Put(tr.GetStorageKey(name), strings.NewReader(content)))
+}
+
+// readTestOutput returns a stored output of the transcoder.
+func readTestOutput(t *testing.T, tr *transcoder.Transcoder, name string) []byte {
+	r, err := tr.Storage.Get(tr.GetStorageKey(name))
+	require.NoError(t, err)
+	defer r.Close()
+
+	bz, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	return bz
+}
+
+// uris returns the non-tag lines of the playlist.
+func uris(playlist []byte) []string {
+	var uris []string
+	for _, line := range strings.Split(strings.TrimSpace(string(playlist)), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			uris = append(uris, line)
+		}
+	}
+
+	return uris
+}
+
+// writeTestRendition stores a media playlist and its segments for the rendition.
+func writeTestRendition(t *testing.T, tr *transcoder.Transcoder, r transcoder.Rendition, segments ...string) {
+	playlist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:5\n"
+	for i, content := range segments {
+		name := fmt.Sprintf("segment%03d.ts", i)
+		playlist += "#EXTINF:5.000000,\n" + name + "\n"
+
+		putTestOutput(t, tr, r.Name+"/"+name, content)
+	}
+	playlist += "#EXT-X-ENDLIST\n"
+
+	putTestOutput(t, tr, r.Name+"/list.m3u8", playlist)
+}
+
+func TestPublishToIpfs(t *testing.T) {
+	chdirTemp(t)
+
+	fake := &fakeIpfs{files: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tr := newTestTranscoder(t, "track.mp3")
+	tr.Profile.Ladder = []transcoder.Rendition{
+		{Name: "320k", Bitrate: 320, Codec: transcoder.CodecAAC},
+		{Name: "64k", Bitrate: 64, Codec: transcoder.CodecAAC},
+	}
+
+	writeTestRendition(t, tr, tr.Profile.Ladder[0], "320k first segment", "320k second segment")
+	writeTestRendition(t, tr, tr.Profile.Ladder[1], "64k first segment", "64k second segment")
+	require.NoError(t, tr.WriteMasterPlaylist())
+
+	var progress []int
+	tr.OnProgress = func(stage string, percentage int) {
+		require.Equal(t, transcoder.StagePublish, stage)
+		progress = append(progress, percentage)
+	}
+
+	const gateway = "https://gateway.test/ipfs/"
+
+	manifests, err := tr.PublishToIpfs(services.NewIpfs(srv.URL), gateway)
+	require.NoError(t, err)
+	require.Equal(t, []int{25, 50, 75, 100}, progress)
+
+	require.Len(t, manifests, 1)
+	require.Equal(t, models.ManifestHLS, manifests[0].Type)
+	cid := manifests[0].Cid
+
+	// 4 segments, 2 media playlists and the master playlist
+	require.Len(t, fake.files, 7)
+
+	master, ok := fake.files[cid]
+	require.True(t, ok)
+	require.True(t, strings.HasPrefix(string(master), "#EXTM3U\n"))
+	require.Contains(t, string(master), "#EXT-X-STREAM-INF:")
+
+	variants := uris(master)
+	require.Len(t, variants, 2)
+
+	for i, r := range tr.Profile.Ladder {
+		require.True(t, strings.HasPrefix(variants[i], gateway))
+
+		media := fake.files[strings.TrimPrefix(variants[i], gateway)]
+		require.Contains(t, string(media), "#EXT-X-ENDLIST")
+
+		segments := uris(media)
+		require.Len(t, segments, 2)
+
+		for j, content := range []string{" first segment", " second segment"} {
+			require.True(t, strings.HasPrefix(segments[j], gateway+"Qm"))
+			require.Equal(t, r.Name+content, string(fake.files[strings.TrimPrefix(segments[j], gateway)]))
+		}
+	}
+}
+
+func TestPublishToIpfsFMP4(t *testing.T) {
+	chdirTemp(t)
+
+	fake := &fakeIpfs{files: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tr := newTestTranscoder(t, "track.flac")
+	tr.Profile = transcoder.DefaultProfiles["cmaf"]
+	tr.Profile.Ladder = tr.Profile.Ladder[:1]
+
+	r := tr.Profile.Ladder[0]
+	putTestOutput(t, tr, r.Name+"/init.mp4", "init segment")
+	putTestOutput(t, tr, r.Name+"/segment000.m4s", "fragment")
+
+	playlist := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:5\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:5.000000,\nsegment000.m4s\n#EXT-X-ENDLIST\n"
+	putTestOutput(t, tr, r.Name+"/list.m3u8", playlist)
+	require.NoError(t, tr.WriteMasterPlaylist())
+
+	require.NoError(t, tr.WriteDashManifest())
+
+	manifests, err := tr.PublishToIpfs(services.NewIpfs(srv.URL), "")
+	require.NoError(t, err)
+	require.Len(t, manifests, 2)
+
+	// init segment, fragment, media playlist, master playlist and dash manifest
+	require.Len(t, fake.files, 5)
+
+	media := fake.files[uris(fake.files[manifests[0].Cid])[0]]
+
+	var initCid string
+	for _, line := range strings.Split(string(media), "\n") {
+		if strings.HasPrefix(line, "#EXT-X-MAP:URI=") {
+			initCid = strings.Trim(strings.TrimPrefix(line, "#EXT-X-MAP:URI="), "\"")
+		}
+	}
+
+	require.Equal(t, "init segment", string(fake.files[initCid]))
+	require.Equal(t, "fragment", string(fake.files[uris(media)[0]]))
+
+	require.Equal(t, models.ManifestDash, manifests[1].Type)
+
+	var mpd transcoder.MPD
+	require.NoError(t, xml.Unmarshal(fake.files[manifests[1].Cid], &mpd))
+
+	list := mpd.Periods[0].AdaptationSets[0].Representations[0].SegmentList
+	require.Equal(t, initCid, list.Initialization.SourceURL)
+	require.Equal(t, uris(media)[0], list.SegmentURLs[0].Media)
+}
+
+func TestPublishToIpfsMissingSegment(t *testing.T) {
+	chdirTemp(t)
+
+	srv := httptest.NewServer(&fakeIpfs{files: make(map[string][]byte)})
+	defer srv.Close()
+
+	tr := newTestTranscoder(t, "track.mp3")
+	putTestOutput(t, tr, "master.m3u8", "#EXTM3U\n320k/list.m3u8\n")
+	putTestOutput(t, tr, "320k/list.m3u8", "#EXTM3U\nsegment000.ts\n")
+
+	_, err := tr.PublishToIpfs(services.NewIpfs(srv.URL), "")
+	require.Error(t, err)
+}
@@ -0,0 +1,173 @@
+package transcoder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+const (
+	AudioFormatMP3  = "mp3"
+	AudioFormatAAC  = "aac"
+	AudioFormatWAV  = "wav"
+	AudioFormatAIFF = "aiff"
+	AudioFormatFLAC = "flac"
+	AudioFormatOGG  = "ogg"
+	AudioFormatM4A  = "m4a"
+)
+
+// SniffLength is the number of leading bytes needed by DetectAudioFormat.
+const SniffLength = 512
+
+var (
+	ErrExecutable   = errors.New("executable files are not allowed")
+	ErrArchive      = errors.New("archives are not allowed")
+	ErrUnknownAudio = errors.New("unrecognized audio format")
+	ErrNoAudio      = errors.New("no audio stream found")
+)
+
+type signature struct {
+	format string
+	match  func(header []byte) bool
+}
+
+func prefix(magic string) func([]byte) bool {
+	return func(header []byte) bool {
+		return bytes.HasPrefix(header, []byte(magic))
+	}
+}
+
+// rejectedSignatures are the files which are refused whatever their
+// extension or content type.
+var rejectedSignatures = []struct {
+	err   error
+	match func([]byte) bool
+}{
+	{ErrExecutable, prefix("MZ")},               // PE
+	{ErrExecutable, prefix("\x7fELF")},          // ELF
+	{ErrExecutable, prefix("\xfe\xed\xfa\xce")}, // Mach-O 32
+	{ErrExecutable, prefix("\xfe\xed\xfa\xcf")}, // Mach-O 64
+	{ErrExecutable, prefix("\xce\xfa\xed\xfe")}, // Mach-O 32, little endian
+	{ErrExecutable, prefix("\xcf\xfa\xed\xfe")}, // Mach-O 64, little endian
+	{ErrExecutable, prefix("\xca\xfe\xba\xbe")}, // Mach-O universal, java class
+	{ErrExecutable, prefix("#!")},               // script
+	{ErrArchive, prefix("PK\x03\x04")},          // zip, jar, docx
+	{ErrArchive, prefix("PK\x05\x06")},          // empty zip
+	{ErrArchive, prefix("Rar!\x1a\x07")},
+	{ErrArchive, prefix("7z\xbc\xaf\x27\x1c")},
+	{ErrArchive, prefix("\x1f\x8b")},         // gzip
+	{ErrArchive, prefix("BZh")},              // bzip2
+	{ErrArchive, prefix("\xfd7zXZ\x00")},     // xz
+	{ErrArchive, prefix("\x28\xb5\x2f\xfd")}, // zstd
+	{ErrArchive, func(header []byte) bool { // tar
+		return len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar"))
+	}},
+}
+
+// m4aBrands are the major brands of the ftyp box of the MPEG-4 audio files.
+// The other ISO base media files, e.g. 3gp, heic, avif or QuickTime, are not
+// audio files.
+var m4aBrands = map[string]bool{
+	"M4A ": true,
+	"M4B ": true, // audiobook
+	"M4P ": true, // protected
+	"F4A ": true, // flash
+	"mp41": true,
+	"mp42": true,
+	"isom": true,
+	"iso2": true,
+	"dash": true,
+}
+
+var audioSignatures = []signature{
+	{AudioFormatMP3, prefix("ID3")},
+	{AudioFormatFLAC, prefix("fLaC")},
+	{AudioFormatOGG, prefix("OggS")},
+	{AudioFormatWAV, func(header []byte) bool {
+		return len(header) >= 12 && (bytes.Equal(header[0:4], []byte("RIFF")) || bytes.Equal(header[0:4], []byte("RF64"))) &&
+			bytes.Equal(header[8:12], []byte("WAVE"))
+	}},
+	{AudioFormatAIFF, func(header []byte) bool {
+		return len(header) >= 12 && bytes.Equal(header[0:4], []byte("FORM")) &&
+			(bytes.Equal(header[8:12], []byte("AIFF")) || bytes.Equal(header[8:12], []byte("AIFC")))
+	}},
+	{AudioFormatM4A, func(header []byte) bool {
+		return len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")) && m4aBrands[string(header[8:12])]
+	}},
+	{AudioFormatAAC, func(header []byte) bool {
+		// ADTS sync word with layer 0
+		return len(header) >= 2 && header[0] == 0xFF && header[1]&0xF6 == 0xF0
+	}},
+	{AudioFormatMP3, func(header []byte) bool {
+		// MPEG audio frame sync with a valid version, layer and bitrate
+		return len(header) >= 3 && header[0] == 0xFF && header[1]&0xE0 == 0xE0 &&
+			header[1]&0x18 != 0x08 && header[1]&0x06 != 0 && header[2]&0xF0 != 0xF0
+	}},
+}
+
+// DetectAudioFormat returns the audio format matching the magic bytes at the
+// beginning of header. Executables and archives are rejected with
+// ErrExecutable and ErrArchive, any other unknown content with
+// ErrUnknownAudio.
+func DetectAudioFormat(header []byte) (string, error) {
+	for _, s := range rejectedSignatures {
+		if s.match(header) {
+			return "", s.err
+		}
+	}
+
+	for _, s := range audioSignatures {
+		if s.match(header) {
+			return s.format, nil
+		}
+	}
+
+	return "", ErrUnknownAudio
+}
+
+// FFProbeStream is a stream reported by ffprobe. The numbers written as
+// strings are kept as reported, ffprobe writes N/A when unknown.
+type FFProbeStream struct {
+	Index            int                `json:"index"`
+	CodecType        string             `json:"codec_type"`
+	CodecName        string             `json:"codec_name"`
+	Profile          string             `json:"profile"`
+	SampleRate       string             `json:"sample_rate"`
+	Channels         int                `json:"channels"`
+	ChannelLayout    string             `json:"channel_layout"`
+	BitsPerSample    int                `json:"bits_per_sample"`
+	BitsPerRawSample string             `json:"bits_per_raw_sample"`
+	BitRate          string             `json:"bit_rate"`
+	Duration         string             `json:"duration"`
+	Tags             map[string]string  `json:"tags"`
+	Disposition      FFProbeDisposition `json:"disposition"`
+}
+
+// FFProbeDisposition holds the dispositions of a stream used by the checks,
+// AttachedPic is set on the cover art of the audio files.
+type FFProbeDisposition struct {
+	AttachedPic int `json:"attached_pic"`
+}
+
+// ValidateStreams checks that the streams reported by ffprobe hold at least
+// one decodable audio stream.
+func ValidateStreams(streams []FFProbeStream) error {
+	for _, s := range streams {
+		if s.CodecType == "audio" && s.CodecName != "" {
+			return nil
+		}
+	}
+
+	return ErrNoAudio
+}
+
+// CheckAudio confirms with ffprobe that the original upload is an audio file.
+func (a *Transcoder) CheckAudio() error {
+	if !a.Format.ready {
+		if err := a.ffprobe(); err != nil {
+			return fmt.Errorf("cannot probe audio: %w", err)
+		}
+	}
+
+	return ValidateStreams(a.Streams)
+}
@@ -0,0 +1,152 @@
+package transcoder_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/stretchr/testify/require"
+)
+
+// wavHeader returns the header of a 16 bit stereo PCM wave file.
+func wavHeader() []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(36))
+	b.WriteString("WAVEfmt ")
+	_ = binary.Write(&b, binary.LittleEndian, []interface{}{
+		uint32(16), uint16(1), uint16(2), uint32(48000), uint32(192000), uint16(4), uint16(16),
+	})
+	b.WriteString("data")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0))
+
+	return b.Bytes()
+}
+
+func tarHeader() []byte {
+	header := make([]byte, 512)
+	copy(header, "track.mp3")
+	copy(header[257:], "ustar\x0000")
+
+	return header
+}
+
+func TestDetectAudioFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		format string
+		err    error
+	}{
+		{"mp3 with id3 tag", []byte("ID3\x04\x00\x00\x00\x00\x00\x00"), transcoder.AudioFormatMP3, nil},
+		{"mp3 frame, mpeg1 layer 3 128k", []byte{0xFF, 0xFB, 0x90, 0x64}, transcoder.AudioFormatMP3, nil},
+		{"mp3 frame, mpeg2 layer 3", []byte{0xFF, 0xF3, 0x40, 0xC4}, transcoder.AudioFormatMP3, nil},
+		{"aac adts", []byte{0xFF, 0xF1, 0x50, 0x80, 0x02, 0x1F, 0xFC}, transcoder.AudioFormatAAC, nil},
+		{"wav", wavHeader(), transcoder.AudioFormatWAV, nil},
+		{"aiff", []byte("FORM\x00\x00\x00\x00AIFFCOMM"), transcoder.AudioFormatAIFF, nil},
+		{"flac", []byte("fLaC\x00\x00\x00\x22"), transcoder.AudioFormatFLAC, nil},
+		{"ogg opus", []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00OpusHead"), transcoder.AudioFormatOGG, nil},
+		{"m4a", []byte("\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00"), transcoder.AudioFormatM4A, nil},
+		{"mp4", []byte("\x00\x00\x00\x20ftypmp42\x00\x00\x00\x00"), transcoder.AudioFormatM4A, nil},
+
+		{"windows executable", []byte("MZ\x90\x00\x03\x00\x00\x00"), "", transcoder.ErrExecutable},
+		{"elf executable", []byte("\x7fELF\x02\x01\x01\x00"), "", transcoder.ErrExecutable},
+		{"mach-o executable", []byte("\xcf\xfa\xed\xfe\x07\x00\x00\x01"), "", transcoder.ErrExecutable},
+		{"shell script", []byte("#!/bin/sh\nrm -rf /\n"), "", transcoder.ErrExecutable},
+		{"zip", []byte("PK\x03\x04\x14\x00\x00\x00"), "", transcoder.ErrArchive},
+		{"rar", []byte("Rar!\x1a\x07\x01\x00"), "", transcoder.ErrArchive},
+		{"7z", []byte("7z\xbc\xaf\x27\x1c\x00\x04"), "", transcoder.ErrArchive},
+		{"gzip", []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00"), "", transcoder.ErrArchive},
+		{"tar", tarHeader(), "", transcoder.ErrArchive},
+		{"wav header spoofing an executable", append([]byte("MZ"), wavHeader()...), "", transcoder.ErrExecutable},
+
+		{"png", []byte("\x89PNG\r\n\x1a\n"), "", transcoder.ErrUnknownAudio},
+		{"pdf", []byte("%PDF-1.4\n"), "", transcoder.ErrUnknownAudio},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), "", transcoder.ErrUnknownAudio},
+		{"3gp", []byte("\x00\x00\x00\x18ftyp3gp4\x00\x00\x00\x00"), "", transcoder.ErrUnknownAudio},
+		{"riff but not wave", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "", transcoder.ErrUnknownAudio},
+		{"text", []byte("this is not an audio file"), "", transcoder.ErrUnknownAudio},
+		{"frame sync with reserved version", []byte{0xFF, 0xE9, 0x90, 0x00}, "", transcoder.ErrUnknownAudio},
+		{"empty", nil, "", transcoder.ErrUnknownAudio},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := transcoder.DetectAudioFormat(tt.header)
+			require.Equal(t, tt.err, err)
+			require.Equal(t, tt.format, format)
+		})
+	}
+}
+
+// TestDetectAudioFormatFiles sniffs the files of testdata/sniff, small
+// audio files and files spoofing an audio extension.
+func TestDetectAudioFormatFiles(t *testing.T) {
+	tests := []struct {
+		file   string
+		format string
+		err    error
+	}{
+		{"silence.mp3", transcoder.AudioFormatMP3, nil},
+		{"tagged.mp3", transcoder.AudioFormatMP3, nil},
+		{"silence.aac", transcoder.AudioFormatAAC, nil},
+		{"tone.wav", transcoder.AudioFormatWAV, nil},
+		{"tone.aiff", transcoder.AudioFormatAIFF, nil},
+		{"tone.flac", transcoder.AudioFormatFLAC, nil},
+		{"silence.opus", transcoder.AudioFormatOGG, nil},
+		{"silence.m4a", transcoder.AudioFormatM4A, nil},
+		{"silence.mp4", transcoder.AudioFormatM4A, nil},
+
+		{"setup.wav", "", transcoder.ErrExecutable},
+		{"install.wav", "", transcoder.ErrExecutable},
+		{"album.m4a", "", transcoder.ErrArchive},
+		{"track.flac", "", transcoder.ErrArchive},
+		{"cover.mp3", "", transcoder.ErrUnknownAudio},
+		{"notes.ogg", "", transcoder.ErrUnknownAudio},
+		{"video.3gp", "", transcoder.ErrUnknownAudio},
+		{"movie.mov", "", transcoder.ErrUnknownAudio},
+		{"image.heic", "", transcoder.ErrUnknownAudio},
+		{"image.avif", "", transcoder.ErrUnknownAudio},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			content, err := ioutil.ReadFile(filepath.Join("testdata", "sniff", tt.file))
+			require.NoError(t, err)
+
+			if len(content) > transcoder.SniffLength {
+				content = content[:transcoder.SniffLength]
+			}
+
+			format, err := transcoder.DetectAudioFormat(content)
+			require.Equal(t, tt.err, err)
+			require.Equal(t, tt.format, format)
+		})
+	}
+}
+
+func TestValidateStreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams []transcoder.FFProbeStream
+		err     error
+	}{
+		{"audio", []transcoder.FFProbeStream{{Index: 0, CodecType: "audio", CodecName: "mp3"}}, nil},
+		{"audio with cover art", []transcoder.FFProbeStream{
+			{Index: 0, CodecType: "audio", CodecName: "flac"},
+			{Index: 1, CodecType: "video", CodecName: "mjpeg"},
+		}, nil},
+		{"video only", []transcoder.FFProbeStream{{Index: 0, CodecType: "video", CodecName: "h264"}}, transcoder.ErrNoAudio},
+		{"audio without decoder", []transcoder.FFProbeStream{{Index: 0, CodecType: "audio"}}, transcoder.ErrNoAudio},
+		{"no streams", nil, transcoder.ErrNoAudio},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.err, transcoder.ValidateStreams(tt.streams))
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package transcoder
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/angelorc/go-uploader/storage"
+)
+
+// GetStorageKey returns the key of an output file in the storage, below the
+// id of the job.
+func (a *Transcoder) GetStorageKey(name string) string {
+	return a.Id.Hex() + "/" + name
+}
+
+// putOutput writes an output of the job to the storage, name is relative to
+// the storage key of the job.
+func (a *Transcoder) putOutput(name string, data []byte) error {
+	return a.Storage.Put(a.GetStorageKey(name), bytes.NewReader(data))
+}
+
+// readOutput returns the content of an output of the job stored by a
+// previous stage.
+func (a *Transcoder) readOutput(name string) ([]byte, error) {
+	r, err := a.Storage.Get(a.GetStorageKey(name))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// storeRendition moves the playlist and segments written by ffmpeg in the
+// rendition directory to the storage, the directory is removed once all of
+// them are stored.
+func (a *Transcoder) storeRendition(r Rendition) error {
+	dir := a.GetRenditionDir(r)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		if err := storage.PutFile(a.Storage, a.GetStorageKey(r.Name+"/"+f.Name()), filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// countSegments returns the number of segments stored by the job.
+func (a *Transcoder) countSegments() (int, error) {
+	objects, err := a.Storage.List(a.GetStorageKey(""))
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, o := range objects {
+		if strings.HasSuffix(o.Key, ".ts") || strings.HasSuffix(o.Key, ".m4s") {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// DeleteOutputs removes the outputs stored by the job id, such as the ones
+// of a failed job. The cover stored with the upload or the tags update is
+// kept.
+func DeleteOutputs(s storage.Storage, id string) error {
+	objects, err := s.List(id + "/")
+	if err != nil {
+		return err
+	}
+
+	for _, o := range objects {
+		if o.Key == id+"/"+CoverFileName {
+			continue
+		}
+
+		if err := s.Delete(o.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
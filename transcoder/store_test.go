@@ -0,0 +1,65 @@
+package transcoder_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreRendition(t *testing.T) {
+	chdirTemp(t)
+
+	tr := newTestTranscoder(t, "track.mp3")
+	r := transcoder.Rendition{Name: "320k", Bitrate: 320, Codec: transcoder.CodecAAC}
+
+	// as written by ffmpeg
+	require.NoError(t, os.MkdirAll(tr.GetRenditionDir(r), 0755))
+	require.NoError(t, ioutil.WriteFile(tr.GetRenditionDir(r)+"segment000.ts", []byte("first segment"), 0644))
+	require.NoError(t, ioutil.WriteFile(tr.GetRenditionDir(r)+"segment001.ts", []byte("second segment"), 0644))
+	require.NoError(t, ioutil.WriteFile(tr.GetRenditionPlaylistFileName(r), []byte("#EXTM3U\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(tr.Uploader.GetTmpOriginalFileName(), []byte("original"), 0644))
+
+	require.NoError(t, tr.StoreRendition(r))
+
+	objects, err := tr.Storage.List(tr.GetStorageKey(""))
+	require.NoError(t, err)
+
+	var keys []string
+	for _, o := range objects {
+		keys = append(keys, strings.TrimPrefix(o.Key, tr.GetStorageKey("")))
+	}
+	require.Equal(t, []string{"320k/list.m3u8", "320k/segment000.ts", "320k/segment001.ts"}, keys)
+	require.Equal(t, "second segment", string(readTestOutput(t, tr, "320k/segment001.ts")))
+
+	// the rendition leaves the upload directory, the original stays
+	_, err = os.Stat(tr.GetRenditionDir(r))
+	require.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(tr.Uploader.GetTmpOriginalFileName())
+	require.NoError(t, err)
+}
+
+func TestDeleteOutputs(t *testing.T) {
+	chdirTemp(t)
+
+	tr := newTestTranscoder(t, "track.mp3")
+	writeTestRendition(t, tr, transcoder.Rendition{Name: "320k"}, "first segment")
+	putTestOutput(t, tr, transcoder.DownloadFileName, "mp3")
+	putTestOutput(t, tr, transcoder.CoverFileName, "jpeg")
+
+	other := newTestTranscoder(t, "other.mp3")
+	putTestOutput(t, other, transcoder.DownloadFileName, "mp3")
+
+	require.NoError(t, transcoder.DeleteOutputs(tr.Storage, tr.Id.Hex()))
+
+	// the cover of the upload is kept, along with the other jobs
+	objects, err := tr.Storage.List("")
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+	require.Equal(t, "jpeg", string(readTestOutput(t, tr, transcoder.CoverFileName)))
+	require.Equal(t, "mp3", string(readTestOutput(t, other, transcoder.DownloadFileName)))
+}
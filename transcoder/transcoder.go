@@ -3,67 +3,132 @@ package transcoder
 import (
 	"bytes"
 	"encoding/json"
+	"github.com/angelorc/go-uploader/models"
 	"github.com/angelorc/go-uploader/services"
+	"github.com/angelorc/go-uploader/storage"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+const (
+	masterPlaylistFileName = "master.m3u8"
+	playlistFileName       = "list.m3u8"
+	initSegmentFileName    = "init.mp4"
+)
+
 type FFProbeFormat struct {
 	ready        bool
 	StreamsCount int32   `json:"nb_streams"`
 	Format       string  `json:"format_name"`
 	Duration     float32 `json:"duration,string"`
+	// BitRate is kept as reported, ffprobe writes N/A when unknown.
+	BitRate string            `json:"bit_rate"`
+	Tags    map[string]string `json:"tags"`
 }
 
 type Transcoder struct {
 	Uploader *services.Uploader
 	Id       primitive.ObjectID
-	Format   FFProbeFormat `json:"format"`
+	Format   FFProbeFormat   `json:"format"`
+	Streams  []FFProbeStream `json:"streams"`
+	// Threads limits the threads used by ffmpeg, 0 lets ffmpeg decide.
+	Threads int
+	// Storage receives the outputs as they are produced, only the
+	// intermediates of ffmpeg are written to the upload directory.
+	Storage storage.Storage
+	// Profile defines the segments and renditions of the HLS output.
+	Profile Profile
+	// OnProgress, when set, is called with the progress of every stage.
+	OnProgress ProgressFunc
+	// Loudness is set by MeasureLoudness, then updated by TranscodeToMp3
+	// with the loudness of the outputs.
+	Loudness *models.Loudness
+	// measured is the first loudnorm pass, nil until measured.
+	measured *LoudnormReport
 }
 
 func NewTranscoder(u *services.Uploader, id primitive.ObjectID) *Transcoder {
 	return &Transcoder{
 		Uploader: u,
-		Id: id,
+		Id:       id,
 		Format: FFProbeFormat{
 			ready: false,
 		},
+		Profile: DefaultProfiles[DefaultProfileName],
 	}
 }
 
+func (a *Transcoder) GetRenditionDir(r Rendition) string {
+	return a.Uploader.GetDir() + r.Name + "/"
+}
+
+func (a *Transcoder) GetRenditionPlaylistFileName(r Rendition) string {
+	return a.GetRenditionDir(r) + playlistFileName
+}
+
+// SplitToSegments transcodes the converted file into every rendition of the
+// ladder, each one in its own directory moved to the storage once complete,
+// and writes the master playlist.
 func (a *Transcoder) SplitToSegments() error {
-	newName := a.Uploader.GetDir() + "segment%03d.ts"
-	m3u8FileName := a.Uploader.GetDir() + "list.m3u8"
+	ladder := a.Profile.Ladder
 
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i", a.Uploader.GetTmpConvertedFileName(),
-		"-ar", "48000", // sample rate
-		"-b:a", "320k", // bitrate
-		"-hls_time", "5", // 5s for each segment
-		"-hls_segment_type", "mpegts", // hls segment type: Output segment files in MPEG-2 Transport Stream format. This is compatible with all HLS versions.
-		"-hls_list_size", "0", //  If set to 0 the list file will contain all the segments
-		//"-hls_base_url", "segments/",
-		"-hls_segment_filename", newName,
-		"-vn", m3u8FileName,
-	)
+	for i, r := range ladder {
+		if err := os.MkdirAll(a.GetRenditionDir(r), 0755); err != nil {
+			return err
+		}
+
+		i := i
+		progress := func(percentage int) {
+			a.progress(StageSegment, (i*100+percentage)/len(ladder))
+		}
 
-	var ffmpegStdErr bytes.Buffer
-	cmd.Stderr = &ffmpegStdErr
+		args := []string{
+			"-i", a.Uploader.GetTmpConvertedFileName(),
+			"-threads", strconv.Itoa(a.Threads),
+			"-c:a", r.encoder(),
+			"-ar", "48000", // sample rate
+			"-b:a", strconv.Itoa(r.Bitrate) + "k", // bitrate
+			"-hls_time", "5", // 5s for each segment
+			"-hls_segment_type", a.Profile.SegmentType, // mpegts is compatible with all HLS versions, fmp4 is shared with DASH
+			"-hls_list_size", "0", //  If set to 0 the list file will contain all the segments
+			"-hls_segment_filename", a.GetRenditionDir(r) + a.Profile.segmentFileName(),
+		}
 
-	err := cmd.Run()
-	if err != nil {
-		log.Print("FFMpeg error ", err)
-		log.Print(string(ffmpegStdErr.Bytes()))
+		if a.Profile.SegmentType == SegmentTypeFMP4 {
+			// written next to the playlist, referenced by EXT-X-MAP
+			args = append(args, "-hls_fmp4_init_filename", initSegmentFileName)
+		}
+
+		if r.Codec == CodecOpus {
+			// opus in mp4 is still flagged as experimental by older ffmpeg
+			args = append(args, "-strict", "experimental")
+		}
+
+		args = append(args, "-vn", a.GetRenditionPlaylistFileName(r))
+
+		if err := a.runFFmpeg(progress, args...); err != nil {
+			return err
+		}
 
+		if err := a.storeRendition(r); err != nil {
+			return err
+		}
+	}
+
+	if err := a.WriteMasterPlaylist(); err != nil {
 		return err
 	}
 
+	if a.Profile.HasDash() {
+		return a.WriteDashManifest()
+	}
+
 	return nil
 }
 
@@ -131,7 +196,7 @@ func (a *Transcoder) GetSegments() (AudioSegments, error) {
 	var segments AudioSegments
 
 	err := filepath.Walk(a.Uploader.GetDir(), func(path string, info os.FileInfo, err error) error {
-		if strings.HasSuffix(path, ".ts") {
+		if strings.HasSuffix(path, ".ts") || strings.HasSuffix(path, ".m4s") {
 			segment := &AudioSegment{
 				Path: "./" + path,
 			}
@@ -144,23 +209,38 @@ func (a *Transcoder) GetSegments() (AudioSegments, error) {
 	return segments, err
 }
 
+// RemoveFiles removes the intermediate files of the job, the original and
+// converted audio, then the upload directory when nothing else is left in it.
 func (a *Transcoder) RemoveFiles() error {
-	if err := os.Remove(a.Uploader.GetTmpOriginalFileName()); err != nil {
+	if err := os.Remove(a.Uploader.GetTmpOriginalFileName()); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
-	if err := os.Remove(a.Uploader.GetTmpConvertedFileName()); err != nil {
+	if err := os.Remove(a.Uploader.GetTmpConvertedFileName()); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
+	// kept when not empty, e.g. with the info of a resumable upload
+	_ = os.Remove(a.Uploader.GetDir())
+
 	return nil
 }
 
+// TranscodeToMp3 converts the original to the mp3 the renditions are
+// transcoded from, normalized to the loudness of the profile when measured.
 func (a *Transcoder) TranscodeToMp3() error {
-	cmd := exec.Command(
-		"ffmpeg",
+	args := []string{
 		"-i",
 		a.Uploader.GetTmpOriginalFileName(),
+		"-threads",
+		strconv.Itoa(a.Threads),
+	}
+
+	if a.normalizes() {
+		args = append(args, "-af", a.normalizeFilter())
+	}
+
+	args = append(args,
 		"-acodec",
 		"libmp3lame",
 		"-ar",
@@ -171,17 +251,22 @@ func (a *Transcoder) TranscodeToMp3() error {
 		a.Uploader.GetTmpConvertedFileName(),
 	)
 
-	var ffmpegStdErr bytes.Buffer
-	cmd.Stderr = &ffmpegStdErr
-
-	err := cmd.Run()
+	stderr, err := a.runFFmpegStderr(
+		func(percentage int) {
+			a.progress(StageTranscode, percentage)
+		},
+		args...,
+	)
 	if err != nil {
-		log.Print("FFMpeg error ", err)
-		log.Print(string(ffmpegStdErr.Bytes()))
-
 		return err
 	}
 
+	if a.normalizes() {
+		if err := a.setNormalized(stderr); err != nil {
+			log.Warn().Err(err).Msg("cannot read the normalized loudness, the target is recorded")
+		}
+	}
+
 	_, err = ioutil.ReadFile(a.Uploader.GetTmpConvertedFileName())
 	if err != nil {
 		return err
@@ -200,6 +285,7 @@ func (a *Transcoder) ffprobe() error {
 		"-print_format",
 		"json",
 		"-show_format",
+		"-show_streams",
 	)
 
 	var (
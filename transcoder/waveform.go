@@ -0,0 +1,293 @@
+package transcoder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os/exec"
+	"strconv"
+)
+
+const (
+	// waveformDir is the directory of the waveforms, next to the renditions.
+	waveformDir = "waveform"
+	// WaveformSampleRate is the rate the audio is decoded at to compute the
+	// waveforms.
+	WaveformSampleRate = 44100
+
+	// Waveform file formats.
+	WaveformJSON = "json"
+	WaveformDat  = "dat"
+
+	// waveformVersion is the version of the audiowaveform formats, the
+	// first one has a single channel.
+	waveformVersion = 1
+	// waveformFlag8Bit flags the 8 bit data of the dat format.
+	waveformFlag8Bit = 1
+)
+
+// WaveformResolutions are the samples per pixel of the waveforms, the zoom
+// levels of the players. Every resolution is a multiple of the first one.
+var WaveformResolutions = []int{512, 1024, 2048, 4096}
+
+// Waveform is the peak data of a mono audio, in the format of audiowaveform.
+// Data holds the 8 bit minimum and maximum sample of every bucket of
+// SamplesPerPixel samples.
+type Waveform struct {
+	SampleRate      int
+	SamplesPerPixel int
+	Data            []int8
+}
+
+// waveformJSON is the JSON format of audiowaveform, read by peaks.js.
+type waveformJSON struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"`
+	Data            []int8 `json:"data"`
+}
+
+// Length returns the number of buckets.
+func (w *Waveform) Length() int {
+	return len(w.Data) / 2
+}
+
+// WriteJSON writes the waveform in the JSON format of audiowaveform.
+func (w *Waveform) WriteJSON(out io.Writer) error {
+	data := w.Data
+	if data == nil {
+		data = []int8{}
+	}
+
+	return json.NewEncoder(out).Encode(waveformJSON{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      w.SampleRate,
+		SamplesPerPixel: w.SamplesPerPixel,
+		Bits:            8,
+		Length:          w.Length(),
+		Data:            data,
+	})
+}
+
+// WriteDat writes the waveform in the binary format of audiowaveform: a
+// little endian header of the version, flags, sample rate, samples per
+// pixel and length, followed by the data.
+func (w *Waveform) WriteDat(out io.Writer) error {
+	header := []int32{
+		waveformVersion,
+		waveformFlag8Bit,
+		int32(w.SampleRate),
+		int32(w.SamplesPerPixel),
+		int32(w.Length()),
+	}
+
+	if err := binary.Write(out, binary.LittleEndian, header); err != nil {
+		return err
+	}
+
+	return binary.Write(out, binary.LittleEndian, w.Data)
+}
+
+// ValidateWaveformResolutions checks that every resolution is a multiple of
+// the first one.
+func ValidateWaveformResolutions(resolutions []int) error {
+	if len(resolutions) == 0 {
+		return fmt.Errorf("at least one waveform resolution is required")
+	}
+
+	for _, r := range resolutions {
+		if r <= 0 || r%resolutions[0] != 0 {
+			return fmt.Errorf("waveform resolution %d is not a multiple of %d", r, resolutions[0])
+		}
+	}
+
+	return nil
+}
+
+// ComputeWaveforms reads the signed 16 bit little endian mono samples of r
+// and returns the waveform of every resolution. progress, when set, is called
+// with the number of samples read.
+func ComputeWaveforms(r io.Reader, sampleRate int, resolutions []int, progress func(samples int64)) ([]*Waveform, error) {
+	if err := ValidateWaveformResolutions(resolutions); err != nil {
+		return nil, err
+	}
+
+	base := resolutions[0]
+
+	// min and max of every bucket of the first resolution
+	var peaks []int16
+	var samples int64
+
+	min, max := int16(math.MaxInt16), int16(math.MinInt16)
+	count := 0
+
+	buf := make([]byte, 64*1024)
+	rest := 0
+
+	for {
+		n, err := r.Read(buf[rest:])
+		n += rest
+
+		// an odd byte is kept for the next read
+		even := n &^ 1
+		for i := 0; i < even; i += 2 {
+			s := int16(binary.LittleEndian.Uint16(buf[i:]))
+			if s < min {
+				min = s
+			}
+			if s > max {
+				max = s
+			}
+
+			count++
+			if count == base {
+				peaks = append(peaks, min, max)
+				min, max = math.MaxInt16, math.MinInt16
+				count = 0
+			}
+		}
+
+		samples += int64(even / 2)
+		if progress != nil && even > 0 {
+			progress(samples)
+		}
+
+		rest = copy(buf, buf[even:n])
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if count > 0 {
+		peaks = append(peaks, min, max)
+	}
+
+	waveforms := make([]*Waveform, len(resolutions))
+	for i, resolution := range resolutions {
+		waveforms[i] = &Waveform{
+			SampleRate:      sampleRate,
+			SamplesPerPixel: resolution,
+			Data:            mergePeaks(peaks, resolution/base),
+		}
+	}
+
+	return waveforms, nil
+}
+
+// mergePeaks merges every n buckets of peaks and scales them to 8 bit.
+func mergePeaks(peaks []int16, n int) []int8 {
+	buckets := len(peaks) / 2
+	data := make([]int8, 0, (buckets+n-1)/n*2)
+
+	for i := 0; i < buckets; i += n {
+		min, max := peaks[2*i], peaks[2*i+1]
+		for j := i + 1; j < i+n && j < buckets; j++ {
+			if peaks[2*j] < min {
+				min = peaks[2*j]
+			}
+			if peaks[2*j+1] > max {
+				max = peaks[2*j+1]
+			}
+		}
+
+		data = append(data, int8(min>>8), int8(max>>8))
+	}
+
+	return data
+}
+
+// WaveformFileName returns the name of a waveform file, relative to the
+// storage key of the job.
+func WaveformFileName(samplesPerPixel int, format string) string {
+	return waveformDir + "/" + strconv.Itoa(samplesPerPixel) + "." + format
+}
+
+// GenerateWaveforms decodes the converted file once and stores the waveform
+// of every resolution in the JSON and dat formats, next to the renditions.
+func (a *Transcoder) GenerateWaveforms() error {
+	cmd := exec.Command(
+		"ffmpeg",
+		"-v", "error",
+		"-i", a.Uploader.GetTmpConvertedFileName(),
+		"-threads", strconv.Itoa(a.Threads),
+		"-vn",
+		"-ac", "1",
+		"-ar", strconv.Itoa(WaveformSampleRate),
+		"-f", "s16le",
+		"-acodec", "pcm_s16le",
+		"-",
+	)
+
+	var ffmpegStdErr bytes.Buffer
+	cmd.Stderr = &ffmpegStdErr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return newFFmpegError(err, nil)
+	}
+
+	var progress func(samples int64)
+	if a.OnProgress != nil {
+		if duration, err := a.GetDuration(); err == nil && duration > 0 {
+			total := float64(duration) * WaveformSampleRate
+			progress = func(samples int64) {
+				// 100 is reported once every file is written
+				if percentage := int(float64(samples) / total * 100); percentage < 100 {
+					a.progress(StageWaveform, percentage)
+				}
+			}
+		}
+	}
+
+	waveforms, computeErr := ComputeWaveforms(bufio.NewReader(stdout), WaveformSampleRate, WaveformResolutions, progress)
+
+	// drain the output, ffmpeg blocks when the pipe is full
+	_, _ = io.Copy(ioutil.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		return newFFmpegError(err, ffmpegStdErr.Bytes())
+	}
+	if computeErr != nil {
+		return computeErr
+	}
+
+	for _, w := range waveforms {
+		if err := a.putWaveform(WaveformFileName(w.SamplesPerPixel, WaveformJSON), w.WriteJSON); err != nil {
+			return err
+		}
+
+		if err := a.putWaveform(WaveformFileName(w.SamplesPerPixel, WaveformDat), w.WriteDat); err != nil {
+			return err
+		}
+	}
+
+	a.progress(StageWaveform, 100)
+
+	return nil
+}
+
+func (a *Transcoder) putWaveform(name string, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+
+	return a.putOutput(name, buf.Bytes())
+}
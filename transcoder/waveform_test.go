@@ -0,0 +1,98 @@
+package transcoder_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"testing/iotest"
+
+	"github.com/angelorc/go-uploader/transcoder"
+	"github.com/stretchr/testify/require"
+)
+
+// pcm returns the signed 16 bit little endian encoding of samples.
+func pcm(t *testing.T, samples ...int16) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, samples))
+
+	return buf.Bytes()
+}
+
+func TestComputeWaveforms(t *testing.T) {
+	data := pcm(t,
+		// 2 samples per pixel
+		256, -512,
+		32767, 0,
+		-32768, 1024,
+		// partial last bucket
+		2048,
+	)
+
+	var read []int64
+	waveforms, err := transcoder.ComputeWaveforms(bytes.NewReader(data), 8000, []int{2, 4}, func(samples int64) {
+		read = append(read, samples)
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(7), read[len(read)-1])
+	require.Len(t, waveforms, 2)
+
+	require.Equal(t, &transcoder.Waveform{
+		SampleRate:      8000,
+		SamplesPerPixel: 2,
+		Data:            []int8{-2, 1, 0, 127, -128, 4, 8, 8},
+	}, waveforms[0])
+
+	require.Equal(t, &transcoder.Waveform{
+		SampleRate:      8000,
+		SamplesPerPixel: 4,
+		Data:            []int8{-2, 127, -128, 8},
+	}, waveforms[1])
+
+	// samples split across reads
+	split, err := transcoder.ComputeWaveforms(iotest.OneByteReader(bytes.NewReader(data)), 8000, []int{2, 4}, nil)
+	require.NoError(t, err)
+	require.Equal(t, waveforms, split)
+
+	_, err = transcoder.ComputeWaveforms(bytes.NewReader(data), 8000, []int{2, 3}, nil)
+	require.Error(t, err)
+}
+
+func TestWaveformFormats(t *testing.T) {
+	w := &transcoder.Waveform{
+		SampleRate:      44100,
+		SamplesPerPixel: 512,
+		Data:            []int8{-2, 1, -128, 127},
+	}
+
+	var dat bytes.Buffer
+	require.NoError(t, w.WriteDat(&dat))
+	require.Equal(t, []byte{
+		1, 0, 0, 0, // version
+		1, 0, 0, 0, // 8 bit
+		0x44, 0xac, 0, 0, // sample rate
+		0, 2, 0, 0, // samples per pixel
+		2, 0, 0, 0, // length
+		0xfe, 1, 0x80, 0x7f,
+	}, dat.Bytes())
+
+	var out bytes.Buffer
+	require.NoError(t, w.WriteJSON(&out))
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	require.Equal(t, map[string]interface{}{
+		"version":           2.0,
+		"channels":          1.0,
+		"sample_rate":       44100.0,
+		"samples_per_pixel": 512.0,
+		"bits":              8.0,
+		"length":            2.0,
+		"data":              []interface{}{-2.0, 1.0, -128.0, 127.0},
+	}, decoded)
+}
+
+func TestWaveformResolutions(t *testing.T) {
+	require.NoError(t, transcoder.ValidateWaveformResolutions(transcoder.WaveformResolutions))
+	require.Equal(t, "waveform/512.dat", transcoder.WaveformFileName(512, transcoder.WaveformDat))
+}